@@ -1,6 +1,9 @@
 package client
 
 import (
+	"strconv"
+	"strings"
+
 	"github.com/rubixchain/rubixgoplatform/core/model"
 	"github.com/rubixchain/rubixgoplatform/setup"
 )
@@ -29,3 +32,91 @@ func (c *Client) GetAllTokens(didStr string, tokenType string) (*model.TokenResp
 	}
 	return &tr, nil
 }
+
+func (c *Client) FetchPartTokens(fr *model.FetchPartTokensRequest) (*model.FetchPartTokensResponse, error) {
+	var resp model.FetchPartTokensResponse
+	err := c.sendJSONRequest("POST", setup.APIFetchPartTokens, nil, fr, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if err := resp.AsError(); err != nil {
+		return &resp, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) MovePartTokens(mr *model.MovePartTokensRequest) (*model.BasicResponse, error) {
+	var br model.BasicResponse
+	err := c.sendJSONRequest("POST", setup.APIMovePartTokens, nil, mr, &br)
+	if err != nil {
+		return nil, err
+	}
+	return &br, nil
+}
+
+func (c *Client) VerifyPartTokens(did string) (*model.PartTokenVerificationResult, error) {
+	q := make(map[string]string)
+	q["did"] = did
+	var res model.PartTokenVerificationResult
+	err := c.sendJSONRequest("GET", setup.APIVerifyPartTokens, q, nil, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *Client) FetchPartTokensAt(did string, height uint64) (*model.FetchPartTokensResponse, error) {
+	q := make(map[string]string)
+	q["did"] = did
+	q["height"] = strconv.FormatUint(height, 10)
+	var resp model.FetchPartTokensResponse
+	err := c.sendJSONRequest("GET", setup.APIFetchPartTokensAt, q, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) FetchAllTokens(did string) (*model.FetchAllTokensResponse, error) {
+	q := make(map[string]string)
+	q["did"] = did
+	var resp model.FetchAllTokensResponse
+	err := c.sendJSONRequest("GET", setup.APIFetchAllTokens, q, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) PartTokenDenominations(did string) (*model.PartTokenDenominationsResponse, error) {
+	q := make(map[string]string)
+	q["did"] = did
+	var resp model.PartTokenDenominationsResponse
+	err := c.sendJSONRequest("GET", setup.APIPartTokenDenominations, q, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) FetchPartTokensMultiDID(dids []string) (*model.FetchPartTokensMultiDIDResponse, error) {
+	q := make(map[string]string)
+	q["dids"] = strings.Join(dids, ",")
+	var resp model.FetchPartTokensMultiDIDResponse
+	err := c.sendJSONRequest("GET", setup.APIFetchPartTokensMultiDID, q, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) ConsolidatePartTokens(did string) (*model.ConsolidationResult, error) {
+	q := make(map[string]string)
+	q["did"] = did
+	var res model.ConsolidationResult
+	err := c.sendJSONRequest("POST", setup.APIConsolidatePartTokens, q, nil, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
@@ -77,6 +77,8 @@ const (
 	GetTokenBlock                  string = "gettokenblock"
 	GetSmartContractData           string = "getsmartcontractdata"
 	ReleaseAllLockedTokensCmd      string = "releaseAllLockedTokens"
+	GetPartTokensCmd               string = "getparttokens"
+	ConvertLogCmd                  string = "convertlog"
 )
 
 var commands = []string{VersionCmd,
@@ -121,6 +123,8 @@ var commands = []string{VersionCmd,
 	DumpSmartContractTokenChainCmd,
 	GetTokenBlock,
 	GetSmartContractData,
+	GetPartTokensCmd,
+	ConvertLogCmd,
 }
 var commandsHelp = []string{"To get tool version",
 	"To get help",
@@ -163,7 +167,9 @@ var commandsHelp = []string{"To get tool version",
 	"This command will subscribe to a smart contract token",
 	"This command will dump the smartcontract token chain",
 	"This command gets token block",
-	"This command gets the smartcontract data from latest block"}
+	"This command gets the smartcontract data from latest block",
+	"This command will get the part tokens of the DID",
+	"This command will convert the binary log file to text or JSON"}
 
 type Command struct {
 	cfg                config.Config
@@ -237,6 +243,10 @@ type Command struct {
 	smartContractData  string
 	executorAddr       string
 	latest             bool
+	logFormat          string
+	watch              bool
+	watchInterval      time.Duration
+	verbose            bool
 }
 
 func showVersion() {
@@ -428,6 +438,10 @@ func Run(args []string) {
 	flag.StringVar(&cmd.smartContractData, "sctData", "data", "Smart contract execution info")
 	flag.StringVar(&cmd.executorAddr, "executorAddr", "", "Smart contract Executor Address")
 	flag.BoolVar(&cmd.latest, "latest", false, "flag to set latest")
+	flag.StringVar(&cmd.logFormat, "logFormat", "text", "Log format to convert the binary log, text or json")
+	flag.BoolVar(&cmd.watch, "watch", false, "Watch the part tokens and print the changes until interrupted")
+	flag.DurationVar(&cmd.watchInterval, "watchInterval", 5*time.Second, "Poll interval of the part tokens watch")
+	flag.BoolVar(&cmd.verbose, "verbose", false, "Print how the part tokens fetch was resolved after the result")
 
 	if len(os.Args) < 2 {
 		fmt.Println("Invalid Command")
@@ -459,8 +473,7 @@ func Run(args []string) {
 
 	level := logger.Debug
 
-	fp, err := os.OpenFile(cmd.logFile,
-		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	fp, err := logger.OpenAppendFile(cmd.logFile)
 	if err != nil {
 		panic(err)
 	}
@@ -477,10 +490,11 @@ func Run(args []string) {
 	}
 
 	logOptions := &logger.LoggerOptions{
-		Name:   "Main",
-		Level:  level,
-		Color:  []logger.ColorOption{logger.AutoColor, logger.ColorOff},
-		Output: []io.Writer{logger.DefaultOutput, fp},
+		Name:         "Main",
+		Level:        level,
+		Color:        []logger.ColorOption{logger.AutoColor, logger.ColorOff},
+		Output:       []io.Writer{logger.DefaultOutput, fp},
+		AtomicWrites: true,
 	}
 
 	cmd.log = logger.New(logOptions)
@@ -570,6 +584,10 @@ func Run(args []string) {
 		cmd.executeSmartcontract()
 	case ReleaseAllLockedTokensCmd:
 		cmd.releaseAllLockedTokens()
+	case GetPartTokensCmd:
+		cmd.GetPartTokens()
+	case ConvertLogCmd:
+		cmd.convertLog()
 	default:
 		cmd.log.Error("Invalid command")
 	}
@@ -0,0 +1,44 @@
+package command
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	"github.com/rubixchain/rubixgoplatform/wrapper/logger"
+)
+
+// convertLog will print the entries of the binary log file as text or JSON
+func (cmd *Command) convertLog() {
+	if cmd.logFormat != "text" && cmd.logFormat != "json" {
+		cmd.log.Error("Invalid log format, supported formats are text & json")
+		return
+	}
+	f, err := os.Open(cmd.file)
+	if err != nil {
+		cmd.log.Error("Failed to open the binary log file", "err", err)
+		return
+	}
+	defer f.Close()
+	out := logger.New(&logger.LoggerOptions{
+		Level:      logger.Trace,
+		JSONFormat: cmd.logFormat == "json",
+		Color:      []logger.ColorOption{logger.ColorOff},
+		Output:     []io.Writer{os.Stdout},
+	}).(logger.EntryWriter)
+	r := bufio.NewReader(f)
+	n := 0
+	for {
+		e, err := logger.DecodeBinary(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			cmd.log.Error("Failed to decode the binary log", "entry", n+1, "err", err)
+			return
+		}
+		out.WriteEntry(e)
+		n++
+	}
+	cmd.log.Info("Binary log converted successfully", "entries", n)
+}
@@ -1,5 +1,23 @@
 package command
 
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rubixchain/rubixgoplatform/core/model"
+	"github.com/rubixchain/rubixgoplatform/util"
+	"github.com/rubixchain/rubixgoplatform/wrapper/logger"
+)
+
 func (cmd *Command) GenerateTestRBT() {
 
 	br, err := cmd.c.GenerateTestRBT(cmd.numTokens, cmd.did)
@@ -22,3 +40,173 @@ func (cmd *Command) GenerateTestRBT() {
 	}
 	cmd.log.Info("Test RBT generated successfully")
 }
+
+func (cmd *Command) GetPartTokens() {
+	if _, _, err := util.ParseAddress(cmd.did); err != nil {
+		cmd.log.Error("Invalid DID address", "err", err)
+		return
+	}
+	fr := &model.FetchPartTokensRequest{
+		Address: cmd.did,
+	}
+	if cmd.watch {
+		stop := make(chan struct{})
+		sc := make(chan os.Signal, 1)
+		signal.Notify(sc, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sc)
+		go func() {
+			<-sc
+			close(stop)
+		}()
+		fetch := func() (*model.FetchPartTokensResponse, error) {
+			return cmd.c.FetchPartTokens(fr)
+		}
+		err := watchPartTokens(fetch, cmd.watchInterval, os.Stdout, stop)
+		if err != nil {
+			cmd.log.Error("Failed to watch part tokens", "err", err)
+		}
+		return
+	}
+	var trace bytes.Buffer
+	tl := logger.NewNullLogger()
+	if cmd.verbose {
+		tl = logger.New(&logger.LoggerOptions{
+			Level:      logger.Trace,
+			JSONFormat: true,
+			Color:      []logger.ColorOption{logger.ColorOff},
+			Output:     []io.Writer{&trace},
+		})
+	}
+	resp, err := fetchPartTokensTraced(cmd.c.FetchPartTokens, fr, tl)
+	if cmd.verbose {
+		defer printFetchDecision(os.Stdout, trace.Bytes())
+	}
+	if err != nil {
+		cmd.log.Error("Failed to get part tokens", "err", err)
+		return
+	}
+	b, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		cmd.log.Error("Failed to parse the response", "err", err)
+		return
+	}
+	fmt.Println(string(b))
+	cmd.log.Info("Part tokens fetched successfully")
+}
+
+// fetchPartTokensTraced fetches the part tokens and logs at Trace how the
+// address was resolved, the path taken and the time it took. The node
+// serves an address without a peerID from its own wallet, the other ones
+// are asked to the peer unless the peerID is the node itself, so the path
+// is the one reported by the node. It is guessed from the address for the
+// nodes not reporting it.
+func fetchPartTokensTraced(fetch func(*model.FetchPartTokensRequest) (*model.FetchPartTokensResponse, error), fr *model.FetchPartTokensRequest, log logger.Logger) (*model.FetchPartTokensResponse, error) {
+	peerID, did, err := util.ParseAddress(fr.Address)
+	if err != nil {
+		log.Trace("Invalid address", "address", fr.Address, "err", err)
+		return nil, err
+	}
+	log.Trace("Resolved address", "address", fr.Address, "peerID", peerID, "did", did)
+	start := time.Now()
+	resp, err := fetch(fr)
+	elapsed := time.Since(start)
+	path := "local"
+	if peerID != "" {
+		path = "peer"
+	}
+	if err == nil && resp.Path != "" {
+		path = resp.Path
+	}
+	switch {
+	case err != nil:
+		log.Trace("Fetch failed", "elapsed", elapsed.String(), "err", err)
+	case !resp.Status:
+		log.Trace("Fetch rejected", "path", path, "elapsed", elapsed.String(), "msg", resp.Message)
+	default:
+		log.Trace("Fetch done", "path", path, "elapsed", elapsed.String(), "part_tokens", len(resp.PartTokens), "total_value", resp.TotalValue)
+	}
+	return resp, err
+}
+
+// printFetchDecision prints the captured trace entries for humans, one
+// message per line followed by its fields indented
+func printFetchDecision(w io.Writer, trace []byte) {
+	fmt.Fprintln(w, "Fetch details:")
+	sc := bufio.NewScanner(bytes.NewReader(trace))
+	for sc.Scan() {
+		e, err := logger.ParseLine(sc.Bytes())
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(w, "  %s\n", e.Message)
+		for i := 0; i+1 < len(e.Args); i += 2 {
+			v := fmt.Sprintf("%v", e.Args[i+1])
+			if strings.TrimSpace(v) == "" {
+				v = "-"
+			}
+			fmt.Fprintf(w, "    %-12s %s\n", fmt.Sprintf("%v:", e.Args[i]), v)
+		}
+	}
+}
+
+// watchPartTokens polls the part tokens every interval until stop is closed,
+// the first poll is printed whole and the later ones as the changes
+func watchPartTokens(fetch func() (*model.FetchPartTokensResponse, error), interval time.Duration, w io.Writer, stop <-chan struct{}) error {
+	var prev *model.FetchPartTokensResponse
+	for {
+		resp, err := fetch()
+		if err != nil {
+			return err
+		}
+		if !resp.Status {
+			return fmt.Errorf("failed to get part tokens, %s", resp.Message)
+		}
+		if prev == nil {
+			b, err := json.MarshalIndent(resp, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w, string(b))
+		} else {
+			for _, line := range partTokensDiff(prev, resp) {
+				fmt.Fprintln(w, line)
+			}
+		}
+		prev = resp
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// partTokensDiff returns the added (+), removed (-) and changed (~) part
+// tokens between the polls, followed by the total value if it changed
+func partTokensDiff(prev *model.FetchPartTokensResponse, cur *model.FetchPartTokensResponse) []string {
+	old := make(map[string]model.PartTokenDetial, len(prev.PartTokens))
+	for _, pt := range prev.PartTokens {
+		old[pt.Token] = pt
+	}
+	diff := make([]string, 0)
+	seen := make(map[string]bool, len(cur.PartTokens))
+	for _, pt := range cur.PartTokens {
+		seen[pt.Token] = true
+		op, ok := old[pt.Token]
+		if !ok {
+			diff = append(diff, fmt.Sprintf("+ %s value=%v status=%d", pt.Token, pt.TokenValue, pt.Status))
+		} else if op.ParentToken != pt.ParentToken || op.TokenValue != pt.TokenValue || op.Status != pt.Status {
+			diff = append(diff, fmt.Sprintf("~ %s value=%v->%v status=%d->%d", pt.Token, op.TokenValue, pt.TokenValue, op.Status, pt.Status))
+		}
+	}
+	for _, pt := range prev.PartTokens {
+		if !seen[pt.Token] {
+			diff = append(diff, fmt.Sprintf("- %s value=%v status=%d", pt.Token, pt.TokenValue, pt.Status))
+		}
+	}
+	sort.Slice(diff, func(i, j int) bool { return diff[i][2:] < diff[j][2:] })
+	if prev.TotalValue != cur.TotalValue {
+		diff = append(diff, fmt.Sprintf("total_value=%v->%v", prev.TotalValue, cur.TotalValue))
+	}
+	return diff
+}
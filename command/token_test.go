@@ -0,0 +1,101 @@
+package command
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rubixchain/rubixgoplatform/core/model"
+	"github.com/rubixchain/rubixgoplatform/wrapper/logger"
+)
+
+func TestWatchPartTokens(t *testing.T) {
+	polls := []*model.FetchPartTokensResponse{
+		{
+			BasicResponse: model.BasicResponse{Status: true},
+			DID:           "did1",
+			PartTokens:    []model.PartTokenDetial{{Token: "pt1", TokenValue: 0.5}, {Token: "pt2", TokenValue: 0.25}},
+			TotalValue:    0.75,
+		},
+		{
+			BasicResponse: model.BasicResponse{Status: true},
+			DID:           "did1",
+			PartTokens:    []model.PartTokenDetial{{Token: "pt1", TokenValue: 0.5, Status: 1}, {Token: "pt3", TokenValue: 0.1}},
+			TotalValue:    0.6,
+		},
+	}
+	stop := make(chan struct{})
+	n := 0
+	fetch := func() (*model.FetchPartTokensResponse, error) {
+		resp := polls[n]
+		if n < len(polls)-1 {
+			n++
+		} else {
+			close(stop)
+		}
+		return resp, nil
+	}
+	var buf bytes.Buffer
+	if err := watchPartTokens(fetch, time.Millisecond, &buf, stop); err != nil {
+		t.Fatal("Failed to watch part tokens", err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "{") || !strings.Contains(out, `"total_value": 0.75`) {
+		t.Fatalf("Expected the first poll printed whole, got %s", out)
+	}
+	diff := "~ pt1 value=0.5->0.5 status=0->1\n- pt2 value=0.25 status=0\n+ pt3 value=0.1 status=0\ntotal_value=0.75->0.6\n"
+	if !strings.HasSuffix(out, "}\n"+diff) {
+		t.Fatalf("Invalid part token changes, got %s", out)
+	}
+}
+
+func TestFetchPartTokensVerbose(t *testing.T) {
+	var trace bytes.Buffer
+	tl := logger.New(&logger.LoggerOptions{
+		Level:      logger.Trace,
+		JSONFormat: true,
+		Color:      []logger.ColorOption{logger.ColorOff},
+		Output:     []io.Writer{&trace},
+	})
+	fetch := func(fr *model.FetchPartTokensRequest) (*model.FetchPartTokensResponse, error) {
+		return &model.FetchPartTokensResponse{
+			BasicResponse: model.BasicResponse{Status: true},
+			DID:           "did1",
+			PartTokens:    []model.PartTokenDetial{{Token: "pt1", TokenValue: 0.5}},
+			TotalValue:    0.5,
+		}, nil
+	}
+	_, err := fetchPartTokensTraced(fetch, &model.FetchPartTokensRequest{Address: "did1"}, tl)
+	if err != nil {
+		t.Fatal("Failed to fetch part tokens", err)
+	}
+	var out bytes.Buffer
+	printFetchDecision(&out, trace.Bytes())
+	s := out.String()
+	for _, want := range []string{"Resolved address", "path:        local", "did:         did1", "Fetch done", "elapsed:", "part_tokens: 1"} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("Expected %q in the verbose output, got\n%s", want, s)
+		}
+	}
+
+	// The node serves the address of its own peer from its wallet, the path
+	// is the one it reports
+	trace.Reset()
+	self := func(fr *model.FetchPartTokensRequest) (*model.FetchPartTokensResponse, error) {
+		resp, err := fetch(fr)
+		resp.Path = "local"
+		return resp, err
+	}
+	_, err = fetchPartTokensTraced(self, &model.FetchPartTokensRequest{Address: "12D3KooWSelfPeer.did1"}, tl)
+	if err != nil {
+		t.Fatal("Failed to fetch part tokens", err)
+	}
+	out.Reset()
+	printFetchDecision(&out, trace.Bytes())
+	s = out.String()
+	if !strings.Contains(s, "peerID:      12D3KooWSelfPeer") || !strings.Contains(s, "path:        local") || strings.Contains(s, "path:        peer") {
+		t.Fatalf("Expected the self peer address traced as local, got\n%s", s)
+	}
+}
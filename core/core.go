@@ -46,6 +46,7 @@ const (
 	APIGetTokenNumber         string = "/api/get-token-number"
 	APIGetMigratedTokenStatus string = "/api/get-Migrated-token-status"
 	APISyncDIDArbitration     string = "/api/sync-did-arbitration"
+	APIGetPartTokens          string = "/api/get-part-tokens"
 )
 
 const (
@@ -75,6 +76,15 @@ type Core struct {
 	cfgFile       string
 	encKey        string
 	log           logger.Logger
+	nodeLog       logger.Logger
+	nodeLogLock   sync.Mutex
+	ptc           *partTokenCache
+	pth           PartTokenHistory
+	slowRead      time.Duration
+	pbs           partTokenBalanceSubs
+	ptl           partTokenLocks
+	ptr           partTokenRequests
+	ptb           partTokenBreakers
 	peerID        string
 	lock          sync.RWMutex
 	ipfsLock      sync.RWMutex
@@ -160,6 +170,7 @@ func NewCore(cfg *config.Config, cfgFile string, encKey string, log logger.Logge
 	}
 
 	c := &Core{
+		log:           coreLogger(log),
 		cfg:           cfg,
 		cfgFile:       cfgFile,
 		encKey:        encKey,
@@ -192,8 +203,6 @@ func NewCore(cfg *config.Config, cfgFile string, encKey string, log logger.Logge
 		"12D3KooWC5fHUg2yzAHydgenodN52MYPKhpK4DKRfS8TSm3idSUV.bafybmif5qnkfnkkrffxvoofah3fjzkmieohjbgyte35rrjrn3goufaiykq",
 		"12D3KooWDd7c7DAVb38a9vfCFpqxh5nHbDQ4CYjMJuFfBgzpiagK.bafybmie4iynumz2v3obbtkqirxrejjoljjs3l76frvl43wgalqqgprze6q"}
 
-	c.log = log.Named("Core")
-
 	c.ipfsChan = make(chan bool)
 
 	if update {
@@ -257,6 +266,8 @@ func NewCore(cfg *config.Config, cfgFile string, encKey string, log logger.Logge
 		c.log.Error("Failed to setup wallet", "err", err)
 		return nil, err
 	}
+	c.ptc = newPartTokenCache(PartTokenCacheTTL)
+	c.w.SetTokenWriteHook(c.partTokensWritten)
 	c.qm, err = NewQuorumManager(c.s, c.log)
 	if err != nil {
 		c.log.Error("Failed to setup quorum manager", "err", err)
@@ -288,6 +299,43 @@ func NewCore(cfg *config.Config, cfgFile string, encKey string, log logger.Logge
 	return c, nil
 }
 
+// coreLogger will return the named core logger, a null logger is used if no
+// logger is given
+func coreLogger(log logger.Logger) logger.Logger {
+	if log == nil {
+		log = logger.NewNullLogger()
+	}
+	return log.Named("Core")
+}
+
+// Logger will return the core logger attributed with the peer ID of the node,
+// the logger is cached once the peer ID is known
+func (c *Core) Logger() logger.Logger {
+	c.nodeLogLock.Lock()
+	defer c.nodeLogLock.Unlock()
+	if c.nodeLog != nil {
+		return c.nodeLog
+	}
+	if c.peerID == "" {
+		return c.log
+	}
+	c.nodeLog = c.log.With("peer_id", c.peerID)
+	return c.nodeLog
+}
+
+// WithRequest will return the node logger with the method, path and the
+// remote address of the request
+func (c *Core) WithRequest(req *ensweb.Request) logger.Logger {
+	if req == nil {
+		return c.Logger()
+	}
+	remote := ""
+	if req.Connection != nil {
+		remote = req.Connection.RemoteAddr
+	}
+	return c.Logger().With("method", req.Method, "path", req.Path, "remote", remote)
+}
+
 func (c *Core) getCoreAppName(peerID string) string {
 	return peerID + "RubixCore"
 }
@@ -397,8 +445,18 @@ func (c *Core) StopCore() {
 	// 	c.log.Error("Failed to publish explorer model", "err", err)
 	// 	return
 	// }
+	if err := c.ShutdownPartTokens(PartTokenDrainTimeout); err != nil {
+		c.Logger().Error("Failed to shutdown part token requests", "err", err)
+	}
 	time.Sleep(time.Second)
 	c.stopIPFS()
+	// The wallet is closed once the subsystems using it have stopped
+	if c.w != nil {
+		c.Logger().Info("Closing the wallet")
+		if err := c.w.Close(); err != nil {
+			c.Logger().Error("Failed to close the wallet", "err", err)
+		}
+	}
 	if c.l != nil {
 		c.l.Shutdown()
 	}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"github.com/rubixchain/rubixgoplatform/core/model"
+)
+
+// FetchAllTokens will fetch both the part tokens and the whole tokens of the
+// local DID, with the value of each category and their total
+func (c *Core) FetchAllTokens(did string) (*model.FetchAllTokensResponse, error) {
+	done, err := c.beginPartTokenRequest()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	pr, err := c.readPartTokens(did)
+	if err != nil {
+		return nil, err
+	}
+	resp := &model.FetchAllTokensResponse{
+		BasicResponse: pr.BasicResponse,
+		DID:           did,
+		PartTokens:    pr.PartTokens,
+		WholeTokens:   make([]model.WholeTokenDetail, 0),
+	}
+	if !pr.Status {
+		return resp, nil
+	}
+	resp.PartTokensValue = pr.TotalValue
+	wt, err := c.w.ReadAllWholeTokens(did)
+	if err != nil {
+		c.Logger().Error("Failed to read whole tokens", "did", did, "err", err)
+		return nil, err
+	}
+	for _, t := range wt {
+		resp.WholeTokens = append(resp.WholeTokens, model.WholeTokenDetail{
+			Token:  t.TokenID,
+			Status: t.TokenStatus,
+		})
+		resp.WholeTokensValue = floatPrecision(resp.WholeTokensValue+t.TokenValue, MaxDecimalPlaces)
+	}
+	resp.TotalValue = floatPrecision(resp.PartTokensValue+resp.WholeTokensValue, MaxDecimalPlaces)
+	if len(resp.PartTokens) == 0 && len(resp.WholeTokens) == 0 {
+		resp.Message = NoTokensMsg
+	} else {
+		resp.Message = AllTokensFoundMsg
+	}
+	return resp, nil
+}
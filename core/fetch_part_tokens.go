@@ -0,0 +1,862 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/rubixchain/rubixgoplatform/block"
+	"github.com/rubixchain/rubixgoplatform/core/model"
+	"github.com/rubixchain/rubixgoplatform/core/wallet"
+	"github.com/rubixchain/rubixgoplatform/did"
+	"github.com/rubixchain/rubixgoplatform/rac"
+	"github.com/rubixchain/rubixgoplatform/util"
+	"github.com/rubixchain/rubixgoplatform/wrapper/ensweb"
+	"github.com/rubixchain/rubixgoplatform/wrapper/logger"
+	"github.com/rubixchain/rubixgoplatform/wrapper/uuid"
+)
+
+// ErrPartTokenDIDNotFound is returned when the DID is not hosted by the node
+var ErrPartTokenDIDNotFound = errors.New(PartTokenDIDNotFoundMsg)
+
+// ErrPartTokenSignature is returned when the signature of the part tokens
+// served by the peer does not verify
+var ErrPartTokenSignature = errors.New("part tokens signature mismatch")
+
+// ErrPartTokenSigner is returned when the part tokens are signed by a DID
+// which is not of the queried peer, or are of another DID than requested
+var ErrPartTokenSigner = errors.New("part tokens signer mismatch")
+
+// PartTokenStrategy defines how the part tokens are selected for an amount
+type PartTokenStrategy int
+
+const (
+	// PartTokenMinCount selects the fewest tokens covering the amount
+	PartTokenMinCount PartTokenStrategy = iota
+	// PartTokenMinChange selects the tokens leaving the least change
+	PartTokenMinChange
+)
+
+// Limit of the sums tracked by the min change selection, beyond it the
+// selection falls back to the min count selection
+const maxPartTokenSelectionUnits int = 1 << 22
+
+// DefaultSlowPartTokenRead is the wallet read time of the part tokens above
+// which a slow read warning is logged
+const DefaultSlowPartTokenRead time.Duration = 500 * time.Millisecond
+
+// The paths of the part tokens returned by FetchPartTokens
+const (
+	PartTokenPathLocal string = "local"
+	PartTokenPathPeer  string = "peer"
+)
+
+// The orders of the part tokens returned by FetchPartTokens
+const (
+	PartTokenSortValueAsc  string = "value_asc"
+	PartTokenSortValueDesc string = "value_desc"
+	PartTokenSortID        string = "id"
+)
+
+const (
+	PartTokenDIDNotFoundMsg string = "DID not found on this peer"
+	NoPartTokensMsg         string = "No part tokens found"
+	PartTokensFoundMsg      string = "Got all part tokens"
+	NoTokensMsg             string = "No tokens found"
+	AllTokensFoundMsg       string = "Got all tokens"
+)
+
+// FetchPartTokens will fetch the part tokens of the address, the address
+// can be a local DID or <peerID>.<DID> of the peer holding the DID. The
+// unsigned and unverified results without proofs or filters are cached for
+// PartTokenCacheTTL, the sort order is applied on a copy of the result.
+func (c *Core) FetchPartTokens(req *model.FetchPartTokensRequest) (*model.FetchPartTokensResponse, error) {
+	done, err := c.beginPartTokenRequest()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	peerID, did, err := util.ParseAddress(req.Address)
+	if err != nil {
+		c.Logger().Error("Invalid address", "address", req.Address, "err", err)
+		return nil, err
+	}
+	local := peerID == "" || peerID == c.peerID
+	key := did
+	if !local {
+		key = peerID + "." + did
+	}
+	if !req.CreatedAfter.IsZero() && !req.CreatedBefore.IsZero() && req.CreatedAfter.After(req.CreatedBefore) {
+		return nil, fmt.Errorf("invalid acquisition range, created after is later than created before")
+	}
+	if !validPartTokenSort(req.SortBy) {
+		return nil, fmt.Errorf("invalid part token sort %s", req.SortBy)
+	}
+	cacheable := !req.Signed && !req.Verified && !req.WithProofs && req.State == "" && req.CreatedAfter.IsZero() && req.CreatedBefore.IsZero()
+	if cacheable {
+		if resp := c.ptc.get(key); resp != nil {
+			return sortedPartTokens(resp, req.SortBy), nil
+		}
+	}
+	var resp *model.FetchPartTokensResponse
+	if local {
+		resp, err = c.readPartTokensWhere(did, acquiredWithin(req.CreatedAfter, req.CreatedBefore))
+		if err == nil && resp.Status {
+			err = c.filterPartTokensState(resp, req.State)
+		}
+		if err == nil && resp.Status && req.Verified {
+			c.verifyServedPartTokens(resp, c.racPartTokenValue)
+		}
+		if err == nil && resp.Status && req.WithProofs {
+			addPartTokenProofs(resp)
+		}
+		if err == nil {
+			resp.Path = PartTokenPathLocal
+		}
+	} else {
+		resp, err = c.fetchPeerPartTokens(peerID, did, req)
+		if err == nil {
+			resp.Path = PartTokenPathPeer
+		}
+	}
+	if err == nil && resp.Status && cacheable {
+		c.ptc.put(key, resp)
+	}
+	if err == nil {
+		resp = sortedPartTokens(resp, req.SortBy)
+	}
+	return resp, err
+}
+
+func validPartTokenSort(by string) bool {
+	switch by {
+	case "", PartTokenSortValueAsc, PartTokenSortValueDesc, PartTokenSortID:
+		return true
+	}
+	return false
+}
+
+// sortPartTokens will order the part tokens in place, the tokens of the same
+// value keep their order
+func sortPartTokens(pts []model.PartTokenDetial, by string) {
+	switch by {
+	case PartTokenSortValueAsc:
+		sort.SliceStable(pts, func(i, j int) bool { return pts[i].TokenValue < pts[j].TokenValue })
+	case PartTokenSortValueDesc:
+		sort.SliceStable(pts, func(i, j int) bool { return pts[i].TokenValue > pts[j].TokenValue })
+	case PartTokenSortID:
+		sort.SliceStable(pts, func(i, j int) bool { return pts[i].Token < pts[j].Token })
+	}
+}
+
+// sortedPartTokens will return a copy of the response with the part tokens
+// in the order, the response itself is returned if not sorted
+func sortedPartTokens(resp *model.FetchPartTokensResponse, by string) *model.FetchPartTokensResponse {
+	if by == "" || !resp.Status {
+		return resp
+	}
+	sr := *resp
+	sr.PartTokens = append([]model.PartTokenDetial(nil), resp.PartTokens...)
+	sortPartTokens(sr.PartTokens, by)
+	return &sr
+}
+
+// UpdatePartTokens will apply the update function on the part tokens of the
+// DID and write back the result atomically
+func (c *Core) UpdatePartTokens(did string, fn func([]wallet.Token) ([]wallet.Token, error)) error {
+	err := c.w.UpdatePartTokens(did, fn)
+	if err != nil {
+		c.Logger().Error("Failed to update part tokens", "did", did, "err", err)
+		return err
+	}
+	return nil
+}
+
+// MovePartTokens will move the part tokens between the DIDs hosted by this
+// node, the transfer blocks of the tokens are signed by the source DID
+func (c *Core) MovePartTokens(reqID string, req *model.MovePartTokensRequest) {
+	br := &model.BasicResponse{
+		Status: false,
+	}
+	dc, err := c.SetupDID(reqID, req.FromDID)
+	if err != nil {
+		br.Message = err.Error()
+	} else {
+		br = c.movePartTokens(dc, req)
+	}
+	dc2 := c.GetWebReq(reqID)
+	if dc2 == nil {
+		c.Logger().Error("Failed to get did channels")
+		return
+	}
+	dc2.OutChan <- br
+}
+
+func (c *Core) movePartTokens(dc did.DIDCrypto, req *model.MovePartTokensRequest) *model.BasicResponse {
+	br := &model.BasicResponse{
+		Status: false,
+	}
+	done, err := c.beginPartTokenRequest()
+	if err != nil {
+		br.Message = err.Error()
+		return br
+	}
+	defer done()
+	if req.FromDID == req.ToDID {
+		br.Message = "source and destination DID are same"
+		return br
+	}
+	tokenIDs, err := normalizeTokenIDs(req.TokenIDs)
+	if err != nil {
+		br.Message = err.Error()
+		return br
+	}
+	if !c.w.IsDIDExist(req.FromDID) || !c.w.IsDIDExist(req.ToDID) {
+		br.Message = PartTokenDIDNotFoundMsg
+		return br
+	}
+	txID := req.TxID
+	if txID == "" {
+		txID = uuid.New().String()
+	}
+	log := logger.WithTxID(c.Logger(), txID)
+	// The tokens locked for another transfer can not move, the lock of the
+	// transfer itself is the one with its TxID. The tokens are held by the
+	// transfer while they move, its lock is restored if they do not move.
+	pl := &c.ptl
+	pl.l.Lock()
+	pl.purge()
+	err = pl.checkUnlocked(tokenIDs, req.TxID)
+	if err != nil {
+		pl.l.Unlock()
+		log.Error("Failed to move part tokens", "from", req.FromDID, "to", req.ToDID, "err", err)
+		br.Message = "Failed to move part tokens, " + err.Error()
+		return br
+	}
+	prev := pl.hold(txID, req.FromDID, tokenIDs)
+	pl.l.Unlock()
+	moved := false
+	defer func() {
+		// The lock of the transfer is done with once its tokens moved
+		if moved {
+			prev = nil
+		}
+		pl.restore(txID, prev)
+	}()
+	blks, err := c.partTokenMoveBlocks(dc, req.FromDID, req.ToDID, tokenIDs, txID)
+	if err != nil {
+		log.Error("Failed to create the part token move blocks", "from", req.FromDID, "to", req.ToDID, "err", err)
+		br.Message = "Failed to move part tokens, " + err.Error()
+		return br
+	}
+	err = c.w.MovePartTokens(req.FromDID, req.ToDID, tokenIDs)
+	if err != nil {
+		log.Error("Failed to move part tokens", "from", req.FromDID, "to", req.ToDID, "err", err)
+		br.Message = "Failed to move part tokens, " + err.Error()
+		return br
+	}
+	for i, t := range tokenIDs {
+		err = c.w.AddTokenBlock(t, blks[i])
+		if err != nil {
+			log.Error("Failed to add the part token move block", "token", t, "err", err)
+			// The tokens without the block are moved back
+			if rerr := c.w.MovePartTokens(req.ToDID, req.FromDID, tokenIDs[i:]); rerr != nil {
+				log.Error("Failed to move back the part tokens", "from", req.ToDID, "to", req.FromDID, "err", rerr)
+			}
+			br.Message = fmt.Sprintf("Failed to move part tokens, %d of %d moved, %v", i, len(tokenIDs), err)
+			return br
+		}
+	}
+	moved = true
+	log.Debug("Part tokens moved", "from", req.FromDID, "to", req.ToDID, "count", len(tokenIDs))
+	br.Status = true
+	br.Message = "Part tokens moved successfully"
+	return br
+}
+
+// partTokenMoveBlocks will create the signed transfer blocks of the part
+// tokens from one DID to another, in the order of the tokens
+func (c *Core) partTokenMoveBlocks(dc did.DIDCrypto, fromDID string, toDID string, tokenIDs []string, txID string) ([]*block.Block, error) {
+	tt := c.TokenType(PartString)
+	blks := make([]*block.Block, 0, len(tokenIDs))
+	for _, t := range tokenIDs {
+		lb := c.w.GetLatestTokenBlock(t, tt)
+		if lb == nil {
+			return nil, fmt.Errorf("token chain of %s not found", t)
+		}
+		if owner := lb.GetOwner(); owner != fromDID {
+			return nil, fmt.Errorf("token %s is owned by %s on the token chain", t, owner)
+		}
+		tcb := &block.TokenChainBlock{
+			TransactionType: block.TokenTransferredType,
+			TokenOwner:      toDID,
+			TransInfo: &block.TransInfo{
+				SenderDID:   fromDID,
+				ReceiverDID: toDID,
+				TID:         txID,
+				Tokens: []block.TransTokens{
+					{
+						Token:     t,
+						TokenType: tt,
+					},
+				},
+				Comment: "Part token moved at : " + time.Now().String(),
+			},
+		}
+		ctcb := make(map[string]*block.Block)
+		ctcb[t] = lb
+		b := block.CreateNewBlock(ctcb, tcb)
+		if b == nil {
+			return nil, fmt.Errorf("failed to create new block")
+		}
+		err := b.UpdateSignature(dc)
+		if err != nil {
+			return nil, err
+		}
+		blks = append(blks, b)
+	}
+	return blks, nil
+}
+
+// ConsolidatePartTokens will find the parent tokens split by the DID whose
+// parts are all free and held by the DID. The parts can not be merged back
+// yet as the token chain has no merge block, so the mergeable parents are
+// reported with a cannot consolidate result and the wallet is not changed.
+func (c *Core) ConsolidatePartTokens(did string) (*model.ConsolidationResult, error) {
+	res := &model.ConsolidationResult{
+		BasicResponse: model.BasicResponse{
+			Status: false,
+		},
+		DID:       did,
+		Mergeable: make([]string, 0),
+	}
+	if !c.w.IsDIDExist(did) {
+		res.Message = PartTokenDIDNotFoundMsg
+		return res, nil
+	}
+	tkns, err := c.w.ReadAllPartTokens(did)
+	if err != nil {
+		c.Logger().Error("Failed to read part tokens", "did", did, "err", err)
+		return nil, fmt.Errorf("failed to read part tokens")
+	}
+	parts := make(map[string][]wallet.Token)
+	for _, t := range tkns {
+		if t.TokenStatus == wallet.TokenIsFree && t.ParentTokenID != "" {
+			parts[t.ParentTokenID] = append(parts[t.ParentTokenID], t)
+		}
+	}
+	for parent, pts := range parts {
+		pt, err := c.w.ReadToken(parent)
+		if err != nil || pt.DID != did || pt.TokenStatus != wallet.TokenIsBurnt {
+			continue
+		}
+		sum := 0.0
+		for _, t := range pts {
+			sum = floatPrecision(sum+t.TokenValue, MaxDecimalPlaces)
+		}
+		if sum != floatPrecision(pt.TokenValue, MaxDecimalPlaces) {
+			continue
+		}
+		res.Mergeable = append(res.Mergeable, parent)
+	}
+	if len(res.Mergeable) == 0 {
+		res.Status = true
+		res.Message = "No part tokens to consolidate"
+		return res, nil
+	}
+	sort.Strings(res.Mergeable)
+	c.Logger().Info("Part tokens can not be consolidated", "did", did, "mergeable", len(res.Mergeable))
+	res.Message = "Cannot consolidate part tokens, the token chain has no merge block"
+	return res, nil
+}
+
+// SelectPartTokensForAmount will select the free part tokens of the DID
+// covering the amount as per the strategy, the tokens locked for a pending
+// transfer are skipped and the selected ones are not locked
+func (c *Core) SelectPartTokensForAmount(did string, amount float64, strategy PartTokenStrategy) ([]wallet.Token, error) {
+	tkns, err := c.w.ReadAllPartTokens(did)
+	if err != nil {
+		c.Logger().Error("Failed to read part tokens", "did", did, "err", err)
+		return nil, err
+	}
+	ft := make([]wallet.Token, 0, len(tkns))
+	for _, t := range tkns {
+		if t.TokenStatus == wallet.TokenIsFree && !c.ptl.isLocked(t.TokenID) {
+			ft = append(ft, t)
+		}
+	}
+	return selectPartTokens(ft, amount, strategy)
+}
+
+func selectPartTokens(tkns []wallet.Token, amount float64, strategy PartTokenStrategy) ([]wallet.Token, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("invalid amount")
+	}
+	total := 0.0
+	for _, t := range tkns {
+		total = floatPrecision(total+t.TokenValue, MaxDecimalPlaces)
+	}
+	if total < amount {
+		return nil, fmt.Errorf("insufficient part token balance, available %v", total)
+	}
+	if strategy == PartTokenMinChange {
+		if st := selectMinChange(tkns, amount); st != nil {
+			return st, nil
+		}
+	}
+	return selectMinCount(tkns, amount), nil
+}
+
+// selectMinCount picks the largest tokens first, the k largest tokens have
+// the largest sum of any k tokens
+func selectMinCount(tkns []wallet.Token, amount float64) []wallet.Token {
+	st := make([]wallet.Token, len(tkns))
+	copy(st, tkns)
+	sort.SliceStable(st, func(i, j int) bool { return st[i].TokenValue > st[j].TokenValue })
+	sum := 0.0
+	for i := range st {
+		sum = floatPrecision(sum+st[i].TokenValue, MaxDecimalPlaces)
+		if sum >= amount {
+			return st[:i+1]
+		}
+	}
+	return st
+}
+
+// selectMinChange finds the subset with the smallest sum not less than the
+// amount, the sums are tracked in units of the decimal precision. The best
+// sum is below amount plus the largest token, otherwise a token could be
+// dropped. Returns nil if the sums to track exceed the limit.
+func selectMinChange(tkns []wallet.Token, amount float64) []wallet.Token {
+	unit := math.Pow(10, float64(MaxDecimalPlaces))
+	target := round(amount * unit)
+	vals := make([]int, len(tkns))
+	maxVal := 0
+	for i, t := range tkns {
+		vals[i] = round(t.TokenValue * unit)
+		if vals[i] > maxVal {
+			maxVal = vals[i]
+		}
+	}
+	bound := target + maxVal
+	if bound > maxPartTokenSelectionUnits {
+		return nil
+	}
+	// from[s] is the index of the token which first reached the sum s
+	from := make([]int, bound+1)
+	for s := range from {
+		from[s] = -1
+	}
+	for i, v := range vals {
+		for s := bound; s >= v; s-- {
+			if from[s] == -1 && (s == v || from[s-v] != -1) {
+				from[s] = i
+			}
+		}
+	}
+	for s := target; s <= bound; s++ {
+		if from[s] == -1 {
+			continue
+		}
+		st := make([]wallet.Token, 0)
+		for s > 0 {
+			i := from[s]
+			st = append(st, tkns[i])
+			s = s - vals[i]
+		}
+		return st
+	}
+	return nil
+}
+
+// StreamPartTokens will write the part tokens of the DID hosted by this node
+// to the writer as NDJSON, one PartTokenDetial per line
+func (c *Core) StreamPartTokens(did string, w io.Writer) error {
+	done, err := c.beginPartTokenRequest()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if !c.w.IsDIDExist(did) {
+		return ErrPartTokenDIDNotFound
+	}
+	enc := json.NewEncoder(w)
+	err = c.w.ForEachPartToken(did, func(t *wallet.Token) error {
+		return enc.Encode(model.PartTokenDetial{
+			Token:       t.TokenID,
+			ParentToken: t.ParentTokenID,
+			TokenValue:  t.TokenValue,
+			Status:      t.TokenStatus,
+		})
+	})
+	if err != nil {
+		c.Logger().Error("Failed to stream part tokens", "did", did, "err", err)
+		return err
+	}
+	return nil
+}
+
+// VerifyPartTokens will cross check the part tokens of the DID against the
+// token chain and the token value recorded in the RAC block of the token
+func (c *Core) VerifyPartTokens(did string) (*model.PartTokenVerificationResult, error) {
+	return c.verifyPartTokens(did, c.racPartTokenValue)
+}
+
+func (c *Core) verifyPartTokens(did string, ledgerValue func(token string) (float64, error)) (*model.PartTokenVerificationResult, error) {
+	res := &model.PartTokenVerificationResult{
+		BasicResponse: model.BasicResponse{
+			Status: false,
+		},
+		DID:           did,
+		Discrepancies: make([]model.PartTokenDiscrepancy, 0),
+	}
+	if !c.w.IsDIDExist(did) {
+		res.Message = PartTokenDIDNotFoundMsg
+		return res, nil
+	}
+	tkns, err := c.w.ReadAllPartTokens(did)
+	if err != nil {
+		c.Logger().Error("Failed to read part tokens", "did", did, "err", err)
+		return nil, fmt.Errorf("failed to read part tokens")
+	}
+	for _, t := range tkns {
+		issue := c.partTokenIssue(did, t.TokenID, t.TokenValue, ledgerValue)
+		if issue != "" {
+			res.Discrepancies = append(res.Discrepancies, model.PartTokenDiscrepancy{Token: t.TokenID, Issue: issue})
+			continue
+		}
+		res.Verified++
+	}
+	res.Status = true
+	if len(res.Discrepancies) > 0 {
+		res.Message = "Part token discrepancies found"
+	} else {
+		res.Message = "Part tokens verified"
+	}
+	return res, nil
+}
+
+// partTokenIssue will check the part token of the DID against the token
+// chain and the ledger value, it returns the issue found or empty string
+func (c *Core) partTokenIssue(did string, token string, value float64, ledgerValue func(token string) (float64, error)) string {
+	b := c.w.GetLatestTokenBlock(token, c.TokenType(PartString))
+	if b == nil {
+		return "token chain not found"
+	}
+	if owner := b.GetOwner(); owner != did {
+		return "owner mismatch, ledger owner " + owner
+	}
+	lv, err := ledgerValue(token)
+	if err != nil {
+		return "failed to get the ledger value, " + err.Error()
+	}
+	if lv = floatPrecision(lv, MaxDecimalPlaces); lv != floatPrecision(value, MaxDecimalPlaces) {
+		return fmt.Sprintf("value mismatch, ledger value %v", lv)
+	}
+	return ""
+}
+
+// verifyServedPartTokens will drop the part tokens failing the ledger check
+// from the response, the dropped tokens are reported in Omitted
+func (c *Core) verifyServedPartTokens(resp *model.FetchPartTokensResponse, ledgerValue func(token string) (float64, error)) {
+	pts := make([]model.PartTokenDetial, 0, len(resp.PartTokens))
+	resp.TotalValue = 0
+	for _, pt := range resp.PartTokens {
+		issue := c.partTokenIssue(resp.DID, pt.Token, pt.TokenValue, ledgerValue)
+		if issue != "" {
+			c.Logger().Error("Invalid part token omitted", "did", resp.DID, "token", pt.Token, "issue", issue)
+			resp.Omitted = append(resp.Omitted, model.PartTokenDiscrepancy{Token: pt.Token, Issue: issue})
+			continue
+		}
+		pts = append(pts, pt)
+		resp.TotalValue = floatPrecision(resp.TotalValue+pt.TokenValue, MaxDecimalPlaces)
+	}
+	resp.PartTokens = pts
+}
+
+// racPartTokenValue will get the value of the part token from its RAC block
+func (c *Core) racPartTokenValue(token string) (float64, error) {
+	b, err := c.getFromIPFS(token)
+	if err != nil {
+		return 0, err
+	}
+	rb, err := rac.InitRacBlock(util.StrToHex(string(b)), nil)
+	if err != nil {
+		return 0, err
+	}
+	return rb.GetRacValue(), nil
+}
+
+// SetSlowPartTokenRead sets the wallet read time of the part tokens above
+// which a slow read warning is logged, zero for DefaultSlowPartTokenRead
+func (c *Core) SetSlowPartTokenRead(d time.Duration) {
+	c.slowRead = d
+}
+
+func (c *Core) logPartTokenRead(did string, d time.Duration) {
+	log := c.Logger()
+	if log.IsDebug() {
+		log.Debug("Read part tokens from the wallet", "did", did, "duration", d)
+	}
+	threshold := c.slowRead
+	if threshold == 0 {
+		threshold = DefaultSlowPartTokenRead
+	}
+	if d > threshold {
+		log.Warn("Slow wallet read of part tokens", "did", did, "duration", d, "threshold", threshold)
+	}
+}
+
+// readPartTokens will read the part tokens of the DID hosted by this node
+func (c *Core) readPartTokens(did string) (*model.FetchPartTokensResponse, error) {
+	return c.readPartTokensWhere(did, nil)
+}
+
+// acquiredWithin returns the filter keeping the tokens acquired within the
+// range, nil if the range is open on both sides
+func acquiredWithin(after time.Time, before time.Time) func(t *wallet.Token) bool {
+	if after.IsZero() && before.IsZero() {
+		return nil
+	}
+	return func(t *wallet.Token) bool {
+		if !after.IsZero() && t.AcquiredAt.Before(after) {
+			return false
+		}
+		if !before.IsZero() && t.AcquiredAt.After(before) {
+			return false
+		}
+		return true
+	}
+}
+
+// readPartTokensWhere will read the part tokens of the DID kept by the
+// filter, all of them if the filter is nil
+func (c *Core) readPartTokensWhere(did string, keep func(t *wallet.Token) bool) (*model.FetchPartTokensResponse, error) {
+	resp := &model.FetchPartTokensResponse{
+		BasicResponse: model.BasicResponse{
+			Status: false,
+		},
+		DID:        did,
+		PartTokens: make([]model.PartTokenDetial, 0),
+	}
+	if !c.w.IsDIDExist(did) {
+		resp.Message = PartTokenDIDNotFoundMsg
+		return resp, nil
+	}
+	start := time.Now()
+	tkns, err := c.w.ReadAllPartTokens(did)
+	c.logPartTokenRead(did, time.Since(start))
+	if err != nil {
+		c.Logger().Error("Failed to read part tokens", "did", did, "err", err)
+		return nil, fmt.Errorf("failed to read part tokens")
+	}
+	resp.Status = true
+	resp.AsOf = start
+	for i := range tkns {
+		t := &tkns[i]
+		if keep != nil && !keep(t) {
+			continue
+		}
+		resp.PartTokens = append(resp.PartTokens, model.PartTokenDetial{
+			Token:       t.TokenID,
+			ParentToken: t.ParentTokenID,
+			TokenValue:  t.TokenValue,
+			Status:      t.TokenStatus,
+		})
+		resp.TotalValue = floatPrecision(resp.TotalValue+t.TokenValue, MaxDecimalPlaces)
+	}
+	if len(resp.PartTokens) == 0 {
+		resp.Message = NoPartTokensMsg
+		return resp, nil
+	}
+	resp.Message = PartTokensFoundMsg
+	return resp, nil
+}
+
+// fetchPeerPartTokens will get the part tokens from the peer, the peers
+// failing repeatedly are skipped until their circuit cooldown ends
+func (c *Core) fetchPeerPartTokens(peerID string, did string, req *model.FetchPartTokensRequest) (*model.FetchPartTokensResponse, error) {
+	if err := c.ptb.allow(peerID); err != nil {
+		c.Logger().Debug("Skipping the failing peer", "peerID", peerID, "err", err)
+		return nil, err
+	}
+	p, err := c.pm.OpenPeerConn(peerID, did, c.getCoreAppName(peerID))
+	if err != nil {
+		c.Logger().Error("Failed to open peer connection", "peerID", peerID, "err", err)
+		c.recordPeerResult(peerID, err)
+		return nil, err
+	}
+	defer p.Close()
+	q := make(map[string]string)
+	q["did"] = did
+	if req.Signed {
+		q["signed"] = "true"
+	}
+	if req.Verified {
+		q["verified"] = "true"
+	}
+	if req.WithProofs {
+		q["proofs"] = "true"
+	}
+	if req.State != "" {
+		q["state"] = req.State
+	}
+	if !req.CreatedAfter.IsZero() {
+		q["created_after"] = req.CreatedAfter.Format(time.RFC3339Nano)
+	}
+	if !req.CreatedBefore.IsZero() {
+		q["created_before"] = req.CreatedBefore.Format(time.RFC3339Nano)
+	}
+	if req.SortBy != "" {
+		q["sort_by"] = req.SortBy
+	}
+	var resp model.FetchPartTokensResponse
+	err = p.SendJSONRequest("GET", APIGetPartTokens, q, nil, &resp, false)
+	c.recordPeerResult(peerID, err)
+	if err != nil {
+		c.Logger().Error("Failed to get part tokens from the peer", "peerID", peerID, "err", err)
+		return nil, err
+	}
+	if req.Signed && resp.Status {
+		err = c.verifyPartTokensSignature(&resp, peerID, did)
+		if err != nil {
+			c.Logger().Error("Failed to verify the part tokens of the peer", "peerID", peerID, "err", err)
+			return nil, err
+		}
+	}
+	if req.WithProofs && resp.Status {
+		for i := range resp.PartTokens {
+			if !VerifyPartTokenProof(&resp.PartTokens[i], resp.MerkleRoot) {
+				c.Logger().Error("Invalid part token proof from the peer", "peerID", peerID, "token", resp.PartTokens[i].Token)
+				return nil, fmt.Errorf("invalid proof for the part token %s", resp.PartTokens[i].Token)
+			}
+		}
+	}
+	return &resp, nil
+}
+
+// partTokensHash is the hash signed for the part tokens response, it covers
+// the whole response except the signature
+func partTokensHash(resp *model.FetchPartTokensResponse) ([]byte, error) {
+	sr := *resp
+	sr.Signature = ""
+	b, err := json.Marshal(&sr)
+	if err != nil {
+		return nil, err
+	}
+	return util.CalculateHash(b, "SHA3-256"), nil
+}
+
+// signPartTokens will sign the part tokens response with the DID key
+func signPartTokens(resp *model.FetchPartTokensResponse, dc did.DIDCrypto) error {
+	resp.SignerDID = dc.GetDID()
+	h, err := partTokensHash(resp)
+	if err != nil {
+		return err
+	}
+	sig, err := dc.PvtSign(h)
+	if err != nil {
+		return err
+	}
+	resp.Signature = util.HexToStr(sig)
+	return nil
+}
+
+// verifyPartTokensSignature will verify the signature of the part tokens
+// response with the public key of the signer DID. The response must be of
+// the requested DID and the signer a DID known to be hosted by the queried
+// peer, otherwise any peer could sign forged part tokens with its own DID.
+func (c *Core) verifyPartTokensSignature(resp *model.FetchPartTokensResponse, peerID string, ownerDID string) error {
+	if resp.SignerDID == "" || resp.Signature == "" {
+		return fmt.Errorf("part tokens are not signed")
+	}
+	if resp.DID != ownerDID {
+		return fmt.Errorf("%w, part tokens of %s for %s", ErrPartTokenSigner, resp.DID, ownerDID)
+	}
+	if c.w.GetPeerID(resp.SignerDID) != peerID {
+		return fmt.Errorf("%w, signer %s is not of the peer %s", ErrPartTokenSigner, resp.SignerDID, peerID)
+	}
+	err := c.FetchDID(resp.SignerDID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the signer DID, %v", err)
+	}
+	dc := did.InitDIDQuorumc(resp.SignerDID, c.didDir, "")
+	if dc == nil {
+		return fmt.Errorf("failed to get the signer public key")
+	}
+	h, err := partTokensHash(resp)
+	if err != nil {
+		return err
+	}
+	ok, _ := dc.PvtVerify(h, util.StrToHex(resp.Signature))
+	if !ok {
+		return ErrPartTokenSignature
+	}
+	return nil
+}
+
+// signServedPartTokens will sign the part tokens served to the peer with the
+// quorum key of the node
+func (c *Core) signServedPartTokens(resp *model.FetchPartTokensResponse) error {
+	dids := make([]string, 0, len(c.qc))
+	for d := range c.qc {
+		dids = append(dids, d)
+	}
+	if len(dids) == 0 {
+		return fmt.Errorf("quorum is not setup to sign the part tokens")
+	}
+	sort.Strings(dids)
+	return signPartTokens(resp, c.qc[dids[0]])
+}
+
+// getPartTokensFromPeers will serve the part tokens of the DID to the peer,
+// only the DIDs hosted by this node are answered
+func (c *Core) getPartTokensFromPeers(req *ensweb.Request) *ensweb.Result {
+	defer logger.RecoverAndLog(c.WithRequest(req))
+	done, err := c.beginPartTokenRequest()
+	if err != nil {
+		return c.l.RenderJSON(req, &model.BasicResponse{Status: false, Message: err.Error()}, http.StatusOK)
+	}
+	defer done()
+	did := c.l.GetQuerry(req, "did")
+	var after, before time.Time
+	if v := c.l.GetQuerry(req, "created_after"); v != "" {
+		after, err = time.Parse(time.RFC3339Nano, v)
+	}
+	if v := c.l.GetQuerry(req, "created_before"); v != "" && err == nil {
+		before, err = time.Parse(time.RFC3339Nano, v)
+	}
+	var resp *model.FetchPartTokensResponse
+	if err == nil {
+		resp, err = c.readPartTokensWhere(did, acquiredWithin(after, before))
+	}
+	if err == nil && resp.Status {
+		err = c.filterPartTokensState(resp, c.l.GetQuerry(req, "state"))
+	}
+	if err == nil && resp.Status && c.l.GetQuerry(req, "verified") == "true" {
+		c.verifyServedPartTokens(resp, c.racPartTokenValue)
+	}
+	if err == nil && resp.Status && c.l.GetQuerry(req, "proofs") == "true" {
+		addPartTokenProofs(resp)
+	}
+	if by := c.l.GetQuerry(req, "sort_by"); err == nil && resp.Status && by != "" {
+		if validPartTokenSort(by) {
+			sortPartTokens(resp.PartTokens, by)
+		} else {
+			err = fmt.Errorf("invalid part token sort %s", by)
+		}
+	}
+	if err == nil && resp.Status && c.l.GetQuerry(req, "signed") == "true" {
+		err = c.signServedPartTokens(resp)
+	}
+	if err != nil {
+		c.WithRequest(req).Error("Failed to serve part tokens", "did", did, "err", err)
+		return c.l.RenderJSON(req, &model.BasicResponse{Status: false, Message: err.Error()}, http.StatusOK)
+	}
+	return c.l.RenderJSON(req, resp, http.StatusOK)
+}
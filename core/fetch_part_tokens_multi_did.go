@@ -0,0 +1,52 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rubixchain/rubixgoplatform/core/model"
+)
+
+// FetchPartTokensMultiDID will fetch the part tokens of the local DIDs, one
+// breakdown per DID in the given order along with the count and the value
+// over all of them. A DID without part tokens adds nothing to the totals, a
+// DID not hosted by the node is listed with its status false and the
+// repeated DIDs are fetched once.
+func (c *Core) FetchPartTokensMultiDID(dids []string) (*model.FetchPartTokensMultiDIDResponse, error) {
+	done, err := c.beginPartTokenRequest()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	resp := &model.FetchPartTokensMultiDIDResponse{
+		BasicResponse: model.BasicResponse{
+			Status: false,
+		},
+		DIDs: make([]model.FetchPartTokensResponse, 0, len(dids)),
+	}
+	seen := make(map[string]bool, len(dids))
+	for _, did := range dids {
+		did = strings.TrimSpace(did)
+		if did == "" || seen[did] {
+			continue
+		}
+		seen[did] = true
+		pr, err := c.readPartTokens(did)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read part tokens of %s", did)
+		}
+		resp.DIDs = append(resp.DIDs, *pr)
+		resp.TotalCount = resp.TotalCount + len(pr.PartTokens)
+		resp.TotalValue = floatPrecision(resp.TotalValue+pr.TotalValue, MaxDecimalPlaces)
+	}
+	if len(resp.DIDs) == 0 {
+		return nil, fmt.Errorf("no DID given")
+	}
+	resp.Status = true
+	if resp.TotalCount == 0 {
+		resp.Message = NoPartTokensMsg
+	} else {
+		resp.Message = PartTokensFoundMsg
+	}
+	return resp, nil
+}
@@ -0,0 +1,1694 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rubixchain/rubixgoplatform/block"
+	"github.com/rubixchain/rubixgoplatform/core/model"
+	"github.com/rubixchain/rubixgoplatform/core/storage"
+	"github.com/rubixchain/rubixgoplatform/core/wallet"
+	"github.com/rubixchain/rubixgoplatform/crypto"
+	"github.com/rubixchain/rubixgoplatform/did"
+	"github.com/rubixchain/rubixgoplatform/util"
+	"github.com/rubixchain/rubixgoplatform/wrapper/config"
+	"github.com/rubixchain/rubixgoplatform/wrapper/ensweb"
+	"github.com/rubixchain/rubixgoplatform/wrapper/logger"
+)
+
+func initTestCore(t *testing.T) *Core {
+	dir := t.TempDir() + "/"
+	log := logger.New(&logger.LoggerOptions{
+		Level:  logger.Error,
+		Color:  []logger.ColorOption{logger.ColorOff},
+		Output: []io.Writer{io.Discard},
+	})
+	s, err := storage.NewStorageDB(&config.Config{DBAddress: dir + "rubix.db", DBType: "Sqlite3"})
+	if err != nil {
+		t.Fatal("Failed to init DB", err)
+	}
+	w, err := wallet.InitWallet(s, dir, log)
+	if err != nil {
+		t.Fatal("Failed to init wallet", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return &Core{log: log, w: w, s: s, peerID: "TestPeerID"}
+}
+
+func addTestDID(t *testing.T, c *Core, did string) {
+	if err := c.s.Write(wallet.DIDStorage, &wallet.DIDType{DID: did}); err != nil {
+		t.Fatal("Failed to add DID", err)
+	}
+}
+
+// testTokenID returns a token ID in the CIDv0 format, the digits are mapped
+// to base58 characters
+func testTokenID(i int) string {
+	return "QmTestPartToken" + strings.Map(func(r rune) rune { return rune("123456789A"[r-'0']) }, fmt.Sprintf("%031d", i))
+}
+
+func addTestToken(t *testing.T, c *Core, did string, token string, value float64, status int) {
+	err := c.w.CreateToken(&wallet.Token{TokenID: token, TokenValue: value, DID: did, TokenStatus: status})
+	if err != nil {
+		t.Fatal("Failed to add token", err)
+	}
+}
+
+func TestReadPartTokens(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	addTestDID(t, c, "did2")
+	addTestToken(t, c, "did1", "pt1", 0.5, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", "pt2", 0.25, wallet.TokenIsLocked)
+	addTestToken(t, c, "did1", "wt1", 1.0, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", "pt3", 0.1, wallet.TokenIsTransferred)
+
+	resp, err := c.readPartTokens("did1")
+	if err != nil {
+		t.Fatal("Failed to read part tokens", err)
+	}
+	if !resp.Status || len(resp.PartTokens) != 2 || resp.TotalValue != 0.75 {
+		t.Fatalf("Invalid part tokens for hosted DID, %+v", resp)
+	}
+
+	resp, err = c.readPartTokens("did3")
+	if err != nil {
+		t.Fatal("Failed to read part tokens", err)
+	}
+	if resp.Status || resp.Message != PartTokenDIDNotFoundMsg {
+		t.Fatalf("Expected DID not found, got %+v", resp)
+	}
+
+	resp, err = c.readPartTokens("did2")
+	if err != nil {
+		t.Fatal("Failed to read part tokens", err)
+	}
+	if !resp.Status || resp.Message != NoPartTokensMsg || len(resp.PartTokens) != 0 {
+		t.Fatalf("Expected no part tokens, got %+v", resp)
+	}
+}
+
+func TestPartTokensLogPeerID(t *testing.T) {
+	c := initTestCore(t)
+	var buf bytes.Buffer
+	c.log = logger.New(&logger.LoggerOptions{
+		Level:  logger.Error,
+		Color:  []logger.ColorOption{logger.ColorOff},
+		Output: []io.Writer{&buf},
+	})
+	_, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: ""})
+	if err == nil {
+		t.Fatal("Expected failure for invalid address")
+	}
+	if !strings.Contains(buf.String(), "peer_id=TestPeerID") {
+		t.Fatalf("Peer ID missing in the log, %q", buf.String())
+	}
+}
+
+func TestWithRequest(t *testing.T) {
+	c := initTestCore(t)
+	var buf bytes.Buffer
+	c.log = logger.New(&logger.LoggerOptions{
+		Level:  logger.Info,
+		Color:  []logger.ColorOption{logger.ColorOff},
+		Output: []io.Writer{&buf},
+	})
+	req := &ensweb.Request{
+		Method:     "GET",
+		Path:       APIGetPartTokens,
+		Connection: &ensweb.Connection{RemoteAddr: "10.0.0.1"},
+	}
+	c.WithRequest(req).Info("request")
+	for _, f := range []string{"method=GET", "path=" + APIGetPartTokens, "remote=10.0.0.1", "peer_id=TestPeerID"} {
+		if !strings.Contains(buf.String(), f) {
+			t.Fatalf("Field %s missing in the log, %q", f, buf.String())
+		}
+	}
+	buf.Reset()
+	c.WithRequest(nil).Info("no request")
+	if !strings.Contains(buf.String(), "no request") {
+		t.Fatalf("Invalid log for nil request, %q", buf.String())
+	}
+}
+
+func TestSelectPartTokensForAmount(t *testing.T) {
+	c := initTestCore(t)
+	addTestToken(t, c, "did1", "pt1", 0.5, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", "pt2", 0.3, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", "pt3", 0.25, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", "pt4", 0.2, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", "pt5", 0.45, wallet.TokenIsLocked)
+	ids := func(tkns []wallet.Token) map[string]bool {
+		m := make(map[string]bool)
+		for _, t := range tkns {
+			m[t.TokenID] = true
+		}
+		return m
+	}
+
+	st, err := c.SelectPartTokensForAmount("did1", 0.45, PartTokenMinCount)
+	if err != nil {
+		t.Fatal("Failed to select part tokens", err)
+	}
+	if m := ids(st); len(m) != 1 || !m["pt1"] {
+		t.Fatalf("Invalid min count selection %v", m)
+	}
+
+	st, err = c.SelectPartTokensForAmount("did1", 0.45, PartTokenMinChange)
+	if err != nil {
+		t.Fatal("Failed to select part tokens", err)
+	}
+	if m := ids(st); len(m) != 2 || !m["pt3"] || !m["pt4"] {
+		t.Fatalf("Invalid min change selection %v", m)
+	}
+
+	if _, err = c.SelectPartTokensForAmount("did1", 1.5, PartTokenMinChange); err == nil {
+		t.Fatal("Expected failure for insufficient balance")
+	}
+}
+
+func TestStreamPartTokens(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	exp := make(map[string]float64)
+	for i := 0; i < wallet.PartTokenBatchSize+5; i++ {
+		id := fmt.Sprintf("pt%d", i)
+		addTestToken(t, c, "did1", id, 0.5, wallet.TokenIsFree)
+		exp[id] = 0.5
+	}
+	addTestToken(t, c, "did1", "wt1", 1.0, wallet.TokenIsFree)
+
+	var buf bytes.Buffer
+	if err := c.StreamPartTokens("did1", &buf); err != nil {
+		t.Fatal("Failed to stream part tokens", err)
+	}
+	dec := json.NewDecoder(&buf)
+	n := 0
+	for dec.More() {
+		var pt model.PartTokenDetial
+		if err := dec.Decode(&pt); err != nil {
+			t.Fatal("Failed to parse streamed token", err)
+		}
+		if v, ok := exp[pt.Token]; !ok || v != pt.TokenValue {
+			t.Fatalf("Unexpected streamed token %+v", pt)
+		}
+		delete(exp, pt.Token)
+		n++
+	}
+	if len(exp) != 0 {
+		t.Fatalf("Tokens missing in the stream, %d streamed, %d missing", n, len(exp))
+	}
+	if err := c.StreamPartTokens("did2", &buf); err != ErrPartTokenDIDNotFound {
+		t.Fatalf("Expected DID not found, got %v", err)
+	}
+}
+
+// blockedWriter blocks the first write until it is released
+type blockedWriter struct {
+	blocked chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (bw *blockedWriter) Write(p []byte) (int, error) {
+	bw.once.Do(func() {
+		close(bw.blocked)
+		<-bw.release
+	})
+	return len(p), nil
+}
+
+func TestStreamPartTokensUnlocked(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	for i := 0; i < wallet.PartTokenBatchSize+5; i++ {
+		addTestToken(t, c, "did1", testTokenID(i), 0.5, wallet.TokenIsFree)
+	}
+	bw := &blockedWriter{blocked: make(chan struct{}), release: make(chan struct{})}
+	errc := make(chan error, 1)
+	go func() { errc <- c.StreamPartTokens("did1", bw) }()
+	<-bw.blocked
+
+	// The wallet is not held by the stream blocked on the client
+	written := make(chan error, 1)
+	go func() {
+		written <- c.w.UpdatePartTokens("did1", func(pt []wallet.Token) ([]wallet.Token, error) {
+			pt[0].TokenStatus = wallet.TokenIsLocked
+			return pt[:1], nil
+		})
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal("Failed to update part tokens", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wallet write blocked by the stream")
+	}
+	close(bw.release)
+	if err := <-errc; err != nil {
+		t.Fatal("Failed to stream part tokens", err)
+	}
+}
+
+func addTestTokenBlock(t *testing.T, c *Core, token string, owner string) {
+	tcb := &block.TokenChainBlock{
+		TransactionType: block.TokenGeneratedType,
+		TokenOwner:      owner,
+		TransInfo: &block.TransInfo{
+			Tokens: []block.TransTokens{{Token: token, TokenType: c.TokenType(PartString)}},
+		},
+		GenesisBlock: &block.GenesisBlock{
+			Info: []block.GenesisTokenInfo{{Token: token, ParentID: "wt1"}},
+		},
+	}
+	b := block.CreateNewBlock(map[string]*block.Block{token: nil}, tcb)
+	if b == nil {
+		t.Fatal("Failed to create token block")
+	}
+	// The stored blocks are expected to be signed
+	if err := b.ReplaceSignature(owner, "TestSignature"); err != nil {
+		t.Fatal("Failed to sign token block", err)
+	}
+	if err := c.w.AddTokenBlock(token, b); err != nil {
+		t.Fatal("Failed to add token block", err)
+	}
+}
+
+// testDIDCrypto signs for the DID with a fixed signature
+type testDIDCrypto struct {
+	did string
+}
+
+func (d *testDIDCrypto) GetDID() string {
+	return d.did
+}
+
+func (d *testDIDCrypto) Sign(hash string) ([]byte, []byte, error) {
+	return []byte("TestSignature"), []byte("TestSignature"), nil
+}
+
+func (d *testDIDCrypto) Verify(hash string, didSig []byte, pvtSig []byte) (bool, error) {
+	return true, nil
+}
+
+func (d *testDIDCrypto) PvtSign(hash []byte) ([]byte, error) {
+	return []byte("TestSignature"), nil
+}
+
+func (d *testDIDCrypto) PvtVerify(hash []byte, sign []byte) (bool, error) {
+	return true, nil
+}
+
+func TestMovePartTokensVerify(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	addTestDID(t, c, "did2")
+	values := map[string]float64{testTokenID(1): 0.5, testTokenID(2): 0.25}
+	for id, v := range values {
+		addTestToken(t, c, "did1", id, v, wallet.TokenIsFree)
+		addTestTokenBlock(t, c, id, "did1")
+	}
+	addTestToken(t, c, "did1", testTokenID(3), 0.2, wallet.TokenIsFree)
+	ledger := func(token string) (float64, error) { return values[token], nil }
+	dc := &testDIDCrypto{did: "did1"}
+
+	// A token without a token chain can not move, nothing is moved
+	br := c.movePartTokens(dc, &model.MovePartTokensRequest{FromDID: "did1", ToDID: "did2", TokenIDs: []string{testTokenID(1), testTokenID(3)}})
+	if br.Status {
+		t.Fatal("Expected the token without a token chain not moved")
+	}
+	if b := c.w.GetLatestTokenBlock(testTokenID(1), c.TokenType(PartString)); b.GetOwner() != "did1" {
+		t.Fatal("Token chain moved on a failed move")
+	}
+
+	br = c.movePartTokens(dc, &model.MovePartTokensRequest{FromDID: "did1", ToDID: "did2", TokenIDs: []string{testTokenID(1), testTokenID(2)}})
+	if !br.Status {
+		t.Fatal("Failed to move part tokens", br.Message)
+	}
+	b := c.w.GetLatestTokenBlock(testTokenID(1), c.TokenType(PartString))
+	if b == nil || b.GetTransType() != block.TokenTransferredType || b.GetSenderDID() != "did1" || b.GetReceiverDID() != "did2" {
+		t.Fatal("Transfer block of the move not added")
+	}
+	res, err := c.verifyPartTokens("did2", ledger)
+	if err != nil {
+		t.Fatal("Failed to verify part tokens", err)
+	}
+	if res.Verified != 2 || len(res.Discrepancies) != 0 {
+		t.Fatalf("Expected the moved part tokens verified, got %+v", res)
+	}
+}
+
+func TestVerifyPartTokens(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	// The token chain keys expect the token IDs of the IPFS hash length
+	pt := func(i int) string { return fmt.Sprintf("QmTestPartToken%031d", i) }
+	values := map[string]float64{pt(1): 0.5, pt(2): 0.25, pt(3): 0.2}
+	for id, v := range values {
+		addTestToken(t, c, "did1", id, v, wallet.TokenIsFree)
+		addTestTokenBlock(t, c, id, "did1")
+	}
+	ledgerValue := func(token string) (float64, error) {
+		return values[token], nil
+	}
+
+	res, err := c.verifyPartTokens("did1", ledgerValue)
+	if err != nil {
+		t.Fatal("Failed to verify part tokens", err)
+	}
+	if !res.Status || res.Verified != 3 || len(res.Discrepancies) != 0 {
+		t.Fatalf("Expected consistent part tokens, got %+v", res)
+	}
+
+	values[pt(2)] = 0.3
+	addTestToken(t, c, "did1", pt(4), 0.1, wallet.TokenIsFree)
+	res, err = c.verifyPartTokens("did1", ledgerValue)
+	if err != nil {
+		t.Fatal("Failed to verify part tokens", err)
+	}
+	issues := make(map[string]string)
+	for _, d := range res.Discrepancies {
+		issues[d.Token] = d.Issue
+	}
+	if res.Verified != 2 || len(issues) != 2 || !strings.HasPrefix(issues[pt(2)], "value mismatch") || issues[pt(4)] != "token chain not found" {
+		t.Fatalf("Invalid discrepancies %+v", res)
+	}
+}
+
+func TestPartTokensNilLogger(t *testing.T) {
+	c := initTestCore(t)
+	c.log = coreLogger(nil)
+	addTestDID(t, c, "did1")
+	addTestToken(t, c, "did1", "pt1", 0.5, wallet.TokenIsFree)
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatal("Panic without a logger", r)
+		}
+	}()
+	if _, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: ""}); err == nil {
+		t.Fatal("Expected failure for invalid address")
+	}
+	resp, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: "did1"})
+	if err != nil || !resp.Status || len(resp.PartTokens) != 1 {
+		t.Fatalf("Failed to fetch part tokens without a logger, %+v, %v", resp, err)
+	}
+}
+
+func setupTestQuorum(t *testing.T, c *Core, signer string) {
+	c.didDir = t.TempDir() + "/"
+	pvtKey, pubKey, err := crypto.GenerateKeyPair(&crypto.CryptoConfig{Alg: crypto.ECDSAP256, Pwd: "TestPassword"})
+	if err != nil {
+		t.Fatal("Failed to generate key pair", err)
+	}
+	if err := os.MkdirAll(c.didDir+signer, os.ModePerm); err != nil {
+		t.Fatal("Failed to create DID directory", err)
+	}
+	for f, k := range map[string][]byte{did.QuorumPvtKeyFileName: pvtKey, did.QuorumPubKeyFileName: pubKey} {
+		if err := os.WriteFile(c.didDir+signer+"/"+f, k, 0644); err != nil {
+			t.Fatal("Failed to write key", err)
+		}
+	}
+	dc := did.InitDIDQuorumc(signer, c.didDir, "TestPassword")
+	if dc == nil {
+		t.Fatal("Failed to setup quorum")
+	}
+	c.qc = map[string]did.DIDCrypto{signer: dc}
+}
+
+func TestPartTokensSignature(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	addTestToken(t, c, "did1", "pt1", 0.5, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", "pt2", 0.25, wallet.TokenIsFree)
+	// served and received through JSON like between the peers
+	serve := func() *model.FetchPartTokensResponse {
+		resp, err := c.readPartTokens("did1")
+		if err != nil {
+			t.Fatal("Failed to read part tokens", err)
+		}
+		if err := c.signServedPartTokens(resp); err != nil {
+			t.Fatal("Failed to sign part tokens", err)
+		}
+		b, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatal("Failed to encode part tokens", err)
+		}
+		var rr model.FetchPartTokensResponse
+		if err := json.Unmarshal(b, &rr); err != nil {
+			t.Fatal("Failed to decode part tokens", err)
+		}
+		return &rr
+	}
+
+	if err := c.signServedPartTokens(&model.FetchPartTokensResponse{}); err == nil {
+		t.Fatal("Expected failure without quorum")
+	}
+	setupTestQuorum(t, c, "QmTestSignerDID")
+
+	resp := serve()
+	if resp.SignerDID != "QmTestSignerDID" || resp.Signature == "" {
+		t.Fatalf("Part tokens not signed, %+v", resp)
+	}
+	if err := c.w.AddDIDPeerMap("QmTestSignerDID", "peer1"); err != nil {
+		t.Fatal("Failed to map the signer to the peer", err)
+	}
+	if err := c.verifyPartTokensSignature(resp, "peer1", "did1"); err != nil {
+		t.Fatal("Failed to verify part tokens", err)
+	}
+
+	resp.PartTokens[0].TokenValue = 5
+	if err := c.verifyPartTokensSignature(resp, "peer1", "did1"); err != ErrPartTokenSignature {
+		t.Fatalf("Expected signature mismatch for tampered tokens, got %v", err)
+	}
+
+	resp = serve()
+	resp.Signature = ""
+	if err := c.verifyPartTokensSignature(resp, "peer1", "did1"); err == nil {
+		t.Fatal("Expected failure for unsigned part tokens")
+	}
+
+	// A valid signature of the part tokens by the DID of another peer, or
+	// for another DID than requested
+	resp = serve()
+	if err := c.verifyPartTokensSignature(resp, "peer2", "did1"); !errors.Is(err, ErrPartTokenSigner) {
+		t.Fatalf("Expected the signer of another peer rejected, got %v", err)
+	}
+	if err := c.verifyPartTokensSignature(resp, "peer1", "did2"); !errors.Is(err, ErrPartTokenSigner) {
+		t.Fatalf("Expected the part tokens of another DID rejected, got %v", err)
+	}
+	setupTestQuorum(t, c, "QmOtherSignerDID")
+	if err := c.w.AddDIDPeerMap("QmOtherSignerDID", "peer2"); err != nil {
+		t.Fatal("Failed to map the signer to the peer", err)
+	}
+	resp = serve()
+	if resp.SignerDID != "QmOtherSignerDID" {
+		t.Fatalf("Expected the part tokens signed by the other DID, got %s", resp.SignerDID)
+	}
+	if err := c.verifyPartTokensSignature(resp, "peer2", "did1"); err != nil {
+		t.Fatal("Failed to verify the part tokens of the other peer", err)
+	}
+	if err := c.verifyPartTokensSignature(resp, "peer1", "did1"); !errors.Is(err, ErrPartTokenSigner) {
+		t.Fatalf("Expected the valid signature of another DID rejected, got %v", err)
+	}
+}
+
+func TestPartTokenCacheInvalidation(t *testing.T) {
+	c := initTestCore(t)
+	now := time.Now()
+	c.ptc = newPartTokenCache(time.Minute)
+	c.ptc.now = func() time.Time { return now }
+	c.w.SetTokenWriteHook(c.ptc.invalidate)
+	addTestDID(t, c, "did1")
+	addTestDID(t, c, "did2")
+	addTestToken(t, c, "did1", testTokenID(1), 0.5, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", testTokenID(2), 0.25, wallet.TokenIsFree)
+	addTestTokenBlock(t, c, testTokenID(1), "did1")
+	fetch := func(did string) *model.FetchPartTokensResponse {
+		resp, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: did})
+		if err != nil {
+			t.Fatal("Failed to fetch part tokens", err)
+		}
+		return resp
+	}
+
+	if resp := fetch("did1"); len(resp.PartTokens) != 2 || c.ptc.get("did1") == nil {
+		t.Fatalf("Part tokens not cached, %+v", resp)
+	}
+	br := c.movePartTokens(&testDIDCrypto{did: "did1"}, &model.MovePartTokensRequest{FromDID: "did1", ToDID: "did2", TokenIDs: []string{testTokenID(1)}})
+	if !br.Status {
+		t.Fatal("Failed to move part tokens", br.Message)
+	}
+	if resp := fetch("did1"); len(resp.PartTokens) != 1 || resp.TotalValue != 0.25 {
+		t.Fatalf("Stale part tokens after the wallet write, %+v", resp)
+	}
+
+	// the peer entries are not evicted by the wallet writes, only expire
+	remote := &model.FetchPartTokensResponse{BasicResponse: model.BasicResponse{Status: true}, DID: "did3"}
+	c.ptc.put("PeerID.did3", remote)
+	addTestToken(t, c, "did3", "pt3", 0.1, wallet.TokenIsFree)
+	if c.ptc.get("PeerID.did3") == nil {
+		t.Fatal("Peer entry evicted by the wallet write")
+	}
+	now = now.Add(time.Minute)
+	if c.ptc.get("PeerID.did3") != nil {
+		t.Fatal("Peer entry not expired")
+	}
+
+	// A write touching unknown DIDs evicts every entry
+	fetch("did1")
+	fetch("did2")
+	c.ptc.invalidate("")
+	if c.ptc.get("did1") != nil || c.ptc.get("did2") != nil {
+		t.Fatal("Expected every entry evicted for the unknown DIDs")
+	}
+}
+
+func TestConsolidatePartTokens(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	addTestPartToken := func(token string, parent string, value float64, status int) {
+		err := c.w.CreateToken(&wallet.Token{TokenID: token, ParentTokenID: parent, TokenValue: value, DID: "did1", TokenStatus: status})
+		if err != nil {
+			t.Fatal("Failed to add token", err)
+		}
+	}
+	addTestToken(t, c, "did1", "parent1", 1, wallet.TokenIsBurnt)
+	addTestPartToken("part1", "parent1", 0.5, wallet.TokenIsFree)
+	addTestPartToken("part2", "parent1", 0.3, wallet.TokenIsFree)
+	addTestPartToken("part3", "parent1", 0.2, wallet.TokenIsFree)
+	// The parts of parent2 are not all held by the DID
+	addTestToken(t, c, "did1", "parent2", 1, wallet.TokenIsBurnt)
+	addTestPartToken("part4", "parent2", 0.6, wallet.TokenIsFree)
+	addTestPartToken("part5", "parent2", 0.3, wallet.TokenIsLocked)
+
+	res, err := c.ConsolidatePartTokens("did1")
+	if err != nil {
+		t.Fatal("Failed to consolidate part tokens", err)
+	}
+	if res.Status || len(res.Mergeable) != 1 || res.Mergeable[0] != "parent1" {
+		t.Fatalf("Expected parent1 mergeable and nothing consolidated, got %+v", res)
+	}
+	status := func(token string) int {
+		tkn, err := c.w.ReadToken(token)
+		if err != nil {
+			t.Fatal("Failed to read token", err)
+		}
+		return tkn.TokenStatus
+	}
+	// The wallet is left as is without a merge block on the token chain
+	if status("parent1") != wallet.TokenIsBurnt || status("part1") != wallet.TokenIsFree || status("part3") != wallet.TokenIsFree {
+		t.Fatal("Part tokens merged in the wallet only")
+	}
+	if status("parent2") != wallet.TokenIsBurnt || status("part4") != wallet.TokenIsFree {
+		t.Fatal("Incomplete part tokens are merged")
+	}
+
+	addTestDID(t, c, "did2")
+	res, err = c.ConsolidatePartTokens("did2")
+	if err != nil {
+		t.Fatal("Failed to consolidate part tokens", err)
+	}
+	if !res.Status || len(res.Mergeable) != 0 {
+		t.Fatalf("Expected nothing to consolidate, got %+v", res)
+	}
+}
+
+func TestVerifyServedPartTokens(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	pt := func(i int) string { return fmt.Sprintf("QmTestPartToken%031d", i) }
+	values := map[string]float64{pt(1): 0.5, pt(2): 0.25}
+	for id, v := range values {
+		addTestToken(t, c, "did1", id, v, wallet.TokenIsFree)
+		addTestTokenBlock(t, c, id, "did1")
+	}
+	ledgerValue := func(token string) (float64, error) {
+		return values[token], nil
+	}
+
+	resp, err := c.readPartTokens("did1")
+	if err != nil {
+		t.Fatal("Failed to read part tokens", err)
+	}
+	c.verifyServedPartTokens(resp, ledgerValue)
+	if len(resp.PartTokens) != 2 || resp.TotalValue != 0.75 || len(resp.Omitted) != 0 {
+		t.Fatalf("Expected all part tokens served, got %+v", resp)
+	}
+
+	// The store holds a value for the token different from the ledger
+	values[pt(2)] = 0.3
+	resp, err = c.readPartTokens("did1")
+	if err != nil {
+		t.Fatal("Failed to read part tokens", err)
+	}
+	c.verifyServedPartTokens(resp, ledgerValue)
+	if len(resp.PartTokens) != 1 || resp.PartTokens[0].Token != pt(1) || resp.TotalValue != 0.5 {
+		t.Fatalf("Expected the invalid part token omitted, got %+v", resp)
+	}
+	if len(resp.Omitted) != 1 || resp.Omitted[0].Token != pt(2) || !strings.HasPrefix(resp.Omitted[0].Issue, "value mismatch") {
+		t.Fatalf("Invalid omitted part tokens %+v", resp.Omitted)
+	}
+}
+
+type testPartTokenHistory struct {
+	tip     uint64
+	holding map[uint64][]wallet.Token
+}
+
+func (h *testPartTokenHistory) Tip(did string) (uint64, error) {
+	return h.tip, nil
+}
+
+func (h *testPartTokenHistory) PartTokensAt(did string, height uint64) ([]wallet.Token, error) {
+	var at []wallet.Token
+	var atHeight uint64
+	for n, tkns := range h.holding {
+		if n <= height && n >= atHeight {
+			at, atHeight = tkns, n
+		}
+	}
+	return at, nil
+}
+
+func TestFetchPartTokensAt(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	c.pth = &testPartTokenHistory{
+		tip: 10,
+		holding: map[uint64][]wallet.Token{
+			2: {{TokenID: "pt1", TokenValue: 0.5}},
+			7: {{TokenID: "pt1", TokenValue: 0.5}, {TokenID: "pt2", TokenValue: 0.25}},
+		},
+	}
+	tests := []struct {
+		height uint64
+		tokens int
+		total  float64
+	}{
+		{1, 0, 0},
+		{3, 1, 0.5},
+		{7, 2, 0.75},
+	}
+	for _, tt := range tests {
+		resp, err := c.FetchPartTokensAt("did1", tt.height)
+		if err != nil {
+			t.Fatal("Failed to fetch part tokens", err)
+		}
+		if !resp.Status || resp.Height != tt.height || len(resp.PartTokens) != tt.tokens || resp.TotalValue != tt.total {
+			t.Fatalf("Invalid part tokens at height %d, %+v", tt.height, resp)
+		}
+	}
+	if _, err := c.FetchPartTokensAt("did1", 11); !errors.Is(err, ErrHeightBeyondTip) {
+		t.Fatal("Expected the height beyond the tip to fail, got", err)
+	}
+}
+
+// addTestChainBlock adds the next block of the token chain of the part
+// token, the genesis block if the chain is empty
+func addTestChainBlock(t *testing.T, c *Core, token string, owner string, transType string) {
+	prev := c.w.GetLatestTokenBlock(token, c.TokenType(PartString))
+	tcb := &block.TokenChainBlock{
+		TransactionType: transType,
+		TokenOwner:      owner,
+		TransInfo: &block.TransInfo{
+			Tokens: []block.TransTokens{{Token: token, TokenType: c.TokenType(PartString)}},
+		},
+	}
+	if prev == nil {
+		tcb.GenesisBlock = &block.GenesisBlock{
+			Info: []block.GenesisTokenInfo{{Token: token, ParentID: "wt1"}},
+		}
+	}
+	b := block.CreateNewBlock(map[string]*block.Block{token: prev}, tcb)
+	if b == nil {
+		t.Fatal("Failed to create token block")
+	}
+	if err := b.ReplaceSignature(owner, "TestSignature"); err != nil {
+		t.Fatal("Failed to sign token block", err)
+	}
+	if err := c.w.AddTokenBlock(token, b); err != nil {
+		t.Fatal("Failed to add token block", err)
+	}
+}
+
+func TestTokenChainHistory(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	addTestDID(t, c, "did2")
+	tA, tB, tC := testTokenID(1), testTokenID(2), testTokenID(3)
+	addTestToken(t, c, "did1", tA, 0.5, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", tB, 0.25, wallet.TokenIsBurnt)
+	// The token moved away is no longer in the wallet records of did1
+	addTestToken(t, c, "did2", tC, 0.2, wallet.TokenIsFree)
+
+	// The blocks of the chains are interleaved, one height per block
+	addTestChainBlock(t, c, tA, "did1", block.TokenGeneratedType)   // 1
+	addTestChainBlock(t, c, tB, "did1", block.TokenGeneratedType)   // 2
+	addTestChainBlock(t, c, tA, "did2", block.TokenTransferredType) // 3
+	addTestChainBlock(t, c, tB, "did1", block.TokenBurntType)       // 4
+	addTestChainBlock(t, c, tA, "did1", block.TokenTransferredType) // 5
+	addTestChainBlock(t, c, tC, "did1", block.TokenGeneratedType)   // 6
+	addTestChainBlock(t, c, tC, "did2", block.TokenTransferredType) // 7
+
+	ph := c.partTokenHistory()
+	if tip, err := ph.Tip("did1"); err != nil || tip != 7 {
+		t.Fatalf("Expected the tip 7, got %d, %v", tip, err)
+	}
+	tests := []struct {
+		did    string
+		height uint64
+		held   []string
+	}{
+		{"did1", 0, nil},
+		{"did1", 1, []string{tA}},
+		{"did1", 2, []string{tA, tB}},
+		{"did1", 3, []string{tB}},
+		{"did1", 4, nil},
+		{"did1", 5, []string{tA}},
+		{"did1", 6, []string{tA, tC}},
+		{"did1", 7, []string{tA}},
+		{"did2", 3, []string{tA}},
+		{"did2", 5, nil},
+		{"did2", 7, []string{tC}},
+	}
+	for _, tt := range tests {
+		tkns, err := ph.PartTokensAt(tt.did, tt.height)
+		if err != nil {
+			t.Fatal("Failed to get the part tokens", err)
+		}
+		held := make([]string, 0, len(tkns))
+		for _, tk := range tkns {
+			held = append(held, tk.TokenID)
+		}
+		sort.Strings(held)
+		if len(held) != len(tt.held) || (len(held) > 0 && !reflect.DeepEqual(held, tt.held)) {
+			t.Fatalf("Expected %v held by %s at height %d, got %v", tt.held, tt.did, tt.height, held)
+		}
+	}
+
+	resp, err := c.FetchPartTokensAt("did1", 6)
+	if err != nil {
+		t.Fatal("Failed to fetch part tokens", err)
+	}
+	if len(resp.PartTokens) != 2 || resp.TotalValue != 0.7 {
+		t.Fatalf("Invalid part tokens at height 6, %+v", resp)
+	}
+	for _, pt := range resp.PartTokens {
+		if pt.Token == tC && pt.Status != wallet.TokenIsTransferred {
+			t.Fatalf("Expected the token moved away reported as transferred, got %+v", pt)
+		}
+	}
+	if _, err := c.FetchPartTokensAt("did1", 8); !errors.Is(err, ErrHeightBeyondTip) {
+		t.Fatal("Expected the height beyond the tip to fail, got", err)
+	}
+}
+
+func TestFetchPartTokensQuorum(t *testing.T) {
+	c := initTestCore(t)
+	good := &model.FetchPartTokensResponse{
+		BasicResponse: model.BasicResponse{Status: true},
+		DID:           "did1",
+		PartTokens:    []model.PartTokenDetial{{Token: "pt1", TokenValue: 0.5}, {Token: "pt2", TokenValue: 0.25}},
+		TotalValue:    0.75,
+	}
+	// Same part tokens in another order
+	reordered := &model.FetchPartTokensResponse{
+		BasicResponse: model.BasicResponse{Status: true},
+		DID:           "did1",
+		PartTokens:    []model.PartTokenDetial{{Token: "pt2", TokenValue: 0.25}, {Token: "pt1", TokenValue: 0.5}},
+		TotalValue:    0.75,
+	}
+	bad := &model.FetchPartTokensResponse{
+		BasicResponse: model.BasicResponse{Status: true},
+		DID:           "did1",
+		PartTokens:    []model.PartTokenDetial{{Token: "pt1", TokenValue: 0.9}},
+		TotalValue:    0.9,
+	}
+	fetch := func(peers map[string]*model.FetchPartTokensResponse) func(string) (*model.FetchPartTokensResponse, error) {
+		return func(peerID string) (*model.FetchPartTokensResponse, error) {
+			if resp := peers[peerID]; resp != nil {
+				return resp, nil
+			}
+			return nil, fmt.Errorf("peer not reachable")
+		}
+	}
+	peers := []string{"peer1", "peer2", "peer3"}
+
+	res, err := c.fetchPartTokensQuorum("did1", peers, 2, fetch(map[string]*model.FetchPartTokensResponse{"peer1": good, "peer2": reordered, "peer3": good}))
+	if err != nil {
+		t.Fatal("Failed to fetch part tokens with quorum", err)
+	}
+	if len(res.Agreed) != 3 || len(res.Disagreed) != 0 || res.TotalValue != 0.75 {
+		t.Fatalf("Expected all the peers to agree, got %+v", res)
+	}
+
+	res, err = c.fetchPartTokensQuorum("did1", peers, 2, fetch(map[string]*model.FetchPartTokensResponse{"peer1": good, "peer2": bad, "peer3": good}))
+	if err != nil {
+		t.Fatal("Failed to fetch part tokens with quorum", err)
+	}
+	if len(res.Agreed) != 2 || len(res.Disagreed) != 1 || res.Disagreed[0] != "peer2" || res.TotalValue != 0.75 {
+		t.Fatalf("Expected the minority peer flagged, got %+v", res)
+	}
+
+	_, err = c.fetchPartTokensQuorum("did1", peers, 2, fetch(map[string]*model.FetchPartTokensResponse{"peer1": good, "peer2": bad}))
+	if !errors.Is(err, ErrPartTokenQuorum) {
+		t.Fatal("Expected the quorum not to be reached, got", err)
+	}
+
+	// The fetch in progress holds up the shutdown
+	inflight := func(string) (*model.FetchPartTokensResponse, error) {
+		c.ptr.l.Lock()
+		n := c.ptr.inflight
+		c.ptr.l.Unlock()
+		if n != 1 {
+			return nil, fmt.Errorf("expected 1 request in flight, got %d", n)
+		}
+		return good, nil
+	}
+	if _, err := c.fetchPartTokensQuorum("did1", peers, 3, inflight); err != nil {
+		t.Fatal("Expected the quorum fetch registered as in flight, got", err)
+	}
+	if err := c.ShutdownPartTokens(time.Second); err != nil {
+		t.Fatal("Failed to shutdown part tokens", err)
+	}
+	if _, err := c.fetchPartTokensQuorum("did1", peers, 2, fetch(map[string]*model.FetchPartTokensResponse{"peer1": good, "peer2": good})); !errors.Is(err, ErrPartTokensShutdown) {
+		t.Fatalf("Expected %v after shutdown, got %v", ErrPartTokensShutdown, err)
+	}
+}
+
+// slowStorage delays the reads of the part tokens
+type slowStorage struct {
+	storage.Storage
+	delay time.Duration
+}
+
+func (s *slowStorage) Read(storageName string, value interface{}, query string, queryValue ...interface{}) error {
+	time.Sleep(s.delay)
+	return s.Storage.Read(storageName, value, query, queryValue...)
+}
+
+func TestSlowPartTokenRead(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	addTestToken(t, c, "did1", "pt1", 0.5, wallet.TokenIsFree)
+	var buf bytes.Buffer
+	log := logger.New(&logger.LoggerOptions{
+		Level:  logger.Debug,
+		Color:  []logger.ColorOption{logger.ColorOff},
+		Output: []io.Writer{&buf},
+	})
+	w, err := wallet.InitWallet(&slowStorage{Storage: c.s, delay: 20 * time.Millisecond}, t.TempDir()+"/", log)
+	if err != nil {
+		t.Fatal("Failed to init wallet", err)
+	}
+	c.w, c.log = w, log
+	c.SetSlowPartTokenRead(10 * time.Millisecond)
+
+	if _, err := c.readPartTokens("did1"); err != nil {
+		t.Fatal("Failed to read part tokens", err)
+	}
+	if !strings.Contains(buf.String(), "Read part tokens from the wallet") || !strings.Contains(buf.String(), "Slow wallet read of part tokens") {
+		t.Fatalf("Expected the slow read logged, got %s", buf.String())
+	}
+
+	buf.Reset()
+	c.SetSlowPartTokenRead(time.Minute)
+	if _, err := c.readPartTokens("did1"); err != nil {
+		t.Fatal("Failed to read part tokens", err)
+	}
+	if strings.Contains(buf.String(), "Slow wallet read") {
+		t.Fatalf("Unexpected slow read warning %s", buf.String())
+	}
+}
+
+func TestSubscribePartTokenBalance(t *testing.T) {
+	c := initTestCore(t)
+	c.w.SetTokenWriteHook(c.partTokensWritten)
+	addTestDID(t, c, "did1")
+	addTestToken(t, c, "did1", "pt1", 0.5, wallet.TokenIsFree)
+
+	ch, unsubscribe := c.SubscribePartTokenBalance("did1")
+	addTestToken(t, c, "did1", "pt2", 0.25, wallet.TokenIsFree)
+	select {
+	case bal := <-ch:
+		if bal != 0.75 {
+			t.Fatalf("Invalid part token balance %v", bal)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Part token balance change not published")
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-ch; ok {
+		t.Fatal("Expected the channel closed on unsubscribe")
+	}
+	if len(c.pbs.subs) != 0 || len(c.pbs.last) != 0 {
+		t.Fatal("Subscription not cleaned up")
+	}
+	addTestToken(t, c, "did1", "pt3", 0.1, wallet.TokenIsFree)
+}
+
+func TestEstimateTransferFeasibility(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	addTestToken(t, c, "did1", "pt1", 0.5, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", "pt2", 0.25, wallet.TokenIsLocked)
+	// A free token locked for a pending transfer is not available
+	addTestToken(t, c, "did1", testTokenID(4), 0.2, wallet.TokenIsFree)
+	if _, err := c.LockPartTokens("did1", []string{testTokenID(4)}, time.Minute); err != nil {
+		t.Fatal("Failed to lock part tokens", err)
+	}
+	peers := map[string]*model.FetchPartTokensResponse{
+		"peer1.did2": {
+			BasicResponse: model.BasicResponse{Status: true},
+			DID:           "did2",
+			PartTokens:    []model.PartTokenDetial{{Token: "pt3", TokenValue: 0.3, Status: wallet.TokenIsFree}},
+		},
+	}
+	fetch := func(req *model.FetchPartTokensRequest) (*model.FetchPartTokensResponse, error) {
+		if req.State != PartTokenStateFree {
+			return nil, fmt.Errorf("part tokens fetched without the free state")
+		}
+		if !strings.Contains(req.Address, ".") {
+			return c.FetchPartTokens(req)
+		}
+		if resp, ok := peers[req.Address]; ok {
+			return resp, nil
+		}
+		return nil, fmt.Errorf("peer not reachable")
+	}
+	tests := []struct {
+		target    float64
+		addresses []string
+		feasible  bool
+		available float64
+		shortfall float64
+		failed    int
+	}{
+		{0.7, []string{"did1", "peer1.did2"}, true, 0.8, 0, 0},
+		{1, []string{"did1", "peer1.did2"}, false, 0.8, 0.2, 0},
+		{0.7, []string{"did1", "peer1.did2", "peer2.did3"}, true, 0.8, 0, 1},
+		{0.7, []string{"did1", "peer2.did3"}, false, 0.5, 0.2, 1},
+	}
+	for i, tt := range tests {
+		res, err := c.estimateTransferFeasibility(tt.target, tt.addresses, fetch)
+		if err != nil {
+			t.Fatal("Failed to estimate the transfer", err)
+		}
+		if res.Feasible != tt.feasible || res.Available != tt.available || res.Shortfall != tt.shortfall || len(res.Failed) != tt.failed {
+			t.Fatalf("Invalid estimate for case %d, %+v", i, res)
+		}
+	}
+}
+
+func TestPartTokenProofs(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	for i := 1; i <= 5; i++ {
+		addTestToken(t, c, "did1", fmt.Sprintf("QmTestPartToken%031d", i), 0.1*float64(i), wallet.TokenIsFree)
+	}
+	resp, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: "did1", WithProofs: true})
+	if err != nil {
+		t.Fatal("Failed to fetch part tokens", err)
+	}
+	if len(resp.PartTokens) != 5 {
+		t.Fatalf("Expected 5 part tokens, got %d", len(resp.PartTokens))
+	}
+
+	// Compute the root over the sorted leaves, the unpaired node moves up
+	level := make([][]byte, 0)
+	for i := 1; i <= 5; i++ {
+		for j := range resp.PartTokens {
+			if resp.PartTokens[j].Token == fmt.Sprintf("QmTestPartToken%031d", i) {
+				level = append(level, partTokenLeaf(&resp.PartTokens[j]))
+			}
+		}
+	}
+	for len(level) > 1 {
+		next := make([][]byte, 0)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, merkleHash(merkleNodePrefix, level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		level = next
+	}
+	root := util.HexToStr(level[0])
+	if resp.MerkleRoot != root {
+		t.Fatalf("Expected Merkle root %s, got %s", root, resp.MerkleRoot)
+	}
+	for i := range resp.PartTokens {
+		if !VerifyPartTokenProof(&resp.PartTokens[i], root) {
+			t.Fatalf("Proof of the part token %s does not verify", resp.PartTokens[i].Token)
+		}
+	}
+
+	// A changed value or another root must not verify
+	pt := resp.PartTokens[2]
+	pt.TokenValue = 1
+	if VerifyPartTokenProof(&pt, root) {
+		t.Fatal("Expected the proof of the changed part token to fail")
+	}
+	if VerifyPartTokenProof(&resp.PartTokens[0], util.HexToStr(merkleHash(merkleLeafPrefix, []byte("root")))) {
+		t.Fatal("Expected the proof to fail against another root")
+	}
+
+	// The proofs are not returned unless requested
+	resp, err = c.FetchPartTokens(&model.FetchPartTokensRequest{Address: "did1"})
+	if err != nil {
+		t.Fatal("Failed to fetch part tokens", err)
+	}
+	if resp.MerkleRoot != "" || resp.PartTokens[0].Proof != nil {
+		t.Fatal("Expected no proofs in the response")
+	}
+}
+
+func TestLockPartTokens(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	pt := testTokenID
+	for i := 1; i <= 3; i++ {
+		addTestToken(t, c, "did1", pt(i), 0.25, wallet.TokenIsFree)
+	}
+	addTestToken(t, c, "did1", pt(4), 0.25, wallet.TokenIsPledged)
+	now := time.Now()
+	c.ptl.now = func() time.Time { return now }
+	free := func() []string {
+		resp, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: "did1", State: PartTokenStateFree})
+		if err != nil {
+			t.Fatal("Failed to fetch part tokens", err)
+		}
+		ids := make([]string, 0)
+		for _, p := range resp.PartTokens {
+			ids = append(ids, p.Token)
+		}
+		return ids
+	}
+	if ids := free(); len(ids) != 3 {
+		t.Fatalf("Expected 3 free part tokens, got %v", ids)
+	}
+
+	id, err := c.LockPartTokens("did1", []string{pt(1), pt(2)}, time.Minute)
+	if err != nil {
+		t.Fatal("Failed to lock part tokens", err)
+	}
+	if ids := free(); len(ids) != 1 || ids[0] != pt(3) {
+		t.Fatalf("Expected the locked part tokens excluded, got %v", ids)
+	}
+	// All the part tokens are still listed without the filter
+	resp, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: "did1"})
+	if err != nil || len(resp.PartTokens) != 4 {
+		t.Fatalf("Expected all the part tokens, got %v, %v", resp, err)
+	}
+
+	// Conflicting lock, nothing is locked
+	if _, err := c.LockPartTokens("did1", []string{pt(3), pt(2)}, time.Minute); !errors.Is(err, ErrPartTokenLocked) {
+		t.Fatalf("Expected ErrPartTokenLocked, got %v", err)
+	}
+	if ids := free(); len(ids) != 1 {
+		t.Fatalf("Expected the failed lock to reserve nothing, got %v", ids)
+	}
+	if _, err := c.LockPartTokens("did1", []string{pt(4)}, time.Minute); err == nil {
+		t.Fatal("Expected the pledged part token not to be locked")
+	}
+
+	// Explicit release
+	if err := c.ReleasePartTokens(id); err != nil {
+		t.Fatal("Failed to release part tokens", err)
+	}
+	if err := c.ReleasePartTokens(id); !errors.Is(err, ErrPartTokenLockNotFound) {
+		t.Fatalf("Expected ErrPartTokenLockNotFound, got %v", err)
+	}
+	if ids := free(); len(ids) != 3 {
+		t.Fatalf("Expected the released part tokens free, got %v", ids)
+	}
+
+	// Auto expiry
+	if _, err := c.LockPartTokens("did1", []string{pt(3)}, time.Second); err != nil {
+		t.Fatal("Failed to lock part tokens", err)
+	}
+	if ids := free(); len(ids) != 2 {
+		t.Fatalf("Expected the locked part token excluded, got %v", ids)
+	}
+	now = now.Add(time.Second)
+	if ids := free(); len(ids) != 3 {
+		t.Fatalf("Expected the expired lock released, got %v", ids)
+	}
+	if _, err := c.LockPartTokens("did1", []string{pt(3)}, time.Second); err != nil {
+		t.Fatal("Failed to lock the part token after the expiry", err)
+	}
+}
+
+func TestPartTokenLocksEnforced(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	addTestDID(t, c, "did2")
+	pt := testTokenID
+	addTestToken(t, c, "did1", pt(1), 0.5, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", pt(2), 0.25, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", pt(3), 0.2, wallet.TokenIsFree)
+	addTestTokenBlock(t, c, pt(1), "did1")
+	addTestTokenBlock(t, c, pt(2), "did1")
+	dc := &testDIDCrypto{did: "did1"}
+	id, err := c.LockPartTokens("did1", []string{pt(1)}, time.Minute)
+	if err != nil {
+		t.Fatal("Failed to lock part tokens", err)
+	}
+
+	// The selection skips the locked token
+	st, err := c.SelectPartTokensForAmount("did1", 0.4, PartTokenMinCount)
+	if err != nil {
+		t.Fatal("Failed to select part tokens", err)
+	}
+	for _, tk := range st {
+		if tk.TokenID == pt(1) {
+			t.Fatalf("Expected the locked part token skipped, got %v", st)
+		}
+	}
+	if _, err := c.SelectPartTokensForAmount("did1", 0.5, PartTokenMinCount); err == nil {
+		t.Fatal("Expected the locked value not to count in the balance")
+	}
+
+	// Only the transfer holding the lock moves the token
+	br := c.movePartTokens(dc, &model.MovePartTokensRequest{FromDID: "did1", ToDID: "did2", TokenIDs: []string{pt(1), pt(2)}})
+	if br.Status || !strings.Contains(br.Message, ErrPartTokenLocked.Error()) {
+		t.Fatalf("Expected the locked part token not moved, got %+v", br)
+	}
+	br = c.movePartTokens(dc, &model.MovePartTokensRequest{FromDID: "did1", ToDID: "did2", TokenIDs: []string{pt(1)}, TxID: id})
+	if !br.Status {
+		t.Fatalf("Expected the lock holder to move the part token, got %+v", br)
+	}
+	if err := c.ReleasePartTokens(id); !errors.Is(err, ErrPartTokenLockNotFound) {
+		t.Fatalf("Expected the lock released by the move, got %v", err)
+	}
+
+	// Concurrent lockers of the same token, a single one wins
+	var wg sync.WaitGroup
+	var l sync.Mutex
+	won := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.LockPartTokens("did1", []string{pt(3)}, time.Minute); err == nil {
+				l.Lock()
+				won++
+				l.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if won != 1 {
+		t.Fatalf("Expected a single lock of the part token, got %d", won)
+	}
+}
+
+// blockedDIDCrypto blocks the first signature until it is released
+type blockedDIDCrypto struct {
+	testDIDCrypto
+	blocked chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (d *blockedDIDCrypto) PvtSign(hash []byte) ([]byte, error) {
+	d.once.Do(func() {
+		close(d.blocked)
+		<-d.release
+	})
+	return d.testDIDCrypto.PvtSign(hash)
+}
+
+func TestMovePartTokensUnlocked(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	addTestDID(t, c, "did2")
+	pt := testTokenID
+	for i := 1; i <= 2; i++ {
+		addTestToken(t, c, "did1", pt(i), 0.5, wallet.TokenIsFree)
+		addTestTokenBlock(t, c, pt(i), "did1")
+	}
+	id, err := c.LockPartTokens("did1", []string{pt(1)}, time.Minute)
+	if err != nil {
+		t.Fatal("Failed to lock part tokens", err)
+	}
+	dc := &blockedDIDCrypto{testDIDCrypto: testDIDCrypto{did: "did1"}, blocked: make(chan struct{}), release: make(chan struct{})}
+	brc := make(chan *model.BasicResponse, 1)
+	go func() {
+		brc <- c.movePartTokens(dc, &model.MovePartTokensRequest{FromDID: "did1", ToDID: "did2", TokenIDs: []string{pt(1)}, TxID: id})
+	}()
+	<-dc.blocked
+
+	// The locks are not held by the move blocked on the signature, the
+	// moving token stays locked
+	locked := make(chan error, 2)
+	go func() {
+		_, err := c.LockPartTokens("did1", []string{pt(2)}, time.Minute)
+		locked <- err
+		_, err = c.LockPartTokens("did1", []string{pt(1)}, time.Minute)
+		locked <- err
+	}()
+	for i, want := range []error{nil, ErrPartTokenLocked} {
+		select {
+		case err := <-locked:
+			if !errors.Is(err, want) {
+				t.Fatalf("Invalid lock %d during the move, %v", i, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Part token lock blocked by the move")
+		}
+	}
+	close(dc.release)
+	if br := <-brc; !br.Status {
+		t.Fatal("Failed to move part tokens", br.Message)
+	}
+	if c.ptl.isLocked(pt(1)) {
+		t.Fatal("Expected the moved part token unlocked")
+	}
+
+	// The lock of a transfer which fails to move is kept
+	addTestToken(t, c, "did1", pt(3), 0.5, wallet.TokenIsFree)
+	id, err = c.LockPartTokens("did1", []string{pt(3)}, time.Minute)
+	if err != nil {
+		t.Fatal("Failed to lock part tokens", err)
+	}
+	if br := c.movePartTokens(&testDIDCrypto{did: "did1"}, &model.MovePartTokensRequest{FromDID: "did1", ToDID: "did2", TokenIDs: []string{pt(3)}, TxID: id}); br.Status {
+		t.Fatal("Expected the token without a token chain not moved")
+	}
+	if err := c.ReleasePartTokens(id); err != nil {
+		t.Fatal("Expected the lock kept on a failed move", err)
+	}
+}
+
+func TestFetchAllTokens(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "partonly")
+	addTestDID(t, c, "wholeonly")
+	addTestDID(t, c, "both")
+	addTestToken(t, c, "partonly", "pt1", 0.5, wallet.TokenIsFree)
+	addTestToken(t, c, "partonly", "pt2", 0.25, wallet.TokenIsPledged)
+	addTestToken(t, c, "wholeonly", "wt1", 1, wallet.TokenIsFree)
+	addTestToken(t, c, "wholeonly", "wt2", 1, wallet.TokenIsLocked)
+	addTestToken(t, c, "wholeonly", "wt3", 1, wallet.TokenIsTransferred)
+	addTestToken(t, c, "both", "pt3", 0.4, wallet.TokenIsFree)
+	addTestToken(t, c, "both", "wt4", 1, wallet.TokenIsFree)
+
+	tests := []struct {
+		did   string
+		parts int
+		whole int
+		pv    float64
+		wv    float64
+		total float64
+	}{
+		{"partonly", 2, 0, 0.75, 0, 0.75},
+		{"wholeonly", 0, 2, 0, 2, 2},
+		{"both", 1, 1, 0.4, 1, 1.4},
+	}
+	for _, tt := range tests {
+		resp, err := c.FetchAllTokens(tt.did)
+		if err != nil {
+			t.Fatal("Failed to fetch all tokens", err)
+		}
+		if !resp.Status || len(resp.PartTokens) != tt.parts || len(resp.WholeTokens) != tt.whole {
+			t.Fatalf("Invalid tokens for %s, %+v", tt.did, resp)
+		}
+		if resp.PartTokensValue != tt.pv || resp.WholeTokensValue != tt.wv || resp.TotalValue != tt.total {
+			t.Fatalf("Invalid values for %s, %+v", tt.did, resp)
+		}
+	}
+	// The whole tokens are read without being locked
+	tk, err := c.w.ReadToken("wt1")
+	if err != nil || tk.TokenStatus != wallet.TokenIsFree {
+		t.Fatalf("Expected the whole token left free, got %+v, %v", tk, err)
+	}
+
+	resp, err := c.FetchAllTokens("unknown")
+	if err != nil || resp.Status || resp.Message != PartTokenDIDNotFoundMsg {
+		t.Fatalf("Expected the DID not found, got %+v, %v", resp, err)
+	}
+}
+
+func TestFetchPartTokensMultiDID(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	addTestDID(t, c, "empty")
+	addTestDID(t, c, "did2")
+	addTestToken(t, c, "did1", "pt1", 0.5, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", "pt2", 0.25, wallet.TokenIsPledged)
+	addTestToken(t, c, "did2", "pt3", 0.1, wallet.TokenIsFree)
+	addTestToken(t, c, "did2", "wt1", 1, wallet.TokenIsFree)
+
+	resp, err := c.FetchPartTokensMultiDID([]string{"did2", "empty", "unknown", "did1", " did2"})
+	if err != nil {
+		t.Fatal("Failed to fetch part tokens", err)
+	}
+	if !resp.Status || resp.Message != PartTokensFoundMsg || resp.TotalCount != 3 || resp.TotalValue != 0.85 {
+		t.Fatalf("Invalid totals, %+v", resp)
+	}
+	exp := []struct {
+		did    string
+		status bool
+		count  int
+		value  float64
+	}{
+		{"did2", true, 1, 0.1},
+		{"empty", true, 0, 0},
+		{"unknown", false, 0, 0},
+		{"did1", true, 2, 0.75},
+	}
+	if len(resp.DIDs) != len(exp) {
+		t.Fatalf("Expected %d DIDs, got %+v", len(exp), resp.DIDs)
+	}
+	for i, e := range exp {
+		d := resp.DIDs[i]
+		if d.DID != e.did || d.Status != e.status || len(d.PartTokens) != e.count || d.TotalValue != e.value {
+			t.Fatalf("Invalid breakdown of %s, %+v", e.did, d)
+		}
+	}
+	if resp.DIDs[1].Message != NoPartTokensMsg || resp.DIDs[2].Message != PartTokenDIDNotFoundMsg {
+		t.Fatalf("Expected the empty and unknown DIDs reported, got %q and %q", resp.DIDs[1].Message, resp.DIDs[2].Message)
+	}
+
+	resp, err = c.FetchPartTokensMultiDID([]string{"empty"})
+	if err != nil || !resp.Status || resp.Message != NoPartTokensMsg || resp.TotalCount != 0 {
+		t.Fatalf("Expected no part tokens, got %+v, %v", resp, err)
+	}
+	if _, err := c.FetchPartTokensMultiDID(nil); err == nil {
+		t.Fatal("Expected an error without DID")
+	}
+}
+
+func TestFetchPartTokensAcquired(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	day := func(d int) time.Time { return time.Date(2023, 3, d, 0, 0, 0, 0, time.UTC) }
+	for d := 1; d <= 4; d++ {
+		err := c.w.CreateToken(&wallet.Token{TokenID: fmt.Sprintf("pt%d", d), TokenValue: 0.1, DID: "did1", TokenStatus: wallet.TokenIsFree, AcquiredAt: day(d)})
+		if err != nil {
+			t.Fatal("Failed to add token", err)
+		}
+	}
+	fetch := func(after, before time.Time) []string {
+		resp, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: "did1", CreatedAfter: after, CreatedBefore: before})
+		if err != nil || !resp.Status {
+			t.Fatalf("Failed to fetch part tokens, %+v, %v", resp, err)
+		}
+		ids := make([]string, 0)
+		for _, pt := range resp.PartTokens {
+			ids = append(ids, pt.Token)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	// Both bounds are included
+	if ids := fetch(day(2), day(3)); strings.Join(ids, ",") != "pt2,pt3" {
+		t.Fatalf("Expected pt2 and pt3 in the range, got %v", ids)
+	}
+	if ids := fetch(day(3), time.Time{}); strings.Join(ids, ",") != "pt3,pt4" {
+		t.Fatalf("Expected the tokens from the lower bound, got %v", ids)
+	}
+	if ids := fetch(time.Time{}, day(1)); strings.Join(ids, ",") != "pt1" {
+		t.Fatalf("Expected the tokens up to the upper bound, got %v", ids)
+	}
+	if ids := fetch(time.Time{}, time.Time{}); len(ids) != 4 {
+		t.Fatalf("Expected all the part tokens without a range, got %v", ids)
+	}
+
+	// A range holding no token
+	resp, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: "did1", CreatedAfter: day(2).Add(time.Hour), CreatedBefore: day(3).Add(-time.Hour)})
+	if err != nil || !resp.Status || len(resp.PartTokens) != 0 || resp.TotalValue != 0 || resp.Message != NoPartTokensMsg {
+		t.Fatalf("Expected no part tokens in the empty range, got %+v, %v", resp, err)
+	}
+	if _, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: "did1", CreatedAfter: day(3), CreatedBefore: day(2)}); err == nil {
+		t.Fatal("Expected an inverted range to fail")
+	}
+}
+
+func TestShutdownPartTokens(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	addTestToken(t, c, "did1", "pt1", 0.5, wallet.TokenIsFree)
+	var buf bytes.Buffer
+	log := logger.New(&logger.LoggerOptions{
+		Level:  logger.Info,
+		Color:  []logger.ColorOption{logger.ColorOff},
+		Output: []io.Writer{&buf},
+	})
+	w, err := wallet.InitWallet(&slowStorage{Storage: c.s, delay: 200 * time.Millisecond}, t.TempDir()+"/", log)
+	if err != nil {
+		t.Fatal("Failed to init wallet", err)
+	}
+	c.w, c.log = w, log
+
+	fetched := make(chan error, 1)
+	go func() {
+		_, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: "did1"})
+		fetched <- err
+	}()
+	for i := 0; ; i++ {
+		c.ptr.l.Lock()
+		n := c.ptr.inflight
+		c.ptr.l.Unlock()
+		if n == 1 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("Request never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.ShutdownPartTokens(2 * time.Second); err != nil {
+		t.Fatal("Failed to shutdown", err)
+	}
+	select {
+	case err := <-fetched:
+		if err != nil {
+			t.Fatal("In flight request failed", err)
+		}
+	default:
+		t.Fatal("Wallet closed before the in flight request drained")
+	}
+	if !strings.Contains(buf.String(), "Drained part token requests") {
+		t.Fatalf("Expected the drain logged, got %s", buf.String())
+	}
+	if _, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: "did1"}); !errors.Is(err, ErrPartTokensShutdown) {
+		t.Fatalf("Expected %v after shutdown, got %v", ErrPartTokensShutdown, err)
+	}
+	// The wallet stays open for the transfers still in progress
+	if _, err := c.w.ReadAllPartTokens("did1"); err != nil {
+		t.Fatal("Wallet closed by the part token shutdown", err)
+	}
+	if err := c.w.Close(); err != nil {
+		t.Fatal("Failed to close the wallet", err)
+	}
+	if err := c.w.Close(); err != nil {
+		t.Fatal("Second wallet close failed", err)
+	}
+}
+
+func TestFetchPartTokensSortBy(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	addTestToken(t, c, "did1", "pt1", 0.5, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", "pt2", 0.1, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", "pt3", 0.3, wallet.TokenIsFree)
+	values := func(resp *model.FetchPartTokensResponse) []float64 {
+		v := make([]float64, 0, len(resp.PartTokens))
+		for _, pt := range resp.PartTokens {
+			v = append(v, pt.TokenValue)
+		}
+		return v
+	}
+	for _, tc := range []struct {
+		by   string
+		want []float64
+	}{
+		{"", []float64{0.5, 0.1, 0.3}},
+		{PartTokenSortValueAsc, []float64{0.1, 0.3, 0.5}},
+		{PartTokenSortValueDesc, []float64{0.5, 0.3, 0.1}},
+		// The cached result keeps the wallet order
+		{"", []float64{0.5, 0.1, 0.3}},
+	} {
+		resp, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: "did1", SortBy: tc.by})
+		if err != nil {
+			t.Fatal("Failed to fetch part tokens", err)
+		}
+		if got := values(resp); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Fatalf("Sort %q, expected %v, got %v", tc.by, tc.want, got)
+		}
+	}
+	if _, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: "did1", SortBy: "weight"}); err == nil {
+		t.Fatal("Expected an invalid sort to fail")
+	}
+}
+
+func TestPartTokenPeerBreaker(t *testing.T) {
+	c := initTestCore(t)
+	now := time.Unix(1700000000, 0)
+	c.ptb.now = func() time.Time { return now }
+	c.ptb.cooldown = time.Minute
+	errPeer := errors.New("peer unreachable")
+
+	for i := 0; i < PartTokenBreakerThreshold; i++ {
+		if err := c.ptb.allow("Peer1"); err != nil {
+			t.Fatalf("Circuit open after %d failures, %v", i, err)
+		}
+		c.recordPeerResult("Peer1", errPeer)
+	}
+	if err := c.ptb.allow("Peer1"); !errors.Is(err, ErrPeerCircuitOpen) {
+		t.Fatalf("Expected %v, got %v", ErrPeerCircuitOpen, err)
+	}
+	// The open circuit fails the fetch without contacting the peer
+	if _, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: "Peer1.did1"}); !errors.Is(err, ErrPeerCircuitOpen) {
+		t.Fatalf("Expected the fetch short circuited, got %v", err)
+	}
+	if err := c.ptb.allow("Peer2"); err != nil {
+		t.Fatal("Other peer affected by the open circuit", err)
+	}
+
+	// After the cooldown a single trial goes through, its failure reopens
+	now = now.Add(time.Minute)
+	if err := c.ptb.allow("Peer1"); err != nil {
+		t.Fatal("Expected the trial after the cooldown", err)
+	}
+	if err := c.ptb.allow("Peer1"); !errors.Is(err, ErrPeerCircuitOpen) {
+		t.Fatalf("Expected a single trial, got %v", err)
+	}
+	c.recordPeerResult("Peer1", errPeer)
+	if err := c.ptb.allow("Peer1"); !errors.Is(err, ErrPeerCircuitOpen) {
+		t.Fatalf("Expected the failed trial to reopen the circuit, got %v", err)
+	}
+
+	// A successful trial closes the circuit
+	now = now.Add(time.Minute)
+	if err := c.ptb.allow("Peer1"); err != nil {
+		t.Fatal("Expected the trial after the cooldown", err)
+	}
+	c.recordPeerResult("Peer1", nil)
+	for i := 0; i < PartTokenBreakerThreshold-1; i++ {
+		c.recordPeerResult("Peer1", errPeer)
+		if err := c.ptb.allow("Peer1"); err != nil {
+			t.Fatalf("Expected the failures counted again from zero, got %v", err)
+		}
+	}
+
+	// The failures reported are the ones of the peer
+	for i := 1; i <= PartTokenBreakerThreshold+1; i++ {
+		n, opened := c.ptb.record("Peer3", errPeer)
+		if n != i || opened != (i == PartTokenBreakerThreshold) {
+			t.Fatalf("Expected %d failures, got %d opened %v", i, n, opened)
+		}
+	}
+}
+
+func TestValidateTokenID(t *testing.T) {
+	for _, id := range []string{
+		"QmYbLpSxs87YiVDhq3SuL8bDsMxNjymkALtmrcfJ2X6g3z",
+		"QmWV5tBHE6ASnbHW6QdBgENjWZw7E6D7GsJ9uEGWaq2Arb",
+		testTokenID(7),
+	} {
+		if err := validateTokenID(id); err != nil {
+			t.Fatalf("Valid token ID %s rejected, %v", id, err)
+		}
+	}
+	for _, id := range []string{
+		"",
+		"pt1",
+		"QmYbLpSxs87YiVDhq3SuL8bDsMxNjymkALtmrcfJ2X6g3",
+		"QmYbLpSxs87YiVDhq3SuL8bDsMxNjymkALtmrcfJ2X6g3zz",
+		"XmYbLpSxs87YiVDhq3SuL8bDsMxNjymkALtmrcfJ2X6g3z",
+		"QmYbLpSxs87YiVDhq3SuL8bDsMxNjymkALtmrcfJ2X6g30",
+		"QmYbLpSxs87YiVDhq3SuL8bDsMxNjymkALtmrcfJ2X6gOI",
+		"QmYbLpSxs87YiVDhq3SuL8bDsMxNjymkALtmrcfJ2X6g/z",
+	} {
+		if err := validateTokenID(id); !errors.Is(err, ErrInvalidTokenID) {
+			t.Fatalf("Expected %q rejected, got %v", id, err)
+		}
+	}
+	ids, err := normalizeTokenIDs([]string{" " + testTokenID(1) + "\n", testTokenID(2)})
+	if err != nil || ids[0] != testTokenID(1) || ids[1] != testTokenID(2) {
+		t.Fatalf("Expected the token IDs trimmed, got %q, %v", ids, err)
+	}
+	if _, err := normalizeTokenIDs([]string{testTokenID(1), testTokenID(1)}); !errors.Is(err, ErrInvalidTokenID) {
+		t.Fatalf("Expected the repeated token ID rejected, got %v", err)
+	}
+
+	// The malformed IDs are rejected before reading the wallet
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	addTestDID(t, c, "did2")
+	if br := c.movePartTokens(&testDIDCrypto{did: "did1"}, &model.MovePartTokensRequest{FromDID: "did1", ToDID: "did2", TokenIDs: []string{"pt1"}}); br.Status || !strings.Contains(br.Message, ErrInvalidTokenID.Error()) {
+		t.Fatalf("Expected the move rejected, got %+v", br)
+	}
+	if _, err := c.LockPartTokens("did1", []string{"bad token"}, time.Minute); !errors.Is(err, ErrInvalidTokenID) {
+		t.Fatalf("Expected the lock rejected, got %v", err)
+	}
+}
+
+func TestPartTokenDenominations(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	addTestDID(t, c, "did2")
+	addTestToken(t, c, "did1", "pt1", 0.5, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", "pt2", 0.5, wallet.TokenIsPledged)
+	addTestToken(t, c, "did1", "pt3", 0.25, wallet.TokenIsFree)
+	// The same denomination computed with a float rounding error
+	addTestToken(t, c, "did1", "pt4", 0.1+0.2, wallet.TokenIsFree)
+	addTestToken(t, c, "did1", "pt5", 0.3, wallet.TokenIsFree)
+	addTestToken(t, c, "did2", "pt6", 0.5, wallet.TokenIsFree)
+
+	dm, err := c.PartTokenDenominations("did1")
+	if err != nil {
+		t.Fatal("Failed to get part token denominations", err)
+	}
+	exp := map[float64]int{0.5: 2, 0.25: 1, 0.3: 2}
+	if !reflect.DeepEqual(dm, exp) {
+		t.Fatalf("Expected %v, got %v", exp, dm)
+	}
+	if dm, err := c.PartTokenDenominations("did3"); !errors.Is(err, ErrPartTokenDIDNotFound) {
+		t.Fatalf("Expected %v for an unknown DID, got %v %v", ErrPartTokenDIDNotFound, dm, err)
+	}
+}
+
+func TestFetchPartTokensPath(t *testing.T) {
+	c := initTestCore(t)
+	c.peerID = "12D3KooWTestSelfPeer"
+	addTestDID(t, c, "did1")
+	addTestToken(t, c, "did1", "pt1", 0.5, wallet.TokenIsFree)
+	for _, addr := range []string{"did1", c.peerID + ".did1"} {
+		resp, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: addr})
+		if err != nil {
+			t.Fatal("Failed to fetch part tokens", err)
+		}
+		if resp.Path != PartTokenPathLocal {
+			t.Fatalf("Expected %s served locally, got %q", addr, resp.Path)
+		}
+	}
+}
+
+func TestFetchPartTokensAsOf(t *testing.T) {
+	c := initTestCore(t)
+	addTestDID(t, c, "did1")
+	addTestToken(t, c, "did1", "pt1", 0.5, wallet.TokenIsFree)
+	fetch := func() *model.FetchPartTokensResponse {
+		resp, err := c.FetchPartTokens(&model.FetchPartTokensRequest{Address: "did1"})
+		if err != nil {
+			t.Fatal("Failed to fetch part tokens", err)
+		}
+		return resp
+	}
+
+	before := time.Now()
+	resp := fetch()
+	if resp.AsOf.Before(before) || resp.AsOf.After(time.Now()) {
+		t.Fatalf("Expected the fresh read as of now, got %v", resp.AsOf)
+	}
+
+	cachedAt := time.Now().Add(-10 * time.Second)
+	now := cachedAt
+	c.ptc = newPartTokenCache(time.Minute)
+	c.ptc.now = func() time.Time { return now }
+	if resp := fetch(); resp.AsOf.Before(before) {
+		t.Fatalf("Expected the read before caching as of now, got %v", resp.AsOf)
+	}
+	now = cachedAt.Add(5 * time.Second)
+	if resp := fetch(); !resp.AsOf.Equal(cachedAt) {
+		t.Fatalf("Expected the cached read as of %v, got %v", cachedAt, resp.AsOf)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	ipfsnode "github.com/ipfs/go-ipfs-api"
@@ -15,10 +16,20 @@ import (
 	"github.com/rubixchain/rubixgoplatform/wrapper/logger"
 )
 
+const (
+	// DefaultPeerIdleTimeout is the time after which an unused peer
+	// connection is closed
+	DefaultPeerIdleTimeout time.Duration = 2 * time.Minute
+	// MaxPeerIdleTimeout bounds the configurable idle timeout
+	MaxPeerIdleTimeout time.Duration = 10 * time.Minute
+)
+
 // Peer handle for all peer connection
 type PeerManager struct {
 	peerID    string
 	lock      sync.Mutex
+	openConns int64
+	idleTime  time.Duration
 	ps        []bool
 	appName   string
 	ipfs      *ipfsnode.Shell
@@ -36,6 +47,16 @@ type Peer struct {
 	pm     *PeerManager
 	peerID string
 	did    string
+	lock   sync.Mutex
+	idle   *time.Timer
+	active int
+	// closed by the owner, the connection can not be used anymore
+	closed bool
+	// the transport is closed by the idle timer, it is reopened by the next
+	// request as the owner may still hold the peer
+	reaped  bool
+	openFn  func() error
+	closeFn func() error
 }
 
 func NewPeerManager(startPort uint16, lport uint16, maxNumPort uint16, ipfs *ipfsnode.Shell, log logger.Logger, bootStrap []string, peerID string) *PeerManager {
@@ -44,6 +65,7 @@ func NewPeerManager(startPort uint16, lport uint16, maxNumPort uint16, ipfs *ipf
 		ipfs:      ipfs,
 		log:       log.Named("PeerManager"),
 		ps:        make([]bool, maxNumPort),
+		idleTime:  DefaultPeerIdleTimeout,
 		startPort: startPort,
 		lport:     lport,
 		bootStrap: bootStrap,
@@ -60,6 +82,43 @@ func NewPeerManager(startPort uint16, lport uint16, maxNumPort uint16, ipfs *ipf
 	return p
 }
 
+// SetIdleTimeout sets the time after which an unused peer connection is
+// closed, the timeout is bounded by MaxPeerIdleTimeout
+func (pm *PeerManager) SetIdleTimeout(d time.Duration) {
+	if d <= 0 {
+		d = DefaultPeerIdleTimeout
+	}
+	if d > MaxPeerIdleTimeout {
+		d = MaxPeerIdleTimeout
+	}
+	pm.lock.Lock()
+	pm.idleTime = d
+	pm.lock.Unlock()
+}
+
+func (pm *PeerManager) idleTimeout() time.Duration {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	if pm.idleTime <= 0 {
+		return DefaultPeerIdleTimeout
+	}
+	return pm.idleTime
+}
+
+// OpenConns returns the number of peer connections not yet closed
+func (pm *PeerManager) OpenConns() int64 {
+	return atomic.LoadInt64(&pm.openConns)
+}
+
+// trackPeer counts the opened peer connection and arms its idle timer
+func (pm *PeerManager) trackPeer(p *Peer) {
+	n := atomic.AddInt64(&pm.openConns, 1)
+	p.lock.Lock()
+	p.idle = time.AfterFunc(pm.idleTimeout(), p.closeIdle)
+	p.lock.Unlock()
+	p.log.Debug("Peer connection opened", "did", p.did, "open_conns", n)
+}
+
 func (pm *PeerManager) getPeerPort() uint16 {
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
@@ -126,53 +185,73 @@ func (pm *PeerManager) OpenPeerConn(peerID string, did string, appname string) (
 			pm.log.Error("failed to create ensweb clent", "err", err)
 			return nil, err
 		}
+		pm.trackPeer(p)
 		return p, nil
 	} else {
 		if !pm.SwarmConnect(peerID) {
 			pm.log.Error("Failed to connect swarm peer", "peerID", peerID)
 			return nil, fmt.Errorf("failed to connect swarm peer")
 		}
-		portNum := pm.getPeerPort()
-		if portNum == 0 {
-			return nil, fmt.Errorf("all ports are busy")
-		}
-		scfg := &srvcfg.Config{
-			ServerAddress: "localhost",
-			ServerPort:    fmt.Sprintf("%d", portNum),
-		}
 		p := &Peer{
-			port:   portNum,
 			pm:     pm,
 			log:    pm.log.Named(peerID),
 			peerID: peerID,
 			did:    did,
 		}
-		proto := "/x/" + appname + "/1.0"
-		addr := "/ip4/127.0.0.1/tcp/" + fmt.Sprintf("%d", portNum)
-		peer := "/p2p/" + peerID
-		resp, err := pm.ipfs.Request("p2p/forward", proto, addr, peer).Send(context.Background())
-		if err != nil {
-			pm.log.Error("failed make forward request")
-			pm.releasePeerPort(portNum)
-			return nil, err
+		p.openFn = func() error {
+			return pm.forwardPeer(p, appname)
 		}
-		defer resp.Close()
-		if resp.Error != nil {
-			pm.log.Error("error in forward request")
-			pm.releasePeerPort(portNum)
-			return nil, resp.Error
-		}
-		p.Client, err = ensweb.NewClient(scfg, p.log)
-		if err != nil {
-			pm.log.Error("failed to create ensweb clent", "err", err)
-			pm.releasePeerPort(portNum)
+		if err := p.openFn(); err != nil {
 			return nil, err
 		}
+		p.closeFn = p.closePort
+		pm.trackPeer(p)
 		return p, nil
 	}
 }
 
+// forwardPeer forwards a local port to the remote peer and points the client
+// of the peer at it
+func (pm *PeerManager) forwardPeer(p *Peer, appname string) error {
+	portNum := pm.getPeerPort()
+	if portNum == 0 {
+		return fmt.Errorf("all ports are busy")
+	}
+	scfg := &srvcfg.Config{
+		ServerAddress: "localhost",
+		ServerPort:    fmt.Sprintf("%d", portNum),
+	}
+	proto := "/x/" + appname + "/1.0"
+	addr := "/ip4/127.0.0.1/tcp/" + fmt.Sprintf("%d", portNum)
+	peer := "/p2p/" + p.peerID
+	resp, err := pm.ipfs.Request("p2p/forward", proto, addr, peer).Send(context.Background())
+	if err != nil {
+		pm.log.Error("failed make forward request")
+		pm.releasePeerPort(portNum)
+		return err
+	}
+	defer resp.Close()
+	if resp.Error != nil {
+		pm.log.Error("error in forward request")
+		pm.releasePeerPort(portNum)
+		return resp.Error
+	}
+	p.Client, err = ensweb.NewClient(scfg, p.log)
+	if err != nil {
+		pm.log.Error("failed to create ensweb clent", "err", err)
+		pm.releasePeerPort(portNum)
+		return err
+	}
+	p.port = portNum
+	return nil
+}
+
 func (p *Peer) SendJSONRequest(method string, path string, querry map[string]string, req interface{}, resp interface{}, did bool, timeout ...time.Duration) error {
+	if err := p.acquire(); err != nil {
+		return err
+	}
+	defer p.release()
+	p.log.Debug("Sending peer request", "path", path, "open_conns", p.pm.OpenConns())
 	httpReq, err := p.JSONRequest(method, path, req)
 	httpReq.Close = true
 	if err != nil {
@@ -210,21 +289,98 @@ func (p *Peer) IsLocal() bool {
 	return p.local
 }
 
-func (p *Peer) Close() error {
-	if !p.local {
-		defer p.pm.releasePeerPort(p.port)
-		addr := "/ip4/127.0.0.1/tcp/" + fmt.Sprintf("%d", p.port)
-		req := p.pm.ipfs.Request("p2p/close")
-		resp, err := req.Option("listen-address", addr).Send(context.Background())
-		if err != nil {
-			p.log.Error("failed to close ipfs port", "err", err)
-			return err
-		}
-		defer resp.Close()
-		if resp.Error != nil {
-			p.log.Error("failed to close ipfs port", "err", resp.Error)
-			return resp.Error
+// acquire marks the connection in use, the idle timer is stopped until the
+// connection is released. The transport closed by the idle timer is reopened.
+func (p *Peer) acquire() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return fmt.Errorf("peer connection is closed")
+	}
+	if p.reaped {
+		if p.openFn != nil {
+			if err := p.openFn(); err != nil {
+				p.log.Error("Failed to reopen idle peer connection", "did", p.did, "err", err)
+				return fmt.Errorf("failed to reopen peer connection, %v", err)
+			}
 		}
+		p.reaped = false
+		n := atomic.AddInt64(&p.pm.openConns, 1)
+		p.log.Debug("Idle peer connection reopened", "did", p.did, "open_conns", n)
+	}
+	p.active++
+	if p.idle != nil {
+		p.idle.Stop()
+	}
+	return nil
+}
+
+// release rearms the idle timer once the connection is not in use
+func (p *Peer) release() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.active--
+	if p.active == 0 && !p.closed && p.idle != nil {
+		p.idle.Reset(p.pm.idleTimeout())
+	}
+}
+
+// closeIdle closes the transport of the connection unused for the idle
+// timeout, the peer stays usable for its owner
+func (p *Peer) closeIdle() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.active > 0 || p.closed || p.reaped {
+		return
+	}
+	p.reaped = true
+	p.log.Debug("Closing idle peer connection", "did", p.did)
+	p.closeTransport()
+}
+
+// closeTransport releases the transport of the connection, called with the
+// lock held
+func (p *Peer) closeTransport() error {
+	n := atomic.AddInt64(&p.pm.openConns, -1)
+	p.log.Debug("Peer connection closed", "did", p.did, "open_conns", n)
+	if p.closeFn != nil {
+		return p.closeFn()
+	}
+	return nil
+}
+
+// Close closes the connection for good, the transport is closed unless the
+// idle timer already did
+func (p *Peer) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	if p.idle != nil {
+		p.idle.Stop()
+	}
+	if p.reaped {
+		return nil
+	}
+	return p.closeTransport()
+}
+
+// closePort closes the forwarded port of the remote peer
+func (p *Peer) closePort() error {
+	defer p.pm.releasePeerPort(p.port)
+	addr := "/ip4/127.0.0.1/tcp/" + fmt.Sprintf("%d", p.port)
+	req := p.pm.ipfs.Request("p2p/close")
+	resp, err := req.Option("listen-address", addr).Send(context.Background())
+	if err != nil {
+		p.log.Error("failed to close ipfs port", "err", err)
+		return err
+	}
+	defer resp.Close()
+	if resp.Error != nil {
+		p.log.Error("failed to close ipfs port", "err", resp.Error)
+		return resp.Error
 	}
 	return nil
 }
@@ -0,0 +1,127 @@
+package ipfsport
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	srvcfg "github.com/rubixchain/rubixgoplatform/wrapper/config"
+	"github.com/rubixchain/rubixgoplatform/wrapper/ensweb"
+	"github.com/rubixchain/rubixgoplatform/wrapper/logger"
+)
+
+// openTestPeer opens a peer connection to the server with a transport stub
+// counting the opens and the closes
+func openTestPeer(t *testing.T, pm *PeerManager, addr string, opens *int32, closes *int32) *Peer {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal("Invalid server address", err)
+	}
+	p := &Peer{
+		pm:     pm,
+		log:    pm.log,
+		peerID: "TestPeerID",
+		did:    "TestDID",
+		openFn: func() error {
+			atomic.AddInt32(opens, 1)
+			return nil
+		},
+		closeFn: func() error {
+			atomic.AddInt32(closes, 1)
+			return nil
+		},
+	}
+	p.Client, err = ensweb.NewClient(&srvcfg.Config{ServerAddress: host, ServerPort: port}, p.log)
+	if err != nil {
+		t.Fatal("Failed to create client", err)
+	}
+	pm.trackPeer(p)
+	return p
+}
+
+func newTestPeerManager(idle time.Duration) *PeerManager {
+	return &PeerManager{
+		log: logger.New(&logger.LoggerOptions{
+			Level:  logger.Error,
+			Color:  []logger.ColorOption{logger.ColorOff},
+			Output: []io.Writer{io.Discard},
+		}),
+		idleTime: idle,
+	}
+}
+
+func TestPeerIdleTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	// The idle timer is fired by the test
+	pm := newTestPeerManager(time.Hour)
+	addr := srv.Listener.Addr().String()
+	var opens, closes int32
+	counts := func() (int32, int32, int64) {
+		return atomic.LoadInt32(&opens), atomic.LoadInt32(&closes), pm.OpenConns()
+	}
+
+	idle := openTestPeer(t, pm, addr, &opens, &closes)
+	busy := openTestPeer(t, pm, addr, &opens, &closes)
+	if n := pm.OpenConns(); n != 2 {
+		t.Fatalf("Expected 2 open connections, got %d", n)
+	}
+	// The connection in use is not closed by the idle timer
+	if err := busy.acquire(); err != nil {
+		t.Fatal("Failed to acquire the connection", err)
+	}
+	idle.closeIdle()
+	busy.closeIdle()
+	busy.release()
+	if o, c, n := counts(); o != 0 || c != 1 || n != 1 {
+		t.Fatalf("Expected only the idle connection closed, %d opens, %d closes, %d open", o, c, n)
+	}
+
+	// The owner still holding the idle peer gets it reopened
+	if err := idle.SendJSONRequest("GET", "/api/test", nil, nil, nil, false); err != nil {
+		t.Fatal("Failed to send request on the idle connection", err)
+	}
+	if o, c, n := counts(); o != 1 || c != 1 || n != 2 {
+		t.Fatalf("Expected the idle connection reopened, %d opens, %d closes, %d open", o, c, n)
+	}
+
+	// The connection closed by the owner is not reopened
+	idle.Close()
+	if err := idle.SendJSONRequest("GET", "/api/test", nil, nil, nil, false); err == nil {
+		t.Fatal("Expected failure on the closed connection")
+	}
+	// Closing the connection closed by the idle timer does not close the
+	// transport twice
+	busy.closeIdle()
+	busy.Close()
+	busy.Close()
+	if o, c, n := counts(); o != 1 || c != 3 || n != 0 {
+		t.Fatalf("Expected all connections closed once, %d opens, %d closes, %d open", o, c, n)
+	}
+}
+
+func TestPeerIdleTimer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	pm := newTestPeerManager(10 * time.Millisecond)
+	var opens, closes int32
+	p := openTestPeer(t, pm, srv.Listener.Addr().String(), &opens, &closes)
+	defer p.Close()
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&closes) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Idle connection not closed by the timer")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := pm.OpenConns(); n != 0 {
+		t.Fatalf("Expected no open connection, got %d", n)
+	}
+}
@@ -7,6 +7,28 @@ type BasicResponse struct {
 	Result  interface{} `json:"result"`
 }
 
+// ResponseError is the error of a response with a false status, it carries
+// the message of the response
+type ResponseError struct {
+	Message string
+}
+
+func (e *ResponseError) Error() string {
+	if e.Message == "" {
+		return "request failed"
+	}
+	return e.Message
+}
+
+// AsError will return nil if the status of the response is true, otherwise
+// a *ResponseError with the message of the response
+func (br *BasicResponse) AsError() error {
+	if br.Status {
+		return nil
+	}
+	return &ResponseError{Message: br.Message}
+}
+
 // TokenNumberResponse will be basic response model
 type TokenNumberResponse struct {
 	Status       bool   `json:"status"`
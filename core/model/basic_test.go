@@ -0,0 +1,26 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBasicResponseAsError(t *testing.T) {
+	br := &BasicResponse{Status: true, Message: "done"}
+	if err := br.AsError(); err != nil {
+		t.Fatalf("Expected no error for a successful response, got %v", err)
+	}
+
+	br = &BasicResponse{Status: false, Message: "DID does not exist"}
+	err := br.AsError()
+	var re *ResponseError
+	if !errors.As(err, &re) || re.Message != "DID does not exist" || err.Error() != "DID does not exist" {
+		t.Fatalf("Expected a ResponseError with the message, got %v", err)
+	}
+
+	// Embedded in the responses
+	resp := &FetchPartTokensResponse{BasicResponse: BasicResponse{Status: false}}
+	if err := resp.AsError(); err == nil || err.Error() != "request failed" {
+		t.Fatalf("Expected the default message for an empty message, got %v", err)
+	}
+}
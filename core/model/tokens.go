@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 const (
 	RBTType string = "RBT"
 	DTType  string = "DT"
@@ -51,3 +53,148 @@ type TokenResponse struct {
 	BasicResponse
 	TokenDetials []TokenDetial `json:"token_detials"`
 }
+
+type FetchPartTokensRequest struct {
+	Address string `json:"address"`
+	// Signed requests the peer to sign the part tokens with its quorum key,
+	// the response is rejected if the signature does not verify
+	Signed bool `json:"signed"`
+	// Verified requests the holder to check the value of each part token
+	// against the ledger, the invalid tokens are omitted and reported
+	Verified bool `json:"verified"`
+	// WithProofs requests the Merkle root of the part tokens and the
+	// inclusion proof of each token
+	WithProofs bool `json:"with_proofs"`
+	// State filters the part tokens, "free" keeps the free tokens which are
+	// not locked for a pending transfer. All the tokens are returned if empty.
+	State string `json:"state,omitempty"`
+	// CreatedAfter and CreatedBefore keep the part tokens acquired by the
+	// DID within the range, both bounds included. A zero time leaves the
+	// range open on that side.
+	CreatedAfter  time.Time `json:"created_after,omitempty"`
+	CreatedBefore time.Time `json:"created_before,omitempty"`
+	// SortBy orders the returned part tokens, "value_asc", "value_desc" or
+	// "id". The tokens are in the wallet order if empty.
+	SortBy string `json:"sort_by,omitempty"`
+}
+
+type PartTokenDetial struct {
+	Token       string  `json:"token"`
+	ParentToken string  `json:"parent_token"`
+	TokenValue  float64 `json:"token_value"`
+	Status      int     `json:"status"`
+	// Proof is the Merkle inclusion proof of the token, set only when the
+	// proofs are requested
+	Proof []MerkleProofStep `json:"proof,omitempty"`
+}
+
+// MerkleProofStep is the sibling hash at a level of the Merkle tree, Left is
+// set when the sibling is on the left of the node
+type MerkleProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left,omitempty"`
+}
+
+// PartTokenStreamError is the last record of a part tokens stream which
+// failed after it started, the tokens streamed before it are incomplete
+type PartTokenStreamError struct {
+	Error string `json:"error"`
+}
+
+type PartTokenDiscrepancy struct {
+	Token string `json:"token"`
+	Issue string `json:"issue"`
+}
+
+type PartTokenVerificationResult struct {
+	BasicResponse
+	DID           string                 `json:"did"`
+	Verified      int                    `json:"verified"`
+	Discrepancies []PartTokenDiscrepancy `json:"discrepancies"`
+}
+
+type ConsolidationResult struct {
+	BasicResponse
+	DID       string   `json:"did"`
+	Mergeable []string `json:"mergeable"`
+}
+
+type PartTokensQuorumResponse struct {
+	FetchPartTokensResponse
+	Agreed    []string `json:"agreed"`
+	Disagreed []string `json:"disagreed"`
+	Failed    []string `json:"failed"`
+}
+
+type TransferFeasibility struct {
+	BasicResponse
+	Target    float64  `json:"target"`
+	Available float64  `json:"available"`
+	Shortfall float64  `json:"shortfall"`
+	Feasible  bool     `json:"feasible"`
+	Failed    []string `json:"failed"`
+}
+
+type MovePartTokensRequest struct {
+	FromDID  string   `json:"from_did"`
+	ToDID    string   `json:"to_did"`
+	TokenIDs []string `json:"token_ids"`
+	// TxID is the transaction of the move, set on its log entries
+	TxID string `json:"tx_id,omitempty"`
+}
+
+type FetchPartTokensResponse struct {
+	BasicResponse
+	DID        string                 `json:"did"`
+	PartTokens []PartTokenDetial      `json:"part_tokens"`
+	TotalValue float64                `json:"total_value"`
+	Height     uint64                 `json:"height,omitempty"`
+	Omitted    []PartTokenDiscrepancy `json:"omitted,omitempty"`
+	MerkleRoot string                 `json:"merkle_root,omitempty"`
+	SignerDID  string                 `json:"signer_did,omitempty"`
+	Signature  string                 `json:"signature,omitempty"`
+	// AsOf is the time the part tokens were read from the wallet, for the
+	// cached results the time they were cached
+	AsOf time.Time `json:"as_of"`
+	// Path is how the node served the address, "local" from its own wallet
+	// or "peer" from the peer holding the DID
+	Path string `json:"path,omitempty"`
+}
+
+// FetchPartTokensMultiDIDResponse holds the part tokens of each DID, in the
+// requested order, and the totals over all the DIDs
+type FetchPartTokensMultiDIDResponse struct {
+	BasicResponse
+	DIDs       []FetchPartTokensResponse `json:"dids"`
+	TotalCount int                       `json:"total_count"`
+	TotalValue float64                   `json:"total_value"`
+}
+
+type WholeTokenDetail struct {
+	Token  string `json:"token"`
+	Status int    `json:"status"`
+}
+
+// PartTokenDenomination is the number of part tokens of a value
+type PartTokenDenomination struct {
+	Value float64 `json:"value"`
+	Count int     `json:"count"`
+}
+
+// PartTokenDenominationsResponse lists the part tokens held per value, in
+// the ascending order of the values
+type PartTokenDenominationsResponse struct {
+	BasicResponse
+	DID           string                  `json:"did"`
+	Denominations []PartTokenDenomination `json:"denominations"`
+}
+
+type FetchAllTokensResponse struct {
+	BasicResponse
+	DID              string             `json:"did"`
+	PartTokens       []PartTokenDetial  `json:"part_tokens"`
+	WholeTokens      []WholeTokenDetail `json:"whole_tokens"`
+	PartTokensValue  float64            `json:"part_tokens_value"`
+	WholeTokensValue float64            `json:"whole_tokens_value"`
+	TotalValue       float64            `json:"total_value"`
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"sync"
+)
+
+// partTokenBalanceSubs holds the subscribers of the part token balance of
+// the DIDs, the zero value is ready to use
+type partTokenBalanceSubs struct {
+	l    sync.Mutex
+	pl   sync.Mutex
+	subs map[string][]chan float64
+	last map[string]float64
+}
+
+// SubscribePartTokenBalance will return a channel receiving the total value
+// of the part tokens of the DID whenever it changes, and the function ending
+// the subscription. The channel holds only the latest balance, a slow reader
+// misses the intermediate ones. The channel is closed on unsubscribe.
+func (c *Core) SubscribePartTokenBalance(did string) (<-chan float64, func()) {
+	ch := make(chan float64, 1)
+	bal, err := c.partTokenBalance(did)
+	if err != nil {
+		c.Logger().Error("Failed to read the part token balance", "did", did, "err", err)
+	}
+	ps := &c.pbs
+	ps.l.Lock()
+	if ps.subs == nil {
+		ps.subs = make(map[string][]chan float64)
+		ps.last = make(map[string]float64)
+	}
+	if _, ok := ps.last[did]; !ok {
+		ps.last[did] = bal
+	}
+	ps.subs[did] = append(ps.subs[did], ch)
+	ps.l.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			ps.l.Lock()
+			defer ps.l.Unlock()
+			subs := ps.subs[did]
+			for i := range subs {
+				if subs[i] == ch {
+					subs = append(subs[:i], subs[i+1:]...)
+					break
+				}
+			}
+			if len(subs) == 0 {
+				delete(ps.subs, did)
+				delete(ps.last, did)
+			} else {
+				ps.subs[did] = subs
+			}
+			close(ch)
+		})
+	}
+}
+
+// partTokensWritten is the token write hook of the wallet, it is called with
+// the wallet locked so the balance is published from a goroutine.
+func (c *Core) partTokensWritten(did string) {
+	c.ptc.invalidate(did)
+	c.pbs.l.Lock()
+	subscribed := len(c.pbs.subs[did]) > 0
+	c.pbs.l.Unlock()
+	if subscribed {
+		go c.publishPartTokenBalance(did)
+	}
+}
+
+// publishPartTokenBalance will send the balance of the DID to the
+// subscribers if it changed since the last publish
+func (c *Core) publishPartTokenBalance(did string) {
+	ps := &c.pbs
+	// Serialize the publishers so the last balance read is sent last
+	ps.pl.Lock()
+	defer ps.pl.Unlock()
+	bal, err := c.partTokenBalance(did)
+	if err != nil {
+		c.Logger().Error("Failed to read the part token balance", "did", did, "err", err)
+		return
+	}
+	ps.l.Lock()
+	defer ps.l.Unlock()
+	if last, ok := ps.last[did]; !ok || last == bal {
+		return
+	}
+	ps.last[did] = bal
+	for _, ch := range ps.subs[did] {
+		// Replace the balance not yet received
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- bal
+	}
+}
+
+// partTokenBalance is the total value of the part tokens held by the DID
+func (c *Core) partTokenBalance(did string) (float64, error) {
+	tkns, err := c.w.ReadAllPartTokens(did)
+	if err != nil {
+		return 0, err
+	}
+	bal := 0.0
+	for _, t := range tkns {
+		bal = floatPrecision(bal+t.TokenValue, MaxDecimalPlaces)
+	}
+	return bal, nil
+}
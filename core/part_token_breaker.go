@@ -0,0 +1,102 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PartTokenBreakerThreshold is the number of consecutive failures of a peer
+// which opens its circuit
+const PartTokenBreakerThreshold int = 3
+
+// PartTokenBreakerCooldown is the time the part token fetches of a peer with
+// an open circuit fail without contacting the peer
+const PartTokenBreakerCooldown time.Duration = 30 * time.Second
+
+// ErrPeerCircuitOpen is returned for the part token fetches of a peer which
+// failed repeatedly, until its cooldown ends
+var ErrPeerCircuitOpen = errors.New("peer circuit open after repeated failures")
+
+type peerCircuit struct {
+	failures int
+	openedAt time.Time
+	open     bool
+	// set while the single request after the cooldown is in progress
+	trial bool
+}
+
+// partTokenBreakers holds the circuit of each peer, the zero value is ready
+// to use. A circuit opens after PartTokenBreakerThreshold consecutive
+// failures, once the cooldown ends a single request is let through and its
+// result closes or reopens the circuit.
+type partTokenBreakers struct {
+	l        sync.Mutex
+	now      func() time.Time
+	peers    map[string]*peerCircuit
+	cooldown time.Duration
+}
+
+func (pb *partTokenBreakers) init() {
+	if pb.peers == nil {
+		pb.peers = make(map[string]*peerCircuit)
+	}
+	if pb.now == nil {
+		pb.now = time.Now
+	}
+	if pb.cooldown == 0 {
+		pb.cooldown = PartTokenBreakerCooldown
+	}
+}
+
+// allow reports whether a request to the peer can be made, the error tells
+// when the circuit closes again
+func (pb *partTokenBreakers) allow(peerID string) error {
+	pb.l.Lock()
+	defer pb.l.Unlock()
+	pb.init()
+	pc, ok := pb.peers[peerID]
+	if !ok || !pc.open {
+		return nil
+	}
+	retry := pc.openedAt.Add(pb.cooldown)
+	if pc.trial || pb.now().Before(retry) {
+		return fmt.Errorf("%w, peer %s, retry after %s", ErrPeerCircuitOpen, peerID, retry.Format(time.RFC3339))
+	}
+	pc.trial = true
+	return nil
+}
+
+// record the result of a request to the peer, it returns the consecutive
+// failures of the peer and true when the failure opened the circuit
+func (pb *partTokenBreakers) record(peerID string, err error) (int, bool) {
+	pb.l.Lock()
+	defer pb.l.Unlock()
+	pb.init()
+	if err == nil {
+		delete(pb.peers, peerID)
+		return 0, false
+	}
+	pc, ok := pb.peers[peerID]
+	if !ok {
+		pc = &peerCircuit{}
+		pb.peers[peerID] = pc
+	}
+	pc.failures++
+	if pc.trial || (!pc.open && pc.failures >= PartTokenBreakerThreshold) {
+		pc.open = true
+		pc.trial = false
+		pc.openedAt = pb.now()
+		return pc.failures, true
+	}
+	return pc.failures, false
+}
+
+// recordPeerResult will update the circuit of the peer with the result of
+// the request
+func (c *Core) recordPeerResult(peerID string, err error) {
+	if failures, opened := c.ptb.record(peerID, err); opened {
+		c.Logger().Warn("Peer circuit opened, skipping the peer", "peerID", peerID, "failures", failures)
+	}
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rubixchain/rubixgoplatform/core/model"
+)
+
+// PartTokenCacheTTL is the time the fetched part tokens are served from the
+// cache, the local DIDs are evicted earlier on the wallet writes
+const PartTokenCacheTTL time.Duration = 30 * time.Second
+
+type partTokenCacheEntry struct {
+	resp    *model.FetchPartTokensResponse
+	created time.Time
+	expires time.Time
+}
+
+// partTokenCache holds the fetched part tokens keyed by the DID for the local
+// DIDs and by <peerID>.<DID> for the DIDs of the peers
+type partTokenCache struct {
+	l       sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]partTokenCacheEntry
+}
+
+func newPartTokenCache(ttl time.Duration) *partTokenCache {
+	return &partTokenCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]partTokenCacheEntry),
+	}
+}
+
+// get returns a copy of the cached part tokens as of the time they were
+// cached, nil if not cached or expired
+func (pc *partTokenCache) get(key string) *model.FetchPartTokensResponse {
+	if pc == nil {
+		return nil
+	}
+	pc.l.Lock()
+	defer pc.l.Unlock()
+	e, ok := pc.entries[key]
+	if !ok {
+		return nil
+	}
+	if !pc.now().Before(e.expires) {
+		delete(pc.entries, key)
+		return nil
+	}
+	resp := copyPartTokensResponse(e.resp)
+	resp.AsOf = e.created
+	return resp
+}
+
+func (pc *partTokenCache) put(key string, resp *model.FetchPartTokensResponse) {
+	if pc == nil {
+		return
+	}
+	pc.l.Lock()
+	defer pc.l.Unlock()
+	now := pc.now()
+	pc.entries[key] = partTokenCacheEntry{
+		resp:    copyPartTokensResponse(resp),
+		created: now,
+		expires: now.Add(pc.ttl),
+	}
+}
+
+// invalidate evicts the cached part tokens of the local DID, it is the
+// token write hook of the wallet. An empty DID evicts every entry.
+func (pc *partTokenCache) invalidate(did string) {
+	if pc == nil {
+		return
+	}
+	pc.l.Lock()
+	defer pc.l.Unlock()
+	if did == "" {
+		pc.entries = make(map[string]partTokenCacheEntry)
+		return
+	}
+	delete(pc.entries, did)
+}
+
+func copyPartTokensResponse(resp *model.FetchPartTokensResponse) *model.FetchPartTokensResponse {
+	cr := *resp
+	cr.PartTokens = make([]model.PartTokenDetial, len(resp.PartTokens))
+	copy(cr.PartTokens, resp.PartTokens)
+	return &cr
+}
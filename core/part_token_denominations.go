@@ -0,0 +1,26 @@
+package core
+
+// PartTokenDenominations will count the part tokens of the DID hosted by this
+// node per token value. The values are rounded to MaxDecimalPlaces so that
+// the same denomination is a single key whatever the float arithmetic which
+// produced it.
+func (c *Core) PartTokenDenominations(did string) (map[float64]int, error) {
+	done, err := c.beginPartTokenRequest()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if !c.w.IsDIDExist(did) {
+		return nil, ErrPartTokenDIDNotFound
+	}
+	tkns, err := c.w.ReadAllPartTokens(did)
+	if err != nil {
+		c.Logger().Error("Failed to read part tokens", "did", did, "err", err)
+		return nil, err
+	}
+	dm := make(map[float64]int)
+	for _, t := range tkns {
+		dm[floatPrecision(t.TokenValue, MaxDecimalPlaces)]++
+	}
+	return dm, nil
+}
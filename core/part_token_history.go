@@ -0,0 +1,92 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rubixchain/rubixgoplatform/core/model"
+	"github.com/rubixchain/rubixgoplatform/core/wallet"
+)
+
+// ErrHeightBeyondTip is returned for a height above the current tip
+var ErrHeightBeyondTip = errors.New("block height is beyond the current tip")
+
+// PartTokenHistory provides the part tokens held by the DIDs as of a past
+// height
+type PartTokenHistory interface {
+	// Tip returns the current height, the height may be shared by the DIDs
+	Tip(did string) (uint64, error)
+	// PartTokensAt returns the part tokens held by the DID at the height
+	PartTokensAt(did string, height uint64) ([]wallet.Token, error)
+}
+
+// tokenChainHistory derives the holdings from the part token history of the
+// wallet, the height is shared by all the token chains stored by the node and
+// is bumped by every part token chain block stored. The chains stored before
+// the history was indexed are all at height zero.
+type tokenChainHistory struct {
+	c *Core
+}
+
+func (h *tokenChainHistory) Tip(did string) (uint64, error) {
+	return h.c.w.PartTokenHistoryTip(), nil
+}
+
+func (h *tokenChainHistory) PartTokensAt(did string, height uint64) ([]wallet.Token, error) {
+	return h.c.w.PartTokensHeldAt(did, height)
+}
+
+func (c *Core) partTokenHistory() PartTokenHistory {
+	if c.pth == nil {
+		return &tokenChainHistory{c: c}
+	}
+	return c.pth
+}
+
+// FetchPartTokensAt will get the part tokens held by the DID as of the
+// height of the part token history, the height can not be beyond the current
+// tip
+func (c *Core) FetchPartTokensAt(did string, height uint64) (*model.FetchPartTokensResponse, error) {
+	resp := &model.FetchPartTokensResponse{
+		BasicResponse: model.BasicResponse{
+			Status: false,
+		},
+		DID:        did,
+		Height:     height,
+		PartTokens: make([]model.PartTokenDetial, 0),
+	}
+	if !c.w.IsDIDExist(did) {
+		resp.Message = PartTokenDIDNotFoundMsg
+		return resp, nil
+	}
+	ph := c.partTokenHistory()
+	tip, err := ph.Tip(did)
+	if err != nil {
+		c.Logger().Error("Failed to get the part token history tip", "did", did, "err", err)
+		return nil, fmt.Errorf("failed to get the part token history")
+	}
+	if height > tip {
+		return nil, fmt.Errorf("%w, height %d tip %d", ErrHeightBeyondTip, height, tip)
+	}
+	tkns, err := ph.PartTokensAt(did, height)
+	if err != nil {
+		c.Logger().Error("Failed to get the part token history", "did", did, "height", height, "err", err)
+		return nil, fmt.Errorf("failed to get the part token history")
+	}
+	resp.Status = true
+	if len(tkns) == 0 {
+		resp.Message = NoPartTokensMsg
+		return resp, nil
+	}
+	for _, t := range tkns {
+		resp.PartTokens = append(resp.PartTokens, model.PartTokenDetial{
+			Token:       t.TokenID,
+			ParentToken: t.ParentTokenID,
+			TokenValue:  t.TokenValue,
+			Status:      t.TokenStatus,
+		})
+		resp.TotalValue = floatPrecision(resp.TotalValue+t.TokenValue, MaxDecimalPlaces)
+	}
+	resp.Message = PartTokensFoundMsg
+	return resp, nil
+}
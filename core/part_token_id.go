@@ -0,0 +1,49 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TokenIDLength is the length of the CIDv0 token IDs
+const TokenIDLength int = 46
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// ErrInvalidTokenID is returned when a token ID is not a CIDv0
+var ErrInvalidTokenID = errors.New("invalid token ID")
+
+// validateTokenID will check the token ID is a CIDv0, the base58 encoded
+// sha2-256 multihash starting with Qm
+func validateTokenID(id string) error {
+	if len(id) != TokenIDLength {
+		return fmt.Errorf("%w %q, expected %d characters", ErrInvalidTokenID, id, TokenIDLength)
+	}
+	if !strings.HasPrefix(id, "Qm") {
+		return fmt.Errorf("%w %q, expected the Qm prefix", ErrInvalidTokenID, id)
+	}
+	if i := strings.IndexFunc(id, func(r rune) bool { return !strings.ContainsRune(base58Alphabet, r) }); i >= 0 {
+		return fmt.Errorf("%w %q, invalid character %q", ErrInvalidTokenID, id, id[i])
+	}
+	return nil
+}
+
+// normalizeTokenIDs will trim the spaces around the token IDs and validate
+// them, the repeated IDs are rejected
+func normalizeTokenIDs(ids []string) ([]string, error) {
+	norm := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if err := validateTokenID(id); err != nil {
+			return nil, err
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("%w %q, repeated", ErrInvalidTokenID, id)
+		}
+		seen[id] = true
+		norm = append(norm, id)
+	}
+	return norm, nil
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rubixchain/rubixgoplatform/core/model"
+	"github.com/rubixchain/rubixgoplatform/core/wallet"
+	"github.com/rubixchain/rubixgoplatform/wrapper/logger"
+	"github.com/rubixchain/rubixgoplatform/wrapper/uuid"
+)
+
+// PartTokenStateFree is the state filter of FetchPartTokens returning the
+// free part tokens which are not locked for a pending transfer
+const PartTokenStateFree = "free"
+
+var (
+	// ErrPartTokenLocked is returned when a part token is already locked
+	// for another pending transfer
+	ErrPartTokenLocked = errors.New("part token is locked")
+	// ErrPartTokenLockNotFound is returned when releasing an unknown or
+	// expired lock
+	ErrPartTokenLockNotFound = errors.New("part token lock not found")
+)
+
+// partTokenLock is a lock of the part tokens, the lock without expiry is held
+// until it is dropped
+type partTokenLock struct {
+	did     string
+	tokens  []string
+	expires time.Time
+}
+
+// partTokenLocks holds the part tokens reserved for the pending transfers,
+// the zero value is ready to use. The expired locks are dropped lazily.
+type partTokenLocks struct {
+	l      sync.Mutex
+	now    func() time.Time
+	locks  map[string]*partTokenLock
+	tokens map[string]string
+}
+
+// purge drops the expired locks, called with the lock held
+func (pl *partTokenLocks) purge() {
+	if pl.locks == nil {
+		pl.locks = make(map[string]*partTokenLock)
+		pl.tokens = make(map[string]string)
+	}
+	if pl.now == nil {
+		pl.now = time.Now
+	}
+	now := pl.now()
+	for id, lk := range pl.locks {
+		if !lk.expires.IsZero() && !now.Before(lk.expires) {
+			pl.drop(id, lk)
+		}
+	}
+}
+
+func (pl *partTokenLocks) drop(id string, lk *partTokenLock) {
+	for _, t := range lk.tokens {
+		delete(pl.tokens, t)
+	}
+	delete(pl.locks, id)
+}
+
+// isLocked reports whether the token is held by an unexpired lock
+func (pl *partTokenLocks) isLocked(token string) bool {
+	pl.l.Lock()
+	defer pl.l.Unlock()
+	pl.purge()
+	_, ok := pl.tokens[token]
+	return ok
+}
+
+// hold will lock the tokens under the lock ID without expiry, the tokens of
+// the existing lock with the ID stay locked. It returns the existing lock to
+// be restored, called with the lock held.
+func (pl *partTokenLocks) hold(id string, did string, tokens []string) *partTokenLock {
+	prev := pl.locks[id]
+	lk := &partTokenLock{did: did}
+	if prev != nil {
+		lk.tokens = append(lk.tokens, prev.tokens...)
+	}
+	for _, t := range tokens {
+		if pl.tokens[t] != id {
+			lk.tokens = append(lk.tokens, t)
+			pl.tokens[t] = id
+		}
+	}
+	pl.locks[id] = lk
+	return prev
+}
+
+// restore will replace the lock with the ID by the previous lock, the lock is
+// dropped if there is no previous lock
+func (pl *partTokenLocks) restore(id string, prev *partTokenLock) {
+	pl.l.Lock()
+	defer pl.l.Unlock()
+	if lk, ok := pl.locks[id]; ok {
+		pl.drop(id, lk)
+	}
+	if prev == nil {
+		return
+	}
+	pl.locks[id] = prev
+	for _, t := range prev.tokens {
+		pl.tokens[t] = id
+	}
+}
+
+// checkUnlocked fails if any of the tokens is held by a lock other than the
+// owner lock, called with the lock held
+func (pl *partTokenLocks) checkUnlocked(tokens []string, owner string) error {
+	for _, t := range tokens {
+		if id, ok := pl.tokens[t]; ok && id != owner {
+			return fmt.Errorf("%w, %s", ErrPartTokenLocked, t)
+		}
+	}
+	return nil
+}
+
+// LockPartTokens will reserve the free part tokens of the DID for a pending
+// transfer until they are released or the ttl expires, the returned lock ID
+// releases them. No token is locked if any of them is already locked or is
+// not a valid token ID.
+func (c *Core) LockPartTokens(did string, tokenIDs []string, ttl time.Duration) (string, error) {
+	if len(tokenIDs) == 0 {
+		return "", fmt.Errorf("no part tokens to lock")
+	}
+	if ttl <= 0 {
+		return "", fmt.Errorf("invalid lock ttl %v", ttl)
+	}
+	tokenIDs, err := normalizeTokenIDs(tokenIDs)
+	if err != nil {
+		return "", err
+	}
+	// The lock ID is the transaction of the pending transfer
+	id := uuid.New().String()
+	log := logger.WithTxID(c.Logger(), id)
+	tkns, err := c.w.ReadAllPartTokens(did)
+	if err != nil {
+		log.Error("Failed to read part tokens", "did", did, "err", err)
+		return "", err
+	}
+	free := make(map[string]bool, len(tkns))
+	for _, t := range tkns {
+		free[t.TokenID] = t.TokenStatus == wallet.TokenIsFree
+	}
+	for _, t := range tokenIDs {
+		if !free[t] {
+			return "", fmt.Errorf("part token %s is not a free part token of the DID", t)
+		}
+	}
+	// The tokens are checked under the lock so that the concurrent lockers
+	// can not reserve the same free token
+	pl := &c.ptl
+	pl.l.Lock()
+	defer pl.l.Unlock()
+	pl.purge()
+	if err := pl.checkUnlocked(tokenIDs, ""); err != nil {
+		log.Error("Part token is already locked", "did", did, "err", err)
+		return "", err
+	}
+	pl.locks[id] = &partTokenLock{
+		did:     did,
+		tokens:  append([]string(nil), tokenIDs...),
+		expires: pl.now().Add(ttl),
+	}
+	for _, t := range tokenIDs {
+		pl.tokens[t] = id
+	}
+	log.Debug("Part tokens locked", "did", did, "count", len(tokenIDs), "ttl", ttl)
+	return id, nil
+}
+
+// ReleasePartTokens will release the part tokens reserved by the lock
+func (c *Core) ReleasePartTokens(lockID string) error {
+	pl := &c.ptl
+	pl.l.Lock()
+	defer pl.l.Unlock()
+	pl.purge()
+	lk, ok := pl.locks[lockID]
+	if !ok {
+		return ErrPartTokenLockNotFound
+	}
+	pl.drop(lockID, lk)
+	logger.WithTxID(c.Logger(), lockID).Debug("Part tokens released", "did", lk.did, "count", len(lk.tokens))
+	return nil
+}
+
+// filterPartTokensState will keep the part tokens in the state, the total
+// value is of the kept tokens
+func (c *Core) filterPartTokensState(resp *model.FetchPartTokensResponse, state string) error {
+	switch state {
+	case "":
+		return nil
+	case PartTokenStateFree:
+	default:
+		return fmt.Errorf("invalid part token state %s", state)
+	}
+	pts := make([]model.PartTokenDetial, 0, len(resp.PartTokens))
+	resp.TotalValue = 0
+	for _, pt := range resp.PartTokens {
+		if pt.Status != wallet.TokenIsFree || c.ptl.isLocked(pt.Token) {
+			continue
+		}
+		pts = append(pts, pt)
+		resp.TotalValue = floatPrecision(resp.TotalValue+pt.TokenValue, MaxDecimalPlaces)
+	}
+	resp.PartTokens = pts
+	return nil
+}
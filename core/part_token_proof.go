@@ -0,0 +1,89 @@
+package core
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+
+	"github.com/rubixchain/rubixgoplatform/core/model"
+	"github.com/rubixchain/rubixgoplatform/util"
+)
+
+// The leaves and the inner nodes of the part token Merkle tree are hashed
+// with distinct prefixes so a node can not be passed off as a leaf
+const (
+	merkleLeafPrefix byte = 0
+	merkleNodePrefix byte = 1
+)
+
+func merkleHash(prefix byte, data ...[]byte) []byte {
+	b := []byte{prefix}
+	for _, d := range data {
+		b = append(b, d...)
+	}
+	return util.CalculateHash(b, "SHA3-256")
+}
+
+// partTokenLeaf is the leaf hash of the part token, it covers the token, its
+// parent and its value
+func partTokenLeaf(pt *model.PartTokenDetial) []byte {
+	v := pt.Token + "|" + pt.ParentToken + "|" + strconv.FormatFloat(floatPrecision(pt.TokenValue, MaxDecimalPlaces), 'f', -1, 64)
+	return merkleHash(merkleLeafPrefix, []byte(v))
+}
+
+// addPartTokenProofs will set the Merkle root of the part tokens in the
+// response and the inclusion proof of each token. The tree is built over the
+// tokens sorted by the token ID, an unpaired node is carried up as is.
+func addPartTokenProofs(resp *model.FetchPartTokensResponse) {
+	if len(resp.PartTokens) == 0 {
+		return
+	}
+	sort.Slice(resp.PartTokens, func(i, j int) bool { return resp.PartTokens[i].Token < resp.PartTokens[j].Token })
+	level := make([][]byte, len(resp.PartTokens))
+	// pos is the index of the node holding each token in the current level
+	pos := make([]int, len(resp.PartTokens))
+	for i := range resp.PartTokens {
+		level[i] = partTokenLeaf(&resp.PartTokens[i])
+		pos[i] = i
+		resp.PartTokens[i].Proof = make([]model.MerkleProofStep, 0)
+	}
+	for len(level) > 1 {
+		for i := range resp.PartTokens {
+			p := pos[i]
+			if p%2 == 0 && p+1 < len(level) {
+				resp.PartTokens[i].Proof = append(resp.PartTokens[i].Proof, model.MerkleProofStep{Hash: util.HexToStr(level[p+1])})
+			} else if p%2 == 1 {
+				resp.PartTokens[i].Proof = append(resp.PartTokens[i].Proof, model.MerkleProofStep{Hash: util.HexToStr(level[p-1]), Left: true})
+			}
+			pos[i] = p / 2
+		}
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, merkleHash(merkleNodePrefix, level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		level = next
+	}
+	resp.MerkleRoot = util.HexToStr(level[0])
+}
+
+// VerifyPartTokenProof will check the inclusion proof of the part token
+// against the Merkle root of the part tokens
+func VerifyPartTokenProof(pt *model.PartTokenDetial, root string) bool {
+	h := partTokenLeaf(pt)
+	for _, s := range pt.Proof {
+		sib := util.StrToHex(s.Hash)
+		if len(sib) == 0 {
+			return false
+		}
+		if s.Left {
+			h = merkleHash(merkleNodePrefix, sib, h)
+		} else {
+			h = merkleHash(merkleNodePrefix, h, sib)
+		}
+	}
+	return bytes.Equal(h, util.StrToHex(root))
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/rubixchain/rubixgoplatform/core/model"
+)
+
+// ErrPartTokenQuorum is returned when not enough peers agree on the part
+// tokens of the DID
+var ErrPartTokenQuorum = errors.New("part token quorum not reached")
+
+// FetchPartTokensQuorum will fetch the part tokens of the DID from all the
+// peers replicating it and return the part tokens agreed by at least quorum
+// peers, the peers which disagree or fail are reported in the response
+func (c *Core) FetchPartTokensQuorum(did string, peers []string, quorum int) (*model.PartTokensQuorumResponse, error) {
+	return c.fetchPartTokensQuorum(did, peers, quorum, func(peerID string) (*model.FetchPartTokensResponse, error) {
+		return c.fetchPeerPartTokens(peerID, did, &model.FetchPartTokensRequest{})
+	})
+}
+
+func (c *Core) fetchPartTokensQuorum(did string, peers []string, quorum int, fetch func(peerID string) (*model.FetchPartTokensResponse, error)) (*model.PartTokensQuorumResponse, error) {
+	if quorum <= 0 || quorum > len(peers) {
+		return nil, fmt.Errorf("invalid quorum %d for %d peers", quorum, len(peers))
+	}
+	// The peer fetches are drained by ShutdownPartTokens like the local ones
+	done, err := c.beginPartTokenRequest()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	resps := make([]*model.FetchPartTokensResponse, len(peers))
+	var wg sync.WaitGroup
+	for i := range peers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := fetch(peers[i])
+			if err != nil {
+				c.Logger().Error("Failed to fetch part tokens from the peer", "peerID", peers[i], "did", did, "err", err)
+				return
+			}
+			resps[i] = resp
+		}(i)
+	}
+	wg.Wait()
+
+	res := &model.PartTokensQuorumResponse{
+		Agreed:    make([]string, 0),
+		Disagreed: make([]string, 0),
+		Failed:    make([]string, 0),
+	}
+	votes := make(map[string][]int)
+	best := ""
+	for i, resp := range resps {
+		if resp == nil || !resp.Status {
+			res.Failed = append(res.Failed, peers[i])
+			continue
+		}
+		key, err := partTokensDigest(resp)
+		if err != nil {
+			res.Failed = append(res.Failed, peers[i])
+			continue
+		}
+		votes[key] = append(votes[key], i)
+		if len(votes[key]) > len(votes[best]) {
+			best = key
+		}
+	}
+	if len(votes[best]) < quorum {
+		c.Logger().Error("Part token quorum not reached", "did", did, "quorum", quorum, "agreed", len(votes[best]))
+		return nil, fmt.Errorf("%w, %d of %d peers agree", ErrPartTokenQuorum, len(votes[best]), quorum)
+	}
+	for key, idx := range votes {
+		if key != best && len(idx) == len(votes[best]) {
+			c.Logger().Error("Part token quorum is split", "did", did, "quorum", quorum)
+			return nil, fmt.Errorf("%w, peers are split", ErrPartTokenQuorum)
+		}
+	}
+	for key, idx := range votes {
+		for _, i := range idx {
+			if key == best {
+				res.Agreed = append(res.Agreed, peers[i])
+			} else {
+				res.Disagreed = append(res.Disagreed, peers[i])
+			}
+		}
+	}
+	sort.Strings(res.Agreed)
+	sort.Strings(res.Disagreed)
+	res.FetchPartTokensResponse = *resps[votes[best][0]]
+	if len(res.Disagreed) > 0 {
+		c.Logger().Error("Peers disagree on the part tokens", "did", did, "peers", res.Disagreed)
+		res.Message = "Part tokens agreed by the quorum, some peers disagree"
+	}
+	return res, nil
+}
+
+// partTokensDigest is the key comparing the part tokens of the peers, the
+// order of the tokens and the response message are ignored
+func partTokensDigest(resp *model.FetchPartTokensResponse) (string, error) {
+	pts := make([]model.PartTokenDetial, len(resp.PartTokens))
+	copy(pts, resp.PartTokens)
+	sort.Slice(pts, func(i, j int) bool { return pts[i].Token < pts[j].Token })
+	for i := range pts {
+		pts[i].TokenValue = floatPrecision(pts[i].TokenValue, MaxDecimalPlaces)
+	}
+	b, err := json.Marshal(struct {
+		DID        string                  `json:"did"`
+		PartTokens []model.PartTokenDetial `json:"part_tokens"`
+	}{resp.DID, pts})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
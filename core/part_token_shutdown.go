@@ -0,0 +1,94 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PartTokenDrainTimeout is the time the shutdown waits for the part token
+// requests in progress
+const PartTokenDrainTimeout time.Duration = 10 * time.Second
+
+// ErrPartTokensShutdown is returned for the part token requests received
+// once the shutdown has started
+var ErrPartTokensShutdown = errors.New("node is shutting down")
+
+// partTokenRequests tracks the part token requests in progress, the zero
+// value is ready to use
+type partTokenRequests struct {
+	l        sync.Mutex
+	closed   bool
+	inflight int
+	wg       sync.WaitGroup
+}
+
+// begin registers a request, it fails once closed
+func (pr *partTokenRequests) begin() bool {
+	pr.l.Lock()
+	defer pr.l.Unlock()
+	if pr.closed {
+		return false
+	}
+	pr.inflight++
+	pr.wg.Add(1)
+	return true
+}
+
+func (pr *partTokenRequests) end() {
+	pr.l.Lock()
+	pr.inflight--
+	pr.l.Unlock()
+	pr.wg.Done()
+}
+
+// close stops accepting the requests and returns the number in progress
+func (pr *partTokenRequests) close() int {
+	pr.l.Lock()
+	defer pr.l.Unlock()
+	pr.closed = true
+	return pr.inflight
+}
+
+// wait for the requests in progress, false if the timeout elapsed first
+func (pr *partTokenRequests) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		pr.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// beginPartTokenRequest will register a part token request, the returned
+// function ends it
+func (c *Core) beginPartTokenRequest() (func(), error) {
+	if !c.ptr.begin() {
+		return nil, ErrPartTokensShutdown
+	}
+	return c.ptr.end, nil
+}
+
+// ShutdownPartTokens will stop accepting the part token requests and wait up
+// to the timeout for the ones in progress, the error reports the requests not
+// drained in time. The wallet is left open for the other subsystems, it is
+// closed by StopCore.
+func (c *Core) ShutdownPartTokens(timeout time.Duration) error {
+	n := c.ptr.close()
+	c.Logger().Info("Stopped accepting part token requests", "inflight", n)
+	if !c.ptr.wait(timeout) {
+		c.ptr.l.Lock()
+		n = c.ptr.inflight
+		c.ptr.l.Unlock()
+		c.Logger().Error("Timed out draining part token requests", "inflight", n, "timeout", timeout)
+		return fmt.Errorf("%d part token requests still in progress after %v", n, timeout)
+	}
+	c.Logger().Info("Drained part token requests")
+	return nil
+}
@@ -70,9 +70,9 @@ func (c *Core) peerStatus(req *ensweb.Request) *ensweb.Result {
 }
 
 func (c *Core) getPeer(addr string) (*ipfsport.Peer, error) {
-	peerID, did, ok := util.ParseAddress(addr)
-	if !ok {
-		return nil, fmt.Errorf("invalid address")
+	peerID, did, err := util.ParseAddress(addr)
+	if err != nil {
+		return nil, err
 	}
 	// check if addr contains the peer ID
 	if peerID == "" {
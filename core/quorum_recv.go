@@ -704,8 +704,8 @@ func (c *Core) updateReceiverToken(req *ensweb.Request) *ensweb.Result {
 	var wg sync.WaitGroup
 	for i, ti := range sr.TokenInfo {
 		t := ti.Token
-		senderPeerId, _, ok := util.ParseAddress(sr.Address)
-		if !ok {
+		senderPeerId, _, err := util.ParseAddress(sr.Address)
+		if err != nil {
 			c.log.Error("Error occured", "error", err)
 			crep.Message = "Unable to parse sender address"
 			return c.l.RenderJSON(req, &crep, http.StatusOK)
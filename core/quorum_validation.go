@@ -366,8 +366,8 @@ func (c *Core) checkTokenState(tokenId, did string, index int, resultArray []Tok
 	qPeerIds := make([]string, 0)
 
 	for i := range quorumList {
-		pId, _, ok := util.ParseAddress(quorumList[i])
-		if !ok {
+		pId, _, err := util.ParseAddress(quorumList[i])
+		if err != nil {
 			c.log.Error("Error parsing addressing")
 			result.Error = err
 			result.Message = "Error parsing addressing"
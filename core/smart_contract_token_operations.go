@@ -31,8 +31,8 @@ func (c *Core) deploySmartContractToken(reqID string, deployReq *model.DeploySma
 	resp := &model.BasicResponse{
 		Status: false,
 	}
-	_, did, ok := util.ParseAddress(deployReq.DeployerAddress)
-	if !ok {
+	_, did, err := util.ParseAddress(deployReq.DeployerAddress)
+	if err != nil {
 		resp.Message = "Invalid Deployer DID"
 		return resp
 	}
@@ -193,8 +193,8 @@ func (c *Core) executeSmartContractToken(reqID string, executeReq *model.Execute
 		Status: false,
 	}
 
-	_, did, ok := util.ParseAddress(executeReq.ExecutorAddress)
-	if !ok {
+	_, did, err := util.ParseAddress(executeReq.ExecutorAddress)
+	if err != nil {
 		resp.Message = "Invalid Executor DID"
 		return resp
 	}
@@ -35,6 +35,7 @@ type TCBSyncReply struct {
 
 func (c *Core) SetupToken() {
 	c.l.AddRoute(APISyncTokenChain, "POST", c.syncTokenChain)
+	c.l.AddRoute(APIGetPartTokens, "GET", c.getPartTokensFromPeers)
 }
 
 func (c *Core) GetAllTokens(did string, tt string) (*model.TokenResponse, error) {
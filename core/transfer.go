@@ -39,14 +39,14 @@ func (c *Core) initiateRBTTransfer(reqID string, req *model.RBTTransferRequest)
 		return resp
 	}
 
-	_, did, ok := util.ParseAddress(req.Sender)
-	if !ok {
+	_, did, err := util.ParseAddress(req.Sender)
+	if err != nil {
 		resp.Message = "Invalid sender DID"
 		return resp
 	}
 
-	rpeerid, rdid, ok := util.ParseAddress(req.Receiver)
-	if !ok {
+	rpeerid, rdid, err := util.ParseAddress(req.Receiver)
+	if err != nil {
 		resp.Message = "Invalid receiver DID"
 		return resp
 	}
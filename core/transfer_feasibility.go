@@ -0,0 +1,65 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/rubixchain/rubixgoplatform/core/model"
+	"github.com/rubixchain/rubixgoplatform/core/wallet"
+)
+
+// EstimateTransferFeasibility will sum the free part tokens of the local DIDs
+// and the <peerID>.<DID> addresses of the peers and report whether they cover
+// the target amount, the tokens locked for a pending transfer are not counted. The addresses which fail are skipped with a warning and
+// reported in the result.
+func (c *Core) EstimateTransferFeasibility(target float64, addresses []string) (*model.TransferFeasibility, error) {
+	return c.estimateTransferFeasibility(target, addresses, c.FetchPartTokens)
+}
+
+func (c *Core) estimateTransferFeasibility(target float64, addresses []string, fetch func(req *model.FetchPartTokensRequest) (*model.FetchPartTokensResponse, error)) (*model.TransferFeasibility, error) {
+	if target <= 0 {
+		return nil, fmt.Errorf("invalid target amount %v", target)
+	}
+	res := &model.TransferFeasibility{
+		BasicResponse: model.BasicResponse{
+			Status: true,
+		},
+		Target: floatPrecision(target, MaxDecimalPlaces),
+		Failed: make([]string, 0),
+	}
+	seen := make(map[string]bool, len(addresses))
+	for _, addr := range addresses {
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		resp, err := fetch(&model.FetchPartTokensRequest{Address: addr, State: PartTokenStateFree})
+		if err == nil && !resp.Status {
+			err = fmt.Errorf("%s", resp.Message)
+		}
+		if err != nil {
+			c.Logger().Warn("Skipping the address in the transfer estimate", "address", addr, "err", err)
+			res.Failed = append(res.Failed, addr)
+			continue
+		}
+		for _, pt := range resp.PartTokens {
+			if pt.Status == wallet.TokenIsFree {
+				res.Available = floatPrecision(res.Available+pt.TokenValue, MaxDecimalPlaces)
+			}
+		}
+	}
+	res.Feasible = res.Available >= res.Target
+	if !res.Feasible {
+		res.Shortfall = floatPrecision(res.Target-res.Available, MaxDecimalPlaces)
+	}
+	switch {
+	case res.Feasible && len(res.Failed) > 0:
+		res.Message = "Transfer is feasible, some addresses failed"
+	case res.Feasible:
+		res.Message = "Transfer is feasible"
+	case len(res.Failed) > 0:
+		res.Message = "Transfer is not feasible, some addresses failed"
+	default:
+		res.Message = "Transfer is not feasible"
+	}
+	return res, nil
+}
@@ -0,0 +1,223 @@
+package wallet
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rubixchain/rubixgoplatform/block"
+	tkn "github.com/rubixchain/rubixgoplatform/token"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// The part token history indexes the part token chain blocks stored by the
+// node at a height shared by all the chains, every block stored takes the
+// next height. The holder index lists the tokens owned by a DID from a
+// height and the block index the owner of a token from a height, so that the
+// holdings at a height are read without going through the chains.
+const (
+	historyTipKey     string = "hh-tip"
+	historyHolderType string = "ho"
+	historyBlockType  string = "hb"
+)
+
+type historyEntry struct {
+	Owner string `json:"owner"`
+	Burnt bool   `json:"burnt"`
+}
+
+func historyHeight(height uint64) string {
+	return fmt.Sprintf("%016x", height)
+}
+
+func historyHolderPrefix(did string) string {
+	return historyHolderType + "-" + did + "-"
+}
+
+func historyBlockPrefix(token string) string {
+	return historyBlockType + "-" + token + "-"
+}
+
+func isPartTokenType(tt int) bool {
+	return tt == tkn.PartTokenType || tt == tkn.TestPartTokenType
+}
+
+// putHistory adds the index entries of the block to the batch at the height
+func putHistory(batch *leveldb.Batch, height uint64, token string, b *block.Block) error {
+	e := historyEntry{
+		Owner: b.GetOwner(),
+		Burnt: b.GetTransType() == block.TokenBurntType,
+	}
+	eb, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	batch.Put([]byte(historyHolderPrefix(e.Owner)+historyHeight(height)+"-"+token), nil)
+	batch.Put([]byte(historyBlockPrefix(token)+historyHeight(height)), eb)
+	return nil
+}
+
+// initPartTokenHistory will load the history tip, the chains stored before
+// the history was indexed are indexed once at height zero
+func (w *Wallet) initPartTokenHistory() error {
+	db := w.tcs
+	v, err := db.Get([]byte(historyTipKey), nil)
+	if err == nil {
+		w.historyTip, err = strconv.ParseUint(string(v), 16, 64)
+		return err
+	}
+	if err != leveldb.ErrNotFound {
+		return err
+	}
+	batch := new(leveldb.Batch)
+	for _, tt := range []int{tkn.PartTokenType, tkn.TestPartTokenType} {
+		iter := db.NewIterator(util.BytesPrefix([]byte(tcsType(tt))), nil)
+		for iter.Next() {
+			ks := strings.Split(string(iter.Key()), "-")
+			if len(ks) < 3 {
+				continue
+			}
+			blk := append([]byte(nil), iter.Value()...)
+			if string(blk[0:2]) == ReferenceType {
+				blk, err = w.getRawBlock(db, blk)
+				if err != nil {
+					iter.Release()
+					return err
+				}
+			}
+			b := block.InitBlock(blk, nil)
+			if b == nil {
+				iter.Release()
+				return fmt.Errorf("invalid token chain block of %s", ks[1])
+			}
+			// The blocks of the token are in the block number order, the
+			// latest one is the last put in the block index
+			err = putHistory(batch, 0, ks[1], b)
+			if err != nil {
+				iter.Release()
+				return err
+			}
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+	batch.Put([]byte(historyTipKey), []byte(historyHeight(0)))
+	err = db.Write(batch, &opt.WriteOptions{Sync: true})
+	if err != nil {
+		return err
+	}
+	w.historyTip = 0
+	return nil
+}
+
+// addHistory will index the part token chain block stored for the tokens at
+// the next height
+func (w *Wallet) addHistory(db *ChainDB, tokens []string, b *block.Block) error {
+	if db != w.tcs {
+		return nil
+	}
+	db.l.Lock()
+	defer db.l.Unlock()
+	height := w.historyTip + 1
+	batch := new(leveldb.Batch)
+	for _, token := range tokens {
+		if !isPartTokenType(b.GetTokenType(token)) {
+			continue
+		}
+		err := putHistory(batch, height, token, b)
+		if err != nil {
+			return err
+		}
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+	batch.Put([]byte(historyTipKey), []byte(historyHeight(height)))
+	err := db.Write(batch, &opt.WriteOptions{Sync: true})
+	if err != nil {
+		return err
+	}
+	w.historyTip = height
+	return nil
+}
+
+// PartTokenHistoryTip returns the height of the latest part token chain
+// block stored by the node
+func (w *Wallet) PartTokenHistoryTip() uint64 {
+	w.tcs.l.Lock()
+	defer w.tcs.l.Unlock()
+	return w.historyTip
+}
+
+// PartTokensHeldAt will get the part tokens held by the DID at the history
+// height, the tokens owned by the DID at any height up to it are looked up in
+// the block index. The value of the tokens is read from the wallet, the ones
+// which the DID no longer holds are reported as transferred.
+func (w *Wallet) PartTokensHeldAt(did string, height uint64) ([]Token, error) {
+	db := w.tcs
+	prefix := historyHolderPrefix(did)
+	iter := db.NewIterator(&util.Range{
+		Start: []byte(prefix),
+		Limit: []byte(prefix + historyHeight(height+1)),
+	}, nil)
+	owned := make(map[string]bool)
+	for iter.Next() {
+		ks := strings.Split(strings.TrimPrefix(string(iter.Key()), prefix), "-")
+		if len(ks) == 2 {
+			owned[ks[1]] = true
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	held := make([]Token, 0, len(owned))
+	for token := range owned {
+		e, err := w.historyAt(token, height)
+		if err != nil {
+			return nil, err
+		}
+		if e == nil || e.Owner != did || e.Burnt {
+			continue
+		}
+		var t Token
+		err = w.s.Read(TokenStorage, &t, "token_id=?", token)
+		if err != nil {
+			w.log.Error("Failed to read the part token of the history", "token", token, "err", err)
+			return nil, err
+		}
+		if t.DID != did {
+			t.DID = did
+			t.TokenStatus = TokenIsTransferred
+		}
+		held = append(held, t)
+	}
+	sort.Slice(held, func(i, j int) bool { return held[i].TokenID < held[j].TokenID })
+	return held, nil
+}
+
+// historyAt returns the block index entry of the token at the height, nil if
+// the token chain starts after it
+func (w *Wallet) historyAt(token string, height uint64) (*historyEntry, error) {
+	prefix := historyBlockPrefix(token)
+	iter := w.tcs.NewIterator(&util.Range{
+		Start: []byte(prefix),
+		Limit: []byte(prefix + historyHeight(height+1)),
+	}, nil)
+	defer iter.Release()
+	if !iter.Last() {
+		return nil, iter.Error()
+	}
+	var e historyEntry
+	err := json.Unmarshal(iter.Value(), &e)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
@@ -0,0 +1,264 @@
+package wallet
+
+import (
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/rubixchain/rubixgoplatform/block"
+	"github.com/rubixchain/rubixgoplatform/core/storage"
+	tkn "github.com/rubixchain/rubixgoplatform/token"
+	"github.com/rubixchain/rubixgoplatform/wrapper/config"
+	"github.com/rubixchain/rubixgoplatform/wrapper/logger"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func initTestWallet(t *testing.T) *Wallet {
+	dir := t.TempDir() + "/"
+	log := logger.New(&logger.LoggerOptions{
+		Level:  logger.Error,
+		Color:  []logger.ColorOption{logger.ColorOff},
+		Output: []io.Writer{io.Discard},
+	})
+	s, err := storage.NewStorageDB(&config.Config{DBAddress: dir + "rubix.db", DBType: "Sqlite3"})
+	if err != nil {
+		t.Fatal("Failed to init DB", err)
+	}
+	w, err := InitWallet(s, dir, log)
+	if err != nil {
+		t.Fatal("Failed to init wallet", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return w
+}
+
+func TestUpdatePartTokensConcurrent(t *testing.T) {
+	w := initTestWallet(t)
+	for _, id := range []string{"pt1", "pt2", "pt3", "pt4"} {
+		err := w.CreateToken(&Token{TokenID: id, TokenValue: 0.5, DID: "did1", TokenStatus: TokenIsFree})
+		if err != nil {
+			t.Fatal("Failed to create token", err)
+		}
+	}
+	lock := func(ids ...string) func([]Token) ([]Token, error) {
+		return func(pt []Token) ([]Token, error) {
+			ut := make([]Token, 0)
+			for _, t := range pt {
+				for _, id := range ids {
+					if t.TokenID == id {
+						t.TokenStatus = TokenIsLocked
+						ut = append(ut, t)
+					}
+				}
+			}
+			return ut, nil
+		}
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs[0] = w.UpdatePartTokens("did1", lock("pt1", "pt2"))
+	}()
+	go func() {
+		defer wg.Done()
+		errs[1] = w.UpdatePartTokens("did1", lock("pt3"))
+	}()
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal("Failed to update part tokens", err)
+		}
+	}
+	pt, err := w.ReadAllPartTokens("did1")
+	if err != nil {
+		t.Fatal("Failed to read part tokens", err)
+	}
+	for _, tk := range pt {
+		locked := tk.TokenStatus == TokenIsLocked
+		if locked != (tk.TokenID != "pt4") {
+			t.Fatalf("Invalid status %d for token %s", tk.TokenStatus, tk.TokenID)
+		}
+	}
+}
+
+func TestMovePartTokens(t *testing.T) {
+	w := initTestWallet(t)
+	tokens := []Token{
+		{TokenID: "pt1", TokenValue: 0.5, DID: "did1", TokenStatus: TokenIsFree},
+		{TokenID: "pt2", TokenValue: 0.25, DID: "did1", TokenStatus: TokenIsFree},
+		{TokenID: "pt3", TokenValue: 0.1, DID: "did1", TokenStatus: TokenIsLocked},
+		{TokenID: "pt4", TokenValue: 0.2, DID: "did2", TokenStatus: TokenIsFree},
+	}
+	for i := range tokens {
+		if err := w.CreateToken(&tokens[i]); err != nil {
+			t.Fatal("Failed to create token", err)
+		}
+	}
+	owner := func(id string) string {
+		tk, err := w.ReadToken(id)
+		if err != nil {
+			t.Fatal("Failed to read token", err)
+		}
+		return tk.DID
+	}
+
+	if err := w.MovePartTokens("did1", "did3", []string{"pt1"}); err != nil {
+		t.Fatal("Failed to move part tokens", err)
+	}
+	if owner("pt1") != "did3" {
+		t.Fatal("Token not moved")
+	}
+
+	// pt4 belongs to did2, none of the tokens should move
+	if err := w.MovePartTokens("did1", "did3", []string{"pt2", "pt4"}); err == nil {
+		t.Fatal("Expected failure for token of another DID")
+	}
+	if owner("pt2") != "did1" || owner("pt4") != "did2" {
+		t.Fatal("Tokens moved on partial failure")
+	}
+
+	if err := w.MovePartTokens("did1", "did3", []string{"pt2", "pt3"}); err == nil {
+		t.Fatal("Expected failure for locked token")
+	}
+	if owner("pt2") != "did1" || owner("pt3") != "did1" {
+		t.Fatal("Tokens moved with a locked token")
+	}
+}
+
+func TestTokenWriteHook(t *testing.T) {
+	w := initTestWallet(t)
+	var dids []string
+	w.SetTokenWriteHook(func(did string) {
+		dids = append(dids, did)
+	})
+	if err := w.CreateToken(&Token{TokenID: "pt1", TokenValue: 0.5, DID: "did1", TokenStatus: TokenIsFree}); err != nil {
+		t.Fatal("Failed to create token", err)
+	}
+	if len(dids) != 1 || dids[0] != "did1" {
+		t.Fatalf("Invalid DIDs reported for the create, %v", dids)
+	}
+	dids = nil
+	if err := w.MovePartTokens("did1", "did2", []string{"pt1"}); err != nil {
+		t.Fatal("Failed to move part tokens", err)
+	}
+	// both the source and the destination DID are touched
+	if len(dids) != 2 || dids[0] != "did2" || dids[1] != "did1" {
+		t.Fatalf("Invalid DIDs reported for the move, %v", dids)
+	}
+
+	// The previous owner of a received token is reported with the new one
+	for token, did := range map[string]string{"pt2": "did3", "pt3": "did3", "pt4": "did4"} {
+		tk := &Token{TokenID: token, TokenValue: 0.5, DID: did, TokenStatus: TokenIsFree}
+		if err := w.CreateToken(tk); err != nil {
+			t.Fatal("Failed to create token", err)
+		}
+	}
+	dids = nil
+	if err := w.ReleaseTokens([]Token{{TokenID: "pt2"}}); err != nil {
+		t.Fatal("Failed to release tokens", err)
+	}
+	if len(dids) != 0 {
+		t.Fatalf("DIDs reported without a write, %v", dids)
+	}
+	if _, err := w.GetAllPartTokens("did3"); err != nil {
+		t.Fatal("Failed to lock part tokens", err)
+	}
+	if len(dids) != 1 || dids[0] != "did3" {
+		t.Fatalf("Invalid DIDs reported for the lock, %v", dids)
+	}
+	dids = nil
+	if err := w.ReleaseTokens([]Token{{TokenID: "pt2"}, {TokenID: "pt3"}}); err != nil {
+		t.Fatal("Failed to release tokens", err)
+	}
+	if len(dids) != 1 || dids[0] != "did3" {
+		t.Fatalf("Invalid DIDs reported for the release, %v", dids)
+	}
+	dids = nil
+	if err := w.RemoveTokens([]Token{{TokenID: "pt2", DID: "did3"}, {TokenID: "pt4", DID: "did4"}}); err != nil {
+		t.Fatal("Failed to remove tokens", err)
+	}
+	if len(dids) != 2 || dids[0] != "did3" || dids[1] != "did4" {
+		t.Fatalf("Invalid DIDs reported for the remove, %v", dids)
+	}
+}
+
+func TestWalletClose(t *testing.T) {
+	w := initTestWallet(t)
+	if err := w.Close(); err != nil {
+		t.Fatal("Failed to close the wallet", err)
+	}
+	// The chain storages are the databases closed
+	for _, cs := range []*ChainDB{w.tcs, w.ntcs, w.dtcs, w.smartContractTokenChainStorage} {
+		if _, err := cs.Get([]byte("key"), nil); err != leveldb.ErrClosed {
+			t.Fatalf("Expected the chain storage closed, got %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("Second wallet close failed", err)
+	}
+}
+
+func TestPartTokenHistoryBackfill(t *testing.T) {
+	w := initTestWallet(t)
+	token := "QmTestPartToken0000000000000000000000000000001"
+	if err := w.CreateToken(&Token{TokenID: token, TokenValue: 0.5, DID: "did2", TokenStatus: TokenIsFree}); err != nil {
+		t.Fatal("Failed to create token", err)
+	}
+	var prev *block.Block
+	for n, owner := range []string{"did1", "did2"} {
+		tcb := &block.TokenChainBlock{
+			TransactionType: block.TokenTransferredType,
+			TokenOwner:      owner,
+			TransInfo: &block.TransInfo{
+				Tokens: []block.TransTokens{{Token: token, TokenType: tkn.PartTokenType}},
+			},
+		}
+		if n == 0 {
+			tcb.TransactionType = block.TokenGeneratedType
+			tcb.GenesisBlock = &block.GenesisBlock{Info: []block.GenesisTokenInfo{{Token: token}}}
+		}
+		b := block.CreateNewBlock(map[string]*block.Block{token: prev}, tcb)
+		if b == nil {
+			t.Fatal("Failed to create token block")
+		}
+		if err := b.ReplaceSignature(owner, "TestSignature"); err != nil {
+			t.Fatal("Failed to sign token block", err)
+		}
+		if err := w.AddTokenBlock(token, b); err != nil {
+			t.Fatal("Failed to add token block", err)
+		}
+		prev = b
+	}
+	if tip := w.PartTokenHistoryTip(); tip != 2 {
+		t.Fatalf("Expected the tip 2, got %d", tip)
+	}
+
+	// Drop the index as before the history was indexed
+	for _, p := range []string{"hh-", historyHolderType + "-", historyBlockType + "-"} {
+		iter := w.tcs.NewIterator(util.BytesPrefix([]byte(p)), nil)
+		for iter.Next() {
+			if err := w.tcs.Delete(iter.Key(), nil); err != nil {
+				t.Fatal("Failed to drop the index", err)
+			}
+		}
+		iter.Release()
+	}
+	if err := w.initPartTokenHistory(); err != nil {
+		t.Fatal("Failed to index the history", err)
+	}
+	if tip := w.PartTokenHistoryTip(); tip != 0 {
+		t.Fatalf("Expected the stored chains at height 0, got %d", tip)
+	}
+	for did, n := range map[string]int{"did1": 0, "did2": 1} {
+		held, err := w.PartTokensHeldAt(did, 0)
+		if err != nil {
+			t.Fatal("Failed to get the part tokens", err)
+		}
+		if len(held) != n {
+			t.Fatalf("Expected %d part tokens of %s from the latest block, got %v", n, did, held)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package wallet
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/rubixchain/rubixgoplatform/block"
 	"github.com/rubixchain/rubixgoplatform/contract"
@@ -30,6 +31,13 @@ const (
 	Zero int = iota
 	One
 )
+const (
+	PartTokenBatchSize int = 100
+)
+
+// Part tokens of the DID which are still held by the DID
+const partTokenQuery string = "did=? AND token_value>? AND token_value<? AND token_status IN (?,?,?)"
+
 const (
 	RACTestTokenType int = iota
 	RACOldNFTType
@@ -42,10 +50,21 @@ type Token struct {
 	TokenValue    float64 `gorm:"column:token_value"`
 	DID           string  `gorm:"column:did"`
 	TokenStatus   int     `gorm:"column:token_status;"`
+	// AcquiredAt is the time the DID got the token, zero for the tokens
+	// stored before it was recorded
+	AcquiredAt time.Time `gorm:"column:acquired_at"`
 }
 
 func (w *Wallet) CreateToken(t *Token) error {
-	return w.s.Write(TokenStorage, t)
+	if t.AcquiredAt.IsZero() {
+		t.AcquiredAt = time.Now()
+	}
+	err := w.s.Write(TokenStorage, t)
+	if err != nil {
+		return err
+	}
+	w.tokensWritten(t.DID)
+	return nil
 }
 
 func (w *Wallet) PledgeWholeToken(did string, token string, b *block.Block) error {
@@ -68,6 +87,7 @@ func (w *Wallet) PledgeWholeToken(did string, token string, b *block.Block) erro
 		w.log.Error("Failed to update token", "token", token, "err", err)
 		return err
 	}
+	w.tokensWritten(did)
 
 	return nil
 }
@@ -98,6 +118,7 @@ func (w *Wallet) UnpledgeWholeToken(did string, token string, tt int) error {
 		w.log.Error("Failed to update token", "token", token, "err", err)
 		return err
 	}
+	w.tokensWritten(did)
 	return nil
 }
 
@@ -156,6 +177,7 @@ func (w *Wallet) GetWholeTokens(did string, num int) ([]Token, int, error) {
 	for i := 0; i < tl; i++ {
 		wt = append(wt, t[i])
 	}
+	defer w.tokensWritten(did)
 	for i := range wt {
 		wt[i].TokenStatus = TokenIsLocked
 		err = w.s.Update(TokenStorage, &wt[i], "did=? AND token_id=?", did, wt[i].TokenID)
@@ -176,6 +198,7 @@ func (w *Wallet) GetTokensByLimit(did string, limit float64) ([]Token, error) {
 		w.log.Error("Failed to get tokens", "err", err)
 		return nil, err
 	}
+	defer w.tokensWritten(did)
 	for i := range t {
 		t[i].TokenStatus = TokenIsLocked
 		err = w.s.Update(TokenStorage, &t[i], "did=? AND token_id=?", did, t[i].TokenID)
@@ -204,6 +227,7 @@ func (w *Wallet) GetTokens(did string, amt float64) ([]Token, error) {
 	for i := 0; i < int(amt); i++ {
 		wt = append(wt, t[i])
 	}
+	defer w.tokensWritten(did)
 	for i := range wt {
 		wt[i].TokenStatus = TokenIsLocked
 		err = w.s.Update(TokenStorage, &wt[i], "did=? AND token_id=?", did, wt[i].TokenID)
@@ -231,6 +255,7 @@ func (w *Wallet) GetToken(token string, token_Status int) (*Token, error) {
 		w.log.Error("Failed to update token status", "err", err)
 		return nil, err
 	}
+	w.tokensWritten(t.DID)
 	return &t, nil
 }
 
@@ -250,12 +275,19 @@ func (w *Wallet) LockToken(wt *Token) error {
 	w.l.Lock()
 	defer w.l.Unlock()
 	wt.TokenStatus = TokenIsLocked
-	return w.s.Update(TokenStorage, wt, "did=? AND token_id=?", wt.DID, wt.TokenID)
+	err := w.s.Update(TokenStorage, wt, "did=? AND token_id=?", wt.DID, wt.TokenID)
+	if err != nil {
+		return err
+	}
+	w.tokensWritten(wt.DID)
+	return nil
 }
 
 func (w *Wallet) ReleaseTokens(wt []Token) error {
 	w.l.Lock()
 	defer w.l.Unlock()
+	dids := make([]string, 0)
+	defer func() { w.tokensWritten(dids...) }()
 	for i := range wt {
 		var t Token
 		err := w.s.Read(TokenStorage, &t, "token_id=?", wt[i].TokenID)
@@ -270,6 +302,7 @@ func (w *Wallet) ReleaseTokens(wt []Token) error {
 				w.log.Error("Failed to update token", "err", err)
 				return err
 			}
+			dids = append(dids, t.DID)
 		}
 	}
 	return nil
@@ -291,6 +324,7 @@ func (w *Wallet) ReleaseToken(token string) error {
 			w.log.Error("Failed to update token", "err", err)
 			return err
 		}
+		w.tokensWritten(t.DID)
 	}
 	return nil
 }
@@ -298,11 +332,14 @@ func (w *Wallet) ReleaseToken(token string) error {
 func (w *Wallet) RemoveTokens(wt []Token) error {
 	w.l.Lock()
 	defer w.l.Unlock()
+	dids := make([]string, 0)
+	defer func() { w.tokensWritten(dids...) }()
 	for i := range wt {
 		err := w.s.Delete(TokenStorage, &Token{}, "did=? AND token_id=?", wt[i].DID, wt[i].TokenID)
 		if err != nil {
 			return err
 		}
+		dids = append(dids, wt[i].DID)
 	}
 	return nil
 }
@@ -314,6 +351,7 @@ func (w *Wallet) ClearTokens(did string) error {
 	if err != nil {
 		return err
 	}
+	w.tokensWritten(did)
 	return nil
 }
 
@@ -324,6 +362,7 @@ func (w *Wallet) UpdateToken(t *Token) error {
 	if err != nil {
 		return err
 	}
+	w.tokensWritten(t.DID)
 	return nil
 }
 
@@ -337,6 +376,7 @@ func (w *Wallet) TokensTransferred(did string, ti []contract.TokenInfo, b *block
 		if err != nil {
 			return err
 		}
+		defer w.tokensWritten(did)
 		for i := range ti {
 			var t Token
 			err := w.s.Read(TokenStorage, &t, "did=? AND token_id=?", did, ti[i].Token)
@@ -383,6 +423,9 @@ func (w *Wallet) TokensReceived(did string, ti []contract.TokenInfo, b *block.Bl
 		return err
 	}
 
+	// The tokens are taken from their previous owners
+	dids := []string{did}
+	defer func() { w.tokensWritten(dids...) }()
 	for i := range ti {
 		var t Token
 		err := w.s.Read(TokenStorage, &t, "token_id=?", ti[i].Token)
@@ -416,8 +459,10 @@ func (w *Wallet) TokensReceived(did string, ti []contract.TokenInfo, b *block.Bl
 			}
 		}
 
+		dids = append(dids, t.DID)
 		t.DID = did
 		t.TokenStatus = TokenIsFree
+		t.AcquiredAt = time.Now()
 		err = w.s.Update(TokenStorage, &t, "token_id=?", ti[i].Token)
 		if err != nil {
 			return err
@@ -454,6 +499,7 @@ func (w *Wallet) TokensReceived(did string, ti []contract.TokenInfo, b *block.Bl
 func (w *Wallet) CommitTokens(did string, rbtTokens []string) error {
 	w.l.Lock()
 	defer w.l.Unlock()
+	defer w.tokensWritten(did)
 	for i := range rbtTokens {
 		var t Token
 		err := w.s.Read(TokenStorage, &t, "did=? AND token_id=?", did, rbtTokens[i])
@@ -478,6 +524,7 @@ func (w *Wallet) GetAllPartTokens(did string) ([]Token, error) {
 		w.log.Error("Failed to get tokens", "err", err)
 		return nil, err
 	}
+	defer w.tokensWritten(did)
 	for i := range t {
 		t[i].TokenStatus = TokenIsLocked
 		err = w.s.Update(TokenStorage, &t[i], "did=? AND token_id=?", did, t[i].TokenID)
@@ -489,6 +536,177 @@ func (w *Wallet) GetAllPartTokens(did string) ([]Token, error) {
 	return t, nil
 }
 
+// ReadPartTokenRecords will read all the part tokens recorded for the DID,
+// including the transferred and burnt ones
+func (w *Wallet) ReadPartTokenRecords(did string) ([]Token, error) {
+	w.l.Lock()
+	defer w.l.Unlock()
+	var t []Token
+	err := w.s.Read(TokenStorage, &t, "did=? AND token_value>? AND token_value<?", did, Zero, One)
+	if err != nil {
+		if err.Error() == "no records found" {
+			return make([]Token, 0), nil
+		}
+		w.log.Error("Failed to get part tokens", "err", err)
+		return nil, err
+	}
+	return t, nil
+}
+
+// ForEachPartToken will call the function for each part token held by the
+// DID, the tokens are read in batches of PartTokenBatchSize so that all of
+// them are never in memory. The wallet is locked only while a batch is read,
+// not while the function runs, the batches are read in the token ID order so
+// that the tokens written in between do not shift them. It stops at the first
+// error of the function.
+func (w *Wallet) ForEachPartToken(did string, fn func(t *Token) error) error {
+	last := ""
+	for {
+		t, err := w.readPartTokenBatch(did, last)
+		if err != nil {
+			return err
+		}
+		for i := range t {
+			if err := fn(&t[i]); err != nil {
+				return err
+			}
+		}
+		if len(t) < PartTokenBatchSize {
+			return nil
+		}
+		last = t[len(t)-1].TokenID
+	}
+}
+
+// readPartTokenBatch will read the next batch of the part tokens of the DID
+// after the token ID
+func (w *Wallet) readPartTokenBatch(did string, after string) ([]Token, error) {
+	w.l.Lock()
+	defer w.l.Unlock()
+	var t []Token
+	err := w.s.ReadWithOffset(TokenStorage, 0, PartTokenBatchSize, &t, partTokenQuery+" AND token_id>? ORDER BY token_id", did, Zero, One, TokenIsFree, TokenIsLocked, TokenIsPledged, after)
+	if err != nil {
+		if err.Error() == "no records found" {
+			return nil, nil
+		}
+		w.log.Error("Failed to get part tokens", "err", err)
+		return nil, err
+	}
+	return t, nil
+}
+
+// ReadAllPartTokens will read all the part tokens held by the DID without
+// locking them, tokens that are transferred or burnt are not included
+func (w *Wallet) ReadAllPartTokens(did string) ([]Token, error) {
+	w.l.Lock()
+	defer w.l.Unlock()
+	return w.readAllPartTokens(did)
+}
+
+func (w *Wallet) readAllPartTokens(did string) ([]Token, error) {
+	var t []Token
+	err := w.s.Read(TokenStorage, &t, partTokenQuery, did, Zero, One, TokenIsFree, TokenIsLocked, TokenIsPledged)
+	if err != nil {
+		if err.Error() == "no records found" {
+			return make([]Token, 0), nil
+		}
+		w.log.Error("Failed to get part tokens", "err", err)
+		return nil, err
+	}
+	return t, nil
+}
+
+// UpdatePartTokens will read the part tokens of the DID, apply the update
+// function and write back the returned tokens under the wallet lock, so that
+// concurrent fetch-then-update flows do not lose each others updates. If any
+// write fails the tokens written so far are restored.
+func (w *Wallet) UpdatePartTokens(did string, fn func([]Token) ([]Token, error)) error {
+	w.l.Lock()
+	defer w.l.Unlock()
+	pt, err := w.readAllPartTokens(did)
+	if err != nil {
+		return err
+	}
+	org := make(map[string]Token)
+	for _, t := range pt {
+		org[t.TokenID] = t
+	}
+	ut, err := fn(pt)
+	if err != nil {
+		return err
+	}
+	defer w.tokensWritten(did)
+	for i := range ut {
+		if _, ok := org[ut[i].TokenID]; !ok {
+			w.rollbackPartTokens(ut[:i], org)
+			return fmt.Errorf("token %s is not a part token of the DID", ut[i].TokenID)
+		}
+		err = w.s.Update(TokenStorage, &ut[i], "did=? AND token_id=?", did, ut[i].TokenID)
+		if err != nil {
+			w.log.Error("Failed to update part token", "token", ut[i].TokenID, "err", err)
+			w.rollbackPartTokens(ut[:i], org)
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Wallet) rollbackPartTokens(ut []Token, org map[string]Token) {
+	for i := range ut {
+		t := org[ut[i].TokenID]
+		err := w.s.Update(TokenStorage, &t, "token_id=?", t.TokenID)
+		if err != nil {
+			w.log.Error("Failed to restore part token", "token", t.TokenID, "err", err)
+		}
+	}
+}
+
+// MovePartTokens will reassign the part tokens from one DID to another DID,
+// all the tokens must be free part tokens of the source DID, otherwise none
+// of the tokens are moved
+func (w *Wallet) MovePartTokens(fromDID string, toDID string, tokenIDs []string) error {
+	w.l.Lock()
+	defer w.l.Unlock()
+	if len(tokenIDs) == 0 {
+		return fmt.Errorf("no tokens to move")
+	}
+	org := make(map[string]Token)
+	mt := make([]Token, 0, len(tokenIDs))
+	for _, id := range tokenIDs {
+		if _, ok := org[id]; ok {
+			return fmt.Errorf("token %s is repeated", id)
+		}
+		var t Token
+		err := w.s.Read(TokenStorage, &t, "token_id=?", id)
+		if err != nil {
+			return fmt.Errorf("token %s not found", id)
+		}
+		if t.DID != fromDID {
+			return fmt.Errorf("token %s does not belong to the DID", id)
+		}
+		if t.TokenValue <= 0 || t.TokenValue >= 1 {
+			return fmt.Errorf("token %s is not a part token", id)
+		}
+		if t.TokenStatus != TokenIsFree {
+			return fmt.Errorf("token %s is not free", id)
+		}
+		org[id] = t
+		t.DID = toDID
+		t.AcquiredAt = time.Now()
+		mt = append(mt, t)
+	}
+	defer w.tokensWritten(toDID, fromDID)
+	for i := range mt {
+		err := w.s.Update(TokenStorage, &mt[i], "did=? AND token_id=?", fromDID, mt[i].TokenID)
+		if err != nil {
+			w.log.Error("Failed to move part token", "token", mt[i].TokenID, "err", err)
+			w.rollbackPartTokens(mt[:i], org)
+			return err
+		}
+	}
+	return nil
+}
+
 func (w *Wallet) GetAllWholeTokens(did string) ([]Token, error) {
 	w.l.Lock()
 	defer w.l.Unlock()
@@ -498,6 +716,7 @@ func (w *Wallet) GetAllWholeTokens(did string) ([]Token, error) {
 		w.log.Error("Failed to get tokens", "err", err)
 		return nil, err
 	}
+	defer w.tokensWritten(did)
 	for i := range t {
 		t[i].TokenStatus = TokenIsLocked
 		err = w.s.Update(TokenStorage, &t[i], "did=? AND token_id=?", did, t[i].TokenID)
@@ -509,6 +728,23 @@ func (w *Wallet) GetAllWholeTokens(did string) ([]Token, error) {
 	return t, nil
 }
 
+// ReadAllWholeTokens will read the whole tokens held by the DID, free,
+// locked or pledged, without changing their status
+func (w *Wallet) ReadAllWholeTokens(did string) ([]Token, error) {
+	w.l.Lock()
+	defer w.l.Unlock()
+	var t []Token
+	err := w.s.Read(TokenStorage, &t, "did=? AND token_value=? AND token_status IN (?,?,?)", did, One, TokenIsFree, TokenIsLocked, TokenIsPledged)
+	if err != nil {
+		if err.Error() == "no records found" {
+			return make([]Token, 0), nil
+		}
+		w.log.Error("Failed to get whole tokens", "err", err)
+		return nil, err
+	}
+	return t, nil
+}
+
 /* func (w *Wallet) UpdateChildTokenStatusToOrphan(tokenHash string) (error){
 	w.l.Lock()
 	defer w.l.Unlock()
@@ -528,6 +764,7 @@ func (w *Wallet) GetChildToken(did string, parentTokenID string) ([]Token, error
 		w.log.Error("Failed to get tokens", "err", err)
 		return nil, err
 	}
+	defer w.tokensWritten(did)
 	for i := range t {
 		t[i].TokenStatus = TokenIsLocked
 		err = w.s.Update(TokenStorage, &t[i], "did=? AND token_id=?", did, t[i].TokenID)
@@ -563,6 +800,8 @@ func (w *Wallet) ReleaseAllLockedTokens() error {
 		w.log.Info("No Loked tokens to release")
 		return nil
 	}
+	dids := make([]string, 0)
+	defer func() { w.tokensWritten(dids...) }()
 	for _, t := range lockedTokens {
 		t.TokenStatus = TokenIsFree
 		err = w.s.Update(TokenStorage, &t, "token_id=?", t.TokenID)
@@ -570,6 +809,7 @@ func (w *Wallet) ReleaseAllLockedTokens() error {
 			w.log.Error("Failed to update token", "err", err)
 			return err
 		}
+		dids = append(dids, t.DID)
 	}
 	return nil
 }
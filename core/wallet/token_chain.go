@@ -413,7 +413,6 @@ func (w *Wallet) addBlock(token string, b *block.Block) error {
 		db.l.Lock()
 		err = db.Put([]byte(key), refkey, opt)
 		db.l.Unlock()
-		return err
 	} else {
 		db.l.Lock()
 		err = db.Put([]byte(key), b.GetBlock(), opt)
@@ -421,8 +420,11 @@ func (w *Wallet) addBlock(token string, b *block.Block) error {
 			w.log.Debug("Writtent", "key", key)
 		}
 		db.l.Unlock()
+	}
+	if err != nil {
 		return err
 	}
+	return w.addHistory(db, []string{token}, b)
 }
 
 // addBlock will write block into storage
@@ -522,7 +524,7 @@ func (w *Wallet) addBlocks(b *block.Block) error {
 			return err
 		}
 	}
-	return nil
+	return w.addHistory(db, tokens, b)
 }
 
 // GetTokenBlock get token chain block from the storage
@@ -0,0 +1,29 @@
+package wallet
+
+// SetTokenWriteHook sets the function called with the DID of the tokens
+// written to the wallet, the wallet methods writing the token storage call it
+// once per DID they wrote the tokens of, e.g. to evict the cached part tokens
+// of the DID. A token moved to another DID reports both the DIDs.
+func (w *Wallet) SetTokenWriteHook(fn func(did string)) {
+	w.hl.Lock()
+	defer w.hl.Unlock()
+	w.twh = fn
+}
+
+// tokensWritten will call the token write hook once for each DID
+func (w *Wallet) tokensWritten(dids ...string) {
+	w.hl.Lock()
+	fn := w.twh
+	w.hl.Unlock()
+	if fn == nil {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, did := range dids {
+		if did == "" || seen[did] {
+			continue
+		}
+		seen[did] = true
+		fn(did)
+	}
+}
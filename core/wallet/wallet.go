@@ -41,7 +41,7 @@ type WalletConfig struct {
 }
 
 type ChainDB struct {
-	leveldb.DB
+	*leveldb.DB
 	l sync.Mutex
 }
 
@@ -52,10 +52,15 @@ type Wallet struct {
 	dtl                            sync.Mutex
 	log                            logger.Logger
 	wl                             sync.Mutex
+	hl                             sync.Mutex
+	twh                            func(did string)
 	tcs                            *ChainDB
 	dtcs                           *ChainDB
 	ntcs                           *ChainDB
 	smartContractTokenChainStorage *ChainDB
+	closed                         bool
+	// The part token history height, guarded by the lock of tcs
+	historyTip uint64
 }
 
 func InitWallet(s storage.Storage, dir string, log logger.Logger) (*Wallet, error) {
@@ -77,19 +82,24 @@ func InitWallet(s storage.Storage, dir string, log logger.Logger) (*Wallet, erro
 		w.log.Error("failed to configure token chain block storage", "err", err)
 		return nil, fmt.Errorf("failed to configure token chain block storage")
 	}
-	w.tcs.DB = *tdb
+	w.tcs.DB = tdb
+	err = w.initPartTokenHistory()
+	if err != nil {
+		w.log.Error("failed to index the part token history", "err", err)
+		return nil, fmt.Errorf("failed to index the part token history")
+	}
 	ntdb, err := leveldb.OpenFile(dir+NFTChainStorage, op)
 	if err != nil {
 		w.log.Error("failed to configure NFT chain block storage", "err", err)
 		return nil, fmt.Errorf("failed to configure NFT chain block storage")
 	}
-	w.ntcs.DB = *ntdb
+	w.ntcs.DB = ntdb
 	dtdb, err := leveldb.OpenFile(dir+DataChainStorage, op)
 	if err != nil {
 		w.log.Error("failed to configure data chain block storage", "err", err)
 		return nil, fmt.Errorf("failed to configure data chain block storage")
 	}
-	w.dtcs.DB = *dtdb
+	w.dtcs.DB = dtdb
 	err = w.s.Init(DIDStorage, &DIDType{}, true)
 	if err != nil {
 		w.log.Error("Failed to initialize DID storage", "err", err)
@@ -141,7 +151,7 @@ func InitWallet(s storage.Storage, dir string, log logger.Logger) (*Wallet, erro
 		w.log.Error("failed to configure token chain block storage", "err", err)
 		return nil, fmt.Errorf("failed to configure token chain block storage")
 	}
-	w.smartContractTokenChainStorage.DB = *smartcontracTokenchainstorageDB
+	w.smartContractTokenChainStorage.DB = smartcontracTokenchainstorageDB
 
 	err = w.s.Init(CallBackUrlStorage, &CallBackUrl{}, true)
 	if err != nil {
@@ -152,6 +162,25 @@ func InitWallet(s storage.Storage, dir string, log logger.Logger) (*Wallet, erro
 	return w, nil
 }
 
+// Close will close the token chain storages of the wallet, the storage is
+// shared with the core which closes it. It can be called more than once.
+func (w *Wallet) Close() error {
+	w.l.Lock()
+	defer w.l.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	var err error
+	for _, cs := range []*ChainDB{w.tcs, w.ntcs, w.dtcs, w.smartContractTokenChainStorage} {
+		if cerr := cs.Close(); cerr != nil {
+			w.log.Error("Failed to close token chain storage", "err", cerr)
+			err = cerr
+		}
+	}
+	return err
+}
+
 func (w *Wallet) SetupWallet(ipfs *ipfsnode.Shell) {
 	w.ipfs = ipfs
 }
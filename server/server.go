@@ -155,6 +155,15 @@ func (s *Server) RegisterRoutes() {
 	s.AddRoute(setup.APIGetTxnByNode, "GET", s.AuthHandle(s.APIGetTxnByNode, true, s.AuthError, false))
 	s.AddRoute(setup.APIRemoveTokenChainBlock, "POST", s.AuthHandle(s.APIRemoveTokenChainBlock, true, s.AuthError, false))
 	s.AddRoute(setup.APIReleaseAllLockedTokens, "GET", s.AuthHandle(s.APIReleaseAllLockedTokens, true, s.AuthError, false))
+	s.AddRoute(setup.APIFetchPartTokens, "POST", s.AuthHandle(s.APIFetchPartTokens, true, s.AuthError, false))
+	s.AddRoute(setup.APIMovePartTokens, "POST", s.AuthHandle(s.APIMovePartTokens, true, s.AuthError, false))
+	s.AddRoute(setup.APIStreamPartTokens, "GET", s.AuthHandle(s.APIStreamPartTokens, true, s.AuthError, false))
+	s.AddRoute(setup.APIVerifyPartTokens, "GET", s.AuthHandle(s.APIVerifyPartTokens, true, s.AuthError, false))
+	s.AddRoute(setup.APIConsolidatePartTokens, "POST", s.AuthHandle(s.APIConsolidatePartTokens, true, s.AuthError, false))
+	s.AddRoute(setup.APIFetchPartTokensAt, "GET", s.AuthHandle(s.APIFetchPartTokensAt, true, s.AuthError, false))
+	s.AddRoute(setup.APIFetchAllTokens, "GET", s.AuthHandle(s.APIFetchAllTokens, true, s.AuthError, false))
+	s.AddRoute(setup.APIPartTokenDenominations, "GET", s.AuthHandle(s.APIPartTokenDenominations, true, s.AuthError, false))
+	s.AddRoute(setup.APIFetchPartTokensMultiDID, "GET", s.AuthHandle(s.APIFetchPartTokensMultiDID, true, s.AuthError, false))
 }
 
 func (s *Server) ExitFunc() error {
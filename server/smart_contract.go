@@ -50,8 +50,8 @@ func (s *Server) APIDeploySmartContract(req *ensweb.Request) *ensweb.Result {
 	if err != nil {
 		return s.BasicResponse(req, false, "Invalid input", nil)
 	}
-	_, did, ok := util.ParseAddress(deployReq.DeployerAddress)
-	if !ok {
+	_, did, err := util.ParseAddress(deployReq.DeployerAddress)
+	if err != nil {
 		return s.BasicResponse(req, false, "Invalid Deployer address", nil)
 	}
 	if !s.validateDIDAccess(req, did) {
@@ -293,8 +293,8 @@ func (s *Server) APIExecuteSmartContract(req *ensweb.Request) *ensweb.Result {
 	if err != nil {
 		return s.BasicResponse(req, false, "Invalid input", nil)
 	}
-	_, did, ok := util.ParseAddress(executeReq.ExecutorAddress)
-	if !ok {
+	_, did, err := util.ParseAddress(executeReq.ExecutorAddress)
+	if err != nil {
 		return s.BasicResponse(req, false, "Invalid Deployer address", nil)
 	}
 	if !s.validateDIDAccess(req, did) {
@@ -1,12 +1,19 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/rubixchain/rubixgoplatform/core"
 	"github.com/rubixchain/rubixgoplatform/core/model"
 	"github.com/rubixchain/rubixgoplatform/did"
 	"github.com/rubixchain/rubixgoplatform/util"
 	"github.com/rubixchain/rubixgoplatform/wrapper/ensweb"
+	"github.com/rubixchain/rubixgoplatform/wrapper/logger"
 )
 
 func (s *Server) APIGetAllTokens(req *ensweb.Request) *ensweb.Result {
@@ -19,6 +26,228 @@ func (s *Server) APIGetAllTokens(req *ensweb.Request) *ensweb.Result {
 	return s.RenderJSON(req, tr, http.StatusOK)
 }
 
+// ShowAccount godoc
+// @Summary      Fetch part tokens
+// @Description  For a mentioned address (DID or <peerID>.<DID>), fetch the part tokens held by the DID
+// @Tags         Account
+// @Accept       json
+// @Produce      json
+// @Param        input body model.FetchPartTokensRequest true "Fetch part tokens"
+// @Success 200 {object} model.FetchPartTokensResponse
+// @Router /api/fetch-part-tokens [post]
+func (s *Server) APIFetchPartTokens(req *ensweb.Request) *ensweb.Result {
+	defer logger.RecoverAndLog(s.c.WithRequest(req))
+	var fr model.FetchPartTokensRequest
+	err := s.ParseJSON(req, &fr)
+	if err != nil {
+		return s.BasicResponse(req, false, "Invalid input", nil)
+	}
+	if _, _, err := util.ParseAddress(fr.Address); err != nil {
+		return s.BasicResponse(req, false, err.Error(), nil)
+	}
+	resp, err := s.c.FetchPartTokens(&fr)
+	if err != nil {
+		return s.BasicResponse(req, false, err.Error(), nil)
+	}
+	return s.RenderJSON(req, resp, http.StatusOK)
+}
+
+// ShowAccount godoc
+// @Summary      Stream part tokens
+// @Description  For a mentioned DID hosted on the node, stream the part tokens as NDJSON, one token per line. A stream failing after it started ends with a model.PartTokenStreamError record.
+// @Tags         Account
+// @Produce      json
+// @Param        did query string true "DID"
+// @Success 200 {object} model.PartTokenDetial
+// @Failure 500 {object} ensweb.ErrMessage
+// @Failure 503 {object} ensweb.ErrMessage
+// @Router /api/stream-part-tokens [get]
+func (s *Server) APIStreamPartTokens(req *ensweb.Request) *ensweb.Result {
+	defer logger.RecoverAndLog(s.c.WithRequest(req))
+	did := s.GetQuerry(req, "did")
+	w := &countingWriter{w: req.GetHTTPWritter()}
+	w.w.Header().Set("Content-Type", "application/x-ndjson")
+	err := s.c.StreamPartTokens(did, w)
+	switch {
+	case err == nil:
+	case err == core.ErrPartTokenDIDNotFound:
+		return s.BasicResponse(req, false, err.Error(), nil)
+	case w.n == 0:
+		// Nothing sent yet, the status tells the failure
+		status := http.StatusInternalServerError
+		if errors.Is(err, core.ErrPartTokensShutdown) {
+			status = http.StatusServiceUnavailable
+		}
+		return s.RenderJSONError(req, status, err.Error(), "")
+	default:
+		// The status is already sent, the client sees the error record in
+		// place of the end of the stream
+		json.NewEncoder(w).Encode(model.PartTokenStreamError{Error: err.Error()})
+	}
+	return &ensweb.Result{Status: http.StatusOK, Done: true}
+}
+
+// countingWriter counts the bytes written to the response
+type countingWriter struct {
+	w http.ResponseWriter
+	n int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n = cw.n + n
+	return n, err
+}
+
+// ShowAccount godoc
+// @Summary      Verify part tokens
+// @Description  For a mentioned DID hosted on the node, verify the part tokens against the token chain and report the discrepancies
+// @Tags         Account
+// @Produce      json
+// @Param        did query string true "DID"
+// @Success 200 {object} model.PartTokenVerificationResult
+// @Router /api/verify-part-tokens [get]
+func (s *Server) APIVerifyPartTokens(req *ensweb.Request) *ensweb.Result {
+	defer logger.RecoverAndLog(s.c.WithRequest(req))
+	did := s.GetQuerry(req, "did")
+	res, err := s.c.VerifyPartTokens(did)
+	if err != nil {
+		return s.BasicResponse(req, false, err.Error(), nil)
+	}
+	return s.RenderJSON(req, res, http.StatusOK)
+}
+
+// ShowAccount godoc
+// @Summary      Fetch part tokens at a history height
+// @Description  For a mentioned DID hosted on the node, get the part tokens held as of the height of the part token history, the height is bumped by every part token chain block stored by the node
+// @Tags         Account
+// @Produce      json
+// @Param        did query string true "DID"
+// @Param        height query int true "History height"
+// @Success 200 {object} model.FetchPartTokensResponse
+// @Router /api/fetch-part-tokens-at [get]
+func (s *Server) APIFetchPartTokensAt(req *ensweb.Request) *ensweb.Result {
+	defer logger.RecoverAndLog(s.c.WithRequest(req))
+	did := s.GetQuerry(req, "did")
+	height, err := strconv.ParseUint(s.GetQuerry(req, "height"), 10, 64)
+	if err != nil {
+		return s.BasicResponse(req, false, "Invalid block height", nil)
+	}
+	resp, err := s.c.FetchPartTokensAt(did, height)
+	if err != nil {
+		return s.BasicResponse(req, false, err.Error(), nil)
+	}
+	return s.RenderJSON(req, resp, http.StatusOK)
+}
+
+// ShowAccount godoc
+// @Summary      Fetch all tokens
+// @Description  For a mentioned DID hosted on the node, get both the part tokens and the whole tokens with their values
+// @Tags         Account
+// @Produce      json
+// @Param        did query string true "DID"
+// @Success 200 {object} model.FetchAllTokensResponse
+// @Router /api/fetch-all-tokens [get]
+func (s *Server) APIFetchAllTokens(req *ensweb.Request) *ensweb.Result {
+	defer logger.RecoverAndLog(s.c.WithRequest(req))
+	did := s.GetQuerry(req, "did")
+	resp, err := s.c.FetchAllTokens(did)
+	if err != nil {
+		return s.BasicResponse(req, false, err.Error(), nil)
+	}
+	return s.RenderJSON(req, resp, http.StatusOK)
+}
+
+// ShowAccount godoc
+// @Summary      Fetch part tokens of multiple DIDs
+// @Description  For the mentioned DIDs hosted on the node, get the part tokens of each DID and the total over all of them
+// @Tags         Account
+// @Produce      json
+// @Param        dids query string true "Comma separated DIDs"
+// @Success 200 {object} model.FetchPartTokensMultiDIDResponse
+// @Router /api/fetch-part-tokens-multi-did [get]
+func (s *Server) APIFetchPartTokensMultiDID(req *ensweb.Request) *ensweb.Result {
+	defer logger.RecoverAndLog(s.c.WithRequest(req))
+	dids := strings.Split(s.GetQuerry(req, "dids"), ",")
+	resp, err := s.c.FetchPartTokensMultiDID(dids)
+	if err != nil {
+		return s.BasicResponse(req, false, err.Error(), nil)
+	}
+	return s.RenderJSON(req, resp, http.StatusOK)
+}
+
+// ShowAccount godoc
+// @Summary      Get part token denominations
+// @Description  For a mentioned DID hosted on the node, get the number of part tokens held per token value
+// @Tags         Account
+// @Produce      json
+// @Param        did query string true "DID"
+// @Success 200 {object} model.PartTokenDenominationsResponse
+// @Router /api/part-token-denominations [get]
+func (s *Server) APIPartTokenDenominations(req *ensweb.Request) *ensweb.Result {
+	defer logger.RecoverAndLog(s.c.WithRequest(req))
+	did := s.GetQuerry(req, "did")
+	dm, err := s.c.PartTokenDenominations(did)
+	if err != nil {
+		return s.BasicResponse(req, false, err.Error(), nil)
+	}
+	resp := model.PartTokenDenominationsResponse{
+		BasicResponse: model.BasicResponse{
+			Status:  true,
+			Message: "Got part token denominations",
+		},
+		DID:           did,
+		Denominations: make([]model.PartTokenDenomination, 0, len(dm)),
+	}
+	for v, n := range dm {
+		resp.Denominations = append(resp.Denominations, model.PartTokenDenomination{Value: v, Count: n})
+	}
+	sort.Slice(resp.Denominations, func(i, j int) bool { return resp.Denominations[i].Value < resp.Denominations[j].Value })
+	return s.RenderJSON(req, &resp, http.StatusOK)
+}
+
+// ShowAccount godoc
+// @Summary      Consolidate part tokens
+// @Description  For a mentioned DID hosted on the node, list the parent tokens whose parts are all free and held by the DID, they are not merged until the token chain has a merge block
+// @Tags         Account
+// @Produce      json
+// @Param        did query string true "DID"
+// @Success 200 {object} model.ConsolidationResult
+// @Router /api/consolidate-part-tokens [post]
+func (s *Server) APIConsolidatePartTokens(req *ensweb.Request) *ensweb.Result {
+	defer logger.RecoverAndLog(s.c.WithRequest(req))
+	did := s.GetQuerry(req, "did")
+	res, err := s.c.ConsolidatePartTokens(did)
+	if err != nil {
+		return s.BasicResponse(req, false, err.Error(), nil)
+	}
+	return s.RenderJSON(req, res, http.StatusOK)
+}
+
+// ShowAccount godoc
+// @Summary      Move part tokens
+// @Description  Move the free part tokens from one DID to another DID hosted on the same node, the transfer blocks are signed by the source DID
+// @Tags         Account
+// @Accept       json
+// @Produce      json
+// @Param        input body model.MovePartTokensRequest true "Move part tokens"
+// @Success 200 {object} model.BasicResponse
+// @Router /api/move-part-tokens [post]
+func (s *Server) APIMovePartTokens(req *ensweb.Request) *ensweb.Result {
+	defer logger.RecoverAndLog(s.c.WithRequest(req))
+	var mr model.MovePartTokensRequest
+	err := s.ParseJSON(req, &mr)
+	if err != nil {
+		return s.BasicResponse(req, false, "Invalid input", nil)
+	}
+	if !s.validateDIDAccess(req, mr.FromDID) {
+		return s.BasicResponse(req, false, "DID does not have an access", nil)
+	}
+	s.c.AddWebReq(req)
+	go s.c.MovePartTokens(req.ID, &mr)
+	return s.didResponse(req, req.ID)
+}
+
 func (s *Server) APIGenerateTestToken(req *ensweb.Request) *ensweb.Result {
 	var tr model.RBTGenerateRequest
 	err := s.ParseJSON(req, &tr)
@@ -58,8 +287,8 @@ func (s *Server) APIInitiateRBTTransfer(req *ensweb.Request) *ensweb.Result {
 	if err != nil {
 		return s.BasicResponse(req, false, "Invalid input", nil)
 	}
-	_, did, ok := util.ParseAddress(rbtReq.Sender)
-	if !ok {
+	_, did, err := util.ParseAddress(rbtReq.Sender)
+	if err != nil {
 		return s.BasicResponse(req, false, "Invalid sender address", nil)
 	}
 	if !s.validateDIDAccess(req, did) {
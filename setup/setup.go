@@ -62,6 +62,15 @@ const (
 	APIGetTxnByNode                     string = "/api/get-by-node"
 	APIRemoveTokenChainBlock            string = "/api/remove-token-chain-block"
 	APIReleaseAllLockedTokens           string = "/api/release-all-locked-tokens"
+	APIFetchPartTokens                  string = "/api/fetch-part-tokens"
+	APIMovePartTokens                   string = "/api/move-part-tokens"
+	APIStreamPartTokens                 string = "/api/stream-part-tokens"
+	APIVerifyPartTokens                 string = "/api/verify-part-tokens"
+	APIConsolidatePartTokens            string = "/api/consolidate-part-tokens"
+	APIFetchPartTokensAt                string = "/api/fetch-part-tokens-at"
+	APIFetchAllTokens                   string = "/api/fetch-all-tokens"
+	APIPartTokenDenominations           string = "/api/part-token-denominations"
+	APIFetchPartTokensMultiDID          string = "/api/fetch-part-tokens-multi-did"
 )
 
 // jwt.RegisteredClaims
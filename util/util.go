@@ -352,23 +352,24 @@ func SanitizeDirPath(path string) string {
 	}
 }
 
-// ParseAddress will parse the addrees and split inot Peer ID  & DID
-func ParseAddress(addr string) (string, string, bool) {
-	peerID := ""
-	did := ""
-	// check if addr contains the peer ID
-	if strings.Contains(addr, ".") {
-		str := strings.Split(addr, ".")
-		if len(str) != 2 {
-			return "", "", false
+// ParseAddress will split the address into the peer ID and the DID, the
+// address is either <DID> or <peerID>.<DID>
+func ParseAddress(addr string) (string, string, error) {
+	if addr == "" {
+		return "", "", fmt.Errorf("address is empty")
+	}
+	str := strings.Split(addr, ".")
+	switch len(str) {
+	case 1:
+		return "", str[0], nil
+	case 2:
+		if str[0] == "" || str[1] == "" {
+			return "", "", fmt.Errorf("invalid address %s, peer ID and DID must not be empty", addr)
 		}
-		peerID = str[0]
-		did = str[1]
-	} else {
-		did = addr
+		return str[0], str[1], nil
+	default:
+		return "", "", fmt.Errorf("invalid address %s, expected <peerID>.<DID>", addr)
 	}
-	//TODO:: Validation
-	return peerID, did, true
 }
 
 // CreateAddress will create the addrees fromt Peer ID  & DID
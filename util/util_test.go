@@ -25,3 +25,28 @@ func TestBasic(t *testing.T) {
 		t.Fatal("Failed to copy directory")
 	}
 }
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		addr   string
+		peerID string
+		did    string
+		valid  bool
+	}{
+		{"bafybmidid", "", "bafybmidid", true},
+		{"12D3KooWpeer.bafybmidid", "12D3KooWpeer", "bafybmidid", true},
+		{"", "", "", false},
+		{".bafybmidid", "", "", false},
+		{"12D3KooWpeer.", "", "", false},
+		{"12D3KooWpeer.bafybmidid.extra", "", "", false},
+	}
+	for _, tt := range tests {
+		peerID, did, err := ParseAddress(tt.addr)
+		if (err == nil) != tt.valid {
+			t.Fatalf("Unexpected result for %q, err %v", tt.addr, err)
+		}
+		if peerID != tt.peerID || did != tt.did {
+			t.Fatalf("Invalid parse for %q, got %q, %q", tt.addr, peerID, did)
+		}
+	}
+}
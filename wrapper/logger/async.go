@@ -0,0 +1,207 @@
+package logger
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultAsyncBufferSize is the number of entries queued by the AsyncWriter
+// when BufferSize is not set
+const DefaultAsyncBufferSize = 1024
+
+// OverflowPolicy defines what the AsyncWriter does with an entry when its
+// queue is full
+type OverflowPolicy uint8
+
+const (
+	// OverflowBlock waits for room in the queue, no entry is lost
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop drops the entry, the drops are counted by Dropped
+	OverflowDrop
+)
+
+// AsyncWriterOptions configures the AsyncWriter
+type AsyncWriterOptions struct {
+	// The number of entries queued, defaults to DefaultAsyncBufferSize
+	BufferSize int
+
+	// What to do with the entries written while the queue is full
+	Overflow OverflowPolicy
+
+	// Called with the errors of the wrapped writer, from the goroutine of
+	// the AsyncWriter
+	OnError func(err error)
+}
+
+type asyncEntry struct {
+	level   Level
+	leveled bool
+	p       []byte
+	flushed chan struct{}
+}
+
+// AsyncWriter queues the entries and writes them to the wrapped writer from
+// a goroutine, so that a slow output does not hold up the callers of the
+// logger. The writer must be closed on shutdown with Close, the entries
+// still queued are lost otherwise.
+type AsyncWriter struct {
+	w        io.Writer
+	overflow OverflowPolicy
+	onError  func(err error)
+	queue    chan asyncEntry
+	stop     chan struct{}
+	done     chan struct{}
+	dropped  uint64
+
+	// closed is guarded by l, the writes register in senders under the read
+	// lock and send without it, so that Close can wait for them before
+	// closing the queue
+	l       sync.RWMutex
+	closed  bool
+	senders sync.WaitGroup
+
+	errL    sync.Mutex
+	lastErr error
+}
+
+// NewAsyncWriter starts writing to w the entries written to the returned
+// AsyncWriter
+func NewAsyncWriter(w io.Writer, opts *AsyncWriterOptions) *AsyncWriter {
+	if opts == nil {
+		opts = &AsyncWriterOptions{}
+	}
+	size := opts.BufferSize
+	if size <= 0 {
+		size = DefaultAsyncBufferSize
+	}
+	aw := &AsyncWriter{
+		w:        w,
+		overflow: opts.Overflow,
+		onError:  opts.OnError,
+		queue:    make(chan asyncEntry, size),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go aw.run()
+	return aw
+}
+
+func (aw *AsyncWriter) run() {
+	defer close(aw.done)
+	for e := range aw.queue {
+		if e.flushed != nil {
+			if f, ok := aw.w.(Flushable); ok {
+				aw.report(f.Flush())
+			}
+			close(e.flushed)
+			continue
+		}
+		var err error
+		if lw, ok := aw.w.(LevelWriter); ok && e.leveled {
+			_, err = lw.LevelWrite(e.level, e.p)
+		} else {
+			err = writeFull(aw.w, e.p)
+		}
+		aw.report(err)
+	}
+}
+
+func (aw *AsyncWriter) report(err error) {
+	if err == nil {
+		return
+	}
+	aw.errL.Lock()
+	aw.lastErr = err
+	aw.errL.Unlock()
+	if aw.onError != nil {
+		aw.onError(err)
+	}
+}
+
+// Queue the entry as per the overflow policy, the flush markers are always
+// queued. A write blocked on the full queue fails with ErrWriterClosed once
+// Close is called.
+func (aw *AsyncWriter) enqueue(e asyncEntry) error {
+	aw.l.RLock()
+	if aw.closed {
+		aw.l.RUnlock()
+		return ErrWriterClosed
+	}
+	aw.senders.Add(1)
+	aw.l.RUnlock()
+	defer aw.senders.Done()
+	if aw.overflow == OverflowDrop && e.flushed == nil {
+		select {
+		case aw.queue <- e:
+		default:
+			atomic.AddUint64(&aw.dropped, 1)
+		}
+		return nil
+	}
+	select {
+	case aw.queue <- e:
+		return nil
+	case <-aw.stop:
+		return ErrWriterClosed
+	}
+}
+
+// Write implements io.Writer, the entry is copied
+func (aw *AsyncWriter) Write(p []byte) (int, error) {
+	if err := aw.enqueue(asyncEntry{p: append([]byte(nil), p...)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// LevelWrite implements LevelWriter, the level is passed on if the wrapped
+// writer is a LevelWriter
+func (aw *AsyncWriter) LevelWrite(level Level, p []byte) (int, error) {
+	if err := aw.enqueue(asyncEntry{level: level, leveled: true, p: append([]byte(nil), p...)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush implements Flushable, it waits for the entries queued so far to be
+// written and flushes the wrapped writer if it is Flushable
+func (aw *AsyncWriter) Flush() error {
+	flushed := make(chan struct{})
+	if err := aw.enqueue(asyncEntry{flushed: flushed}); err != nil {
+		return err
+	}
+	<-flushed
+	return nil
+}
+
+// Close writes the queued entries and stops the goroutine, the entries
+// written afterwards fail with ErrWriterClosed. The wrapped writer is left
+// open. It returns the last error of the wrapped writer.
+func (aw *AsyncWriter) Close() error {
+	aw.l.Lock()
+	first := !aw.closed
+	aw.closed = true
+	aw.l.Unlock()
+	if first {
+		close(aw.stop)
+		aw.senders.Wait()
+		close(aw.queue)
+	}
+	<-aw.done
+	aw.errL.Lock()
+	defer aw.errL.Unlock()
+	return aw.lastErr
+}
+
+// IsClosed reports whether Close was called
+func (aw *AsyncWriter) IsClosed() bool {
+	aw.l.RLock()
+	defer aw.l.RUnlock()
+	return aw.closed
+}
+
+// Dropped returns the number of entries dropped by OverflowDrop
+func (aw *AsyncWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&aw.dropped)
+}
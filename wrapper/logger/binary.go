@@ -0,0 +1,320 @@
+package logger
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// The binary log is a sequence of records, each a 4 byte big endian length
+// followed by the encoded entry. The entry starts with the format version,
+// followed by the time in unix nanoseconds, the level, the name, the message
+// and the fields. The strings are prefixed with their uvarint length and each
+// field value with a type tag.
+const binaryVersion byte = 1
+
+// MaxBinaryRecordLen limits the size of a record read by DecodeBinary
+const MaxBinaryRecordLen = 16 << 20
+
+const (
+	binaryTagNil byte = iota
+	binaryTagString
+	binaryTagInt
+	binaryTagUint
+	binaryTagFloat
+	binaryTagBool
+	binaryTagBytes
+	binaryTagTime
+	binaryTagDuration
+)
+
+// ErrInvalidBinaryRecord is returned by DecodeBinary for a malformed record
+var ErrInvalidBinaryRecord = errors.New("invalid binary log record")
+
+// BinaryWriter writes the entries in the compact binary format, it is safe
+// for concurrent use. The values of the fields are kept as strings, integers,
+// floats, bools, bytes, times and durations, any other value is written as
+// its string form.
+type BinaryWriter struct {
+	l      sync.Mutex
+	w      io.Writer
+	buf    []byte
+	closed bool
+}
+
+// NewBinaryWriter returns a BinaryWriter writing to w
+func NewBinaryWriter(w io.Writer) *BinaryWriter {
+	return &BinaryWriter{w: w}
+}
+
+// WriteEntry writes the entry as a single record
+func (bw *BinaryWriter) WriteEntry(e Entry) error {
+	bw.l.Lock()
+	defer bw.l.Unlock()
+	if bw.closed {
+		return ErrWriterClosed
+	}
+	bw.buf = appendBinaryEntry(bw.buf[:0], e)
+	return writeFull(bw.w, bw.buf)
+}
+
+// Close closes the underlying writer if it is an io.Closer, the entries
+// written afterwards fail with ErrWriterClosed
+func (bw *BinaryWriter) Close() error {
+	bw.l.Lock()
+	defer bw.l.Unlock()
+	if bw.closed {
+		return nil
+	}
+	bw.closed = true
+	if c, ok := bw.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// IsClosed reports whether Close was called
+func (bw *BinaryWriter) IsClosed() bool {
+	bw.l.Lock()
+	defer bw.l.Unlock()
+	return bw.closed
+}
+
+func appendBinaryEntry(b []byte, e Entry) []byte {
+	b = append(b, 0, 0, 0, 0, binaryVersion)
+	b = appendVarint(b, e.Time.UnixNano())
+	b = append(b, byte(e.Level))
+	b = appendBinaryString(b, e.Name)
+	b = appendBinaryString(b, e.Message)
+	args := e.Args
+	if len(args)%2 != 0 {
+		args = append(args[:len(args)-1:len(args)-1], MissingKey, args[len(args)-1])
+	}
+	b = appendUvarint(b, uint64(len(args)/2))
+	for i := 0; i < len(args); i = i + 2 {
+		b = appendBinaryString(b, fmt.Sprintf("%v", args[i]))
+		b = appendBinaryValue(b, args[i+1])
+	}
+	binary.BigEndian.PutUint32(b[:4], uint32(len(b)-4))
+	return b
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendFloat(b []byte, v float64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], math.Float64bits(v))
+	return append(b, buf[:]...)
+}
+
+func appendBinaryString(b []byte, s string) []byte {
+	b = appendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendBinaryValue(b []byte, val interface{}) []byte {
+	switch v := val.(type) {
+	case nil:
+		return append(b, binaryTagNil)
+	case string:
+		return appendBinaryString(append(b, binaryTagString), v)
+	case bool:
+		if v {
+			return append(b, binaryTagBool, 1)
+		}
+		return append(b, binaryTagBool, 0)
+	case int:
+		return appendVarint(append(b, binaryTagInt), int64(v))
+	case int8:
+		return appendVarint(append(b, binaryTagInt), int64(v))
+	case int16:
+		return appendVarint(append(b, binaryTagInt), int64(v))
+	case int32:
+		return appendVarint(append(b, binaryTagInt), int64(v))
+	case int64:
+		return appendVarint(append(b, binaryTagInt), v)
+	case Hex:
+		return appendVarint(append(b, binaryTagInt), int64(v))
+	case Octal:
+		return appendVarint(append(b, binaryTagInt), int64(v))
+	case Binary:
+		return appendVarint(append(b, binaryTagInt), int64(v))
+	case uint:
+		return appendUvarint(append(b, binaryTagUint), uint64(v))
+	case uint8:
+		return appendUvarint(append(b, binaryTagUint), uint64(v))
+	case uint16:
+		return appendUvarint(append(b, binaryTagUint), uint64(v))
+	case uint32:
+		return appendUvarint(append(b, binaryTagUint), uint64(v))
+	case uint64:
+		return appendUvarint(append(b, binaryTagUint), v)
+	case float32:
+		return appendFloat(append(b, binaryTagFloat), float64(v))
+	case float64:
+		return appendFloat(append(b, binaryTagFloat), v)
+	case []byte:
+		b = appendUvarint(append(b, binaryTagBytes), uint64(len(v)))
+		return append(b, v...)
+	case time.Time:
+		return appendVarint(append(b, binaryTagTime), v.UnixNano())
+	case time.Duration:
+		return appendVarint(append(b, binaryTagDuration), int64(v))
+	case TypedValue:
+		return appendBinaryValue(b, v.V)
+	case Format:
+		return appendBinaryString(append(b, binaryTagString), fmt.Sprintf(v[0].(string), v[1:]...))
+	case error:
+		return appendBinaryString(append(b, binaryTagString), v.Error())
+	case fmt.Stringer:
+		return appendBinaryString(append(b, binaryTagString), v.String())
+	default:
+		return appendBinaryString(append(b, binaryTagString), fmt.Sprintf("%+v", v))
+	}
+}
+
+// DecodeBinary reads the next record written by a BinaryWriter. It returns
+// io.EOF when there are no more records. The integers are decoded as int64
+// or uint64, the floats as float64 and the times in the local time zone.
+func DecodeBinary(r io.Reader) (Entry, error) {
+	var e Entry
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return e, ErrInvalidBinaryRecord
+		}
+		return e, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > MaxBinaryRecordLen {
+		return e, ErrInvalidBinaryRecord
+	}
+	rec := make([]byte, n)
+	if _, err := io.ReadFull(r, rec); err != nil {
+		return e, ErrInvalidBinaryRecord
+	}
+	d := &binaryDecoder{b: rec}
+	if d.byte() != binaryVersion {
+		return e, fmt.Errorf("unsupported binary log version")
+	}
+	e.Time = time.Unix(0, d.varint())
+	e.Level = Level(d.byte())
+	e.Name = d.string()
+	e.Message = d.string()
+	fields := d.uvarint()
+	if fields > uint64(len(d.b)) {
+		return e, ErrInvalidBinaryRecord
+	}
+	for i := uint64(0); i < fields && d.err == nil; i++ {
+		key := d.string()
+		e.Args = append(e.Args, key, d.value())
+	}
+	if d.err != nil || len(d.b) != 0 {
+		return Entry{}, ErrInvalidBinaryRecord
+	}
+	return e, nil
+}
+
+// binaryDecoder reads the record, the first error sticks and the reads
+// after it return zero values
+type binaryDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *binaryDecoder) fail() {
+	d.err = ErrInvalidBinaryRecord
+	d.b = nil
+}
+
+func (d *binaryDecoder) byte() byte {
+	if len(d.b) < 1 {
+		d.fail()
+		return 0
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c
+}
+
+func (d *binaryDecoder) varint() int64 {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *binaryDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *binaryDecoder) bytes() []byte {
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		d.fail()
+		return nil
+	}
+	v := d.b[:n:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *binaryDecoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *binaryDecoder) value() interface{} {
+	switch d.byte() {
+	case binaryTagNil:
+		return nil
+	case binaryTagString:
+		return d.string()
+	case binaryTagInt:
+		return d.varint()
+	case binaryTagUint:
+		return d.uvarint()
+	case binaryTagFloat:
+		if len(d.b) < 8 {
+			d.fail()
+			return nil
+		}
+		v := math.Float64frombits(binary.BigEndian.Uint64(d.b))
+		d.b = d.b[8:]
+		return v
+	case binaryTagBool:
+		return d.byte() != 0
+	case binaryTagBytes:
+		v := d.bytes()
+		out := make([]byte, len(v))
+		copy(out, v)
+		return out
+	case binaryTagTime:
+		return time.Unix(0, d.varint())
+	case binaryTagDuration:
+		return time.Duration(d.varint())
+	default:
+		d.fail()
+		return nil
+	}
+}
@@ -0,0 +1,62 @@
+package logger
+
+import "context"
+
+// ContextField maps the value stored in a context.Context under Key to the
+// LogKey field of the entries logged with the *Ctx methods
+type ContextField struct {
+	Key    interface{}
+	LogKey string
+}
+
+// RegisterContextField declares a context value extracted by the *Ctx methods
+// of the logger, e.g. the request ID set by a handler
+func (opts *LoggerOptions) RegisterContextField(key interface{}, logKey string) {
+	opts.ContextFields = append(opts.ContextFields, ContextField{Key: key, LogKey: logKey})
+}
+
+// ctxArgs prepends the registered values found in the context to the args,
+// the args are returned as is when the context carries none of them
+func (l *newLogger) ctxArgs(ctx context.Context, args []interface{}) []interface{} {
+	if ctx == nil {
+		return args
+	}
+	var found []interface{}
+	for _, f := range l.ctxFields {
+		if v := ctx.Value(f.Key); v != nil {
+			if found == nil {
+				found = make([]interface{}, 0, 2*len(l.ctxFields)+len(args))
+			}
+			found = append(found, f.LogKey, v)
+		}
+	}
+	if found == nil {
+		return args
+	}
+	return append(found, args...)
+}
+
+// Emit the message and args at TRACE level with the context fields
+func (l *newLogger) TraceCtx(ctx context.Context, msg string, args ...interface{}) {
+	l.log(l.Name(), Trace, msg, l.ctxArgs(ctx, args)...)
+}
+
+// Emit the message and args at DEBUG level with the context fields
+func (l *newLogger) DebugCtx(ctx context.Context, msg string, args ...interface{}) {
+	l.log(l.Name(), Debug, msg, l.ctxArgs(ctx, args)...)
+}
+
+// Emit the message and args at INFO level with the context fields
+func (l *newLogger) InfoCtx(ctx context.Context, msg string, args ...interface{}) {
+	l.log(l.Name(), Info, msg, l.ctxArgs(ctx, args)...)
+}
+
+// Emit the message and args at WARN level with the context fields
+func (l *newLogger) WarnCtx(ctx context.Context, msg string, args ...interface{}) {
+	l.log(l.Name(), Warn, msg, l.ctxArgs(ctx, args)...)
+}
+
+// Emit the message and args at ERROR level with the context fields
+func (l *newLogger) ErrorCtx(ctx context.Context, msg string, args ...interface{}) {
+	l.log(l.Name(), Error, msg, l.ctxArgs(ctx, args)...)
+}
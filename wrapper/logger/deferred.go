@@ -0,0 +1,169 @@
+package logger
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDeferBufferSize is the number of entries held by DeferUntilLevel
+// when DeferBufferSize is not set
+const DefaultDeferBufferSize = 1024
+
+var (
+	// ErrNotDeferred is returned by TransferBuffer when the logger was not
+	// created with DeferUntilLevel
+	ErrNotDeferred = errors.New("logger does not defer entries")
+	// ErrBufferReleased is returned by TransferBuffer when the entries were
+	// already released or transferred
+	ErrBufferReleased = errors.New("deferred entries already released")
+	// ErrNoEntryWriter is returned by TransferBuffer when the target logger
+	// is not an EntryWriter
+	ErrNoEntryWriter = errors.New("logger can not write entries")
+)
+
+type deferredEntry struct {
+	l     *newLogger
+	t     time.Time
+	name  string
+	level Level
+	msg   string
+	args  []interface{}
+}
+
+// deferredBuffer holds the entries logged before the level is set, shared
+// by the logger and its subloggers. Once released the entries go straight
+// to the outputs.
+type deferredBuffer struct {
+	l        sync.Mutex
+	released int32
+	max      int
+	entries  []deferredEntry
+	dropped  int
+}
+
+func newDeferredBuffer(max int) *deferredBuffer {
+	if max <= 0 {
+		max = DefaultDeferBufferSize
+	}
+	return &deferredBuffer{max: max}
+}
+
+// hold buffers the entry unless the buffer is released, the oldest entry is
+// dropped when the buffer is full
+func (d *deferredBuffer) hold(l *newLogger, name string, level Level, msg string, args []interface{}) bool {
+	if atomic.LoadInt32(&d.released) == 1 {
+		return false
+	}
+	d.l.Lock()
+	defer d.l.Unlock()
+	if d.released == 1 {
+		return false
+	}
+	// Capture the stack of the caller now, not the one of the release
+	if l.stackMin != NoLevel && level >= l.stackMin {
+		args = withStacktrace(args)
+	}
+	if len(d.entries) == d.max {
+		copy(d.entries, d.entries[1:])
+		d.entries = d.entries[:len(d.entries)-1]
+		d.dropped++
+	}
+	d.entries = append(d.entries, deferredEntry{
+		l:     l,
+		t:     l.clock(),
+		name:  name,
+		level: level,
+		msg:   msg,
+		args:  append([]interface{}(nil), args...),
+	})
+	return true
+}
+
+// release writes the held entries which pass the level of their logger,
+// the entries logged meanwhile wait for the replay to keep the order
+func (d *deferredBuffer) release(l *newLogger) {
+	if atomic.LoadInt32(&d.released) == 1 {
+		return
+	}
+	d.l.Lock()
+	defer d.l.Unlock()
+	if d.released == 1 {
+		return
+	}
+	for _, e := range d.entries {
+		e.l.replay(e)
+	}
+	if d.dropped > 0 && l.admit(Warn) {
+		l.write(l.clock(), l.name, Warn, false, "Dropped deferred log entries", "dropped", d.dropped)
+	}
+	d.entries = nil
+	atomic.StoreInt32(&d.released, 1)
+}
+
+// Write a deferred entry as log would have at the time it was logged
+func (l *newLogger) replay(e deferredEntry) {
+	if !l.admit(e.level) {
+		if l.writer.ring != nil {
+			l.write(e.t, e.name, e.level, true, e.msg, e.args...)
+		}
+		return
+	}
+	if l.exclude != nil && l.exclude(e.level, e.msg, e.args...) {
+		return
+	}
+	if l.sampler != nil && !l.sampler.keep(l.sampleBucket) {
+		return
+	}
+	l.write(e.t, e.name, e.level, false, e.msg, e.args...)
+}
+
+// Write the entries held by DeferUntilLevel against the current level, the
+// later entries are written directly
+func (l *newLogger) ReleaseBuffer() {
+	if l.deferred != nil {
+		l.deferred.release(l)
+	}
+}
+
+// transfer writes the held entries to the logger with WriteEntry and releases
+// the buffer, the entries keep the implied args of the logger they were
+// logged with
+func (d *deferredBuffer) transfer(l *newLogger, to EntryWriter) error {
+	d.l.Lock()
+	defer d.l.Unlock()
+	if d.released == 1 {
+		return ErrBufferReleased
+	}
+	for _, e := range d.entries {
+		args := append(e.l.implied[:len(e.l.implied):len(e.l.implied)], e.args...)
+		if e.l.txID != "" {
+			args = append([]interface{}{TxIDKey, e.l.txID}, args...)
+		}
+		if e.l.category != "" {
+			args = append([]interface{}{CategoryKey, e.l.category}, args...)
+		}
+		to.WriteEntry(Entry{Time: e.t, Level: e.level, Name: e.name, Message: e.msg, Args: args})
+	}
+	if d.dropped > 0 {
+		to.WriteEntry(Entry{Time: l.clock(), Level: Warn, Name: l.name, Message: "Dropped deferred log entries", Args: []interface{}{"dropped", d.dropped}})
+	}
+	d.entries = nil
+	atomic.StoreInt32(&d.released, 1)
+	return nil
+}
+
+// Write the entries held by DeferUntilLevel to another logger, formatted and
+// filtered by it, instead of this one. The later entries of this logger are
+// written directly.
+func (l *newLogger) TransferBuffer(to Logger) error {
+	if l.deferred == nil {
+		return ErrNotDeferred
+	}
+	ew, ok := to.(EntryWriter)
+	if !ok {
+		return ErrNoEntryWriter
+	}
+	return l.deferred.transfer(l, ew)
+}
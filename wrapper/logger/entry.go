@@ -0,0 +1,90 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Entry is a fully formed log entry, it carries its own timestamp, level and
+// name so that it can be forwarded between processes and re-logged with
+// WriteEntry on the receiving side.
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Name    string
+	Message string
+	// Args are alternating key, val pairs
+	Args []interface{}
+}
+
+// MarshalEntryJSON encodes the entry as a single JSON line. The timestamp
+// keeps nanosecond precision so that ParseLine returns the same entry.
+func MarshalEntryJSON(e Entry) ([]byte, error) {
+	vals := map[string]interface{}{
+		"@timestamp": e.Time.Format(time.RFC3339Nano),
+		"@level":     e.Level.String(),
+		"@message":   e.Message,
+	}
+	if e.Name != "" {
+		vals["@module"] = e.Name
+	}
+	args := e.Args
+	if len(args)%2 != 0 {
+		args = append(args[:len(args)-1:len(args)-1], MissingKey, args[len(args)-1])
+	}
+	for i := 0; i < len(args); i = i + 2 {
+		val := args[i+1]
+		if err, ok := val.(error); ok {
+			val = err.Error()
+		}
+		vals[fmt.Sprintf("%v", args[i])] = val
+	}
+	b, err := json.Marshal(vals)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// ParseLine decodes a JSON log line into an Entry. The fields other than the
+// reserved @ keys are returned as Args sorted by key.
+func ParseLine(line []byte) (Entry, error) {
+	var e Entry
+	var vals map[string]interface{}
+	if err := json.Unmarshal(line, &vals); err != nil {
+		return e, err
+	}
+	ts, ok := vals["@timestamp"].(string)
+	if !ok {
+		return e, errors.New("log line does not have a timestamp")
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return e, err
+	}
+	e.Time = t
+	lvl, _ := vals["@level"].(string)
+	e.Level = LevelFromString(lvl)
+	e.Name, _ = vals["@module"].(string)
+	if path, ok := vals["@module_path"].(string); ok {
+		e.Name = path
+	}
+	e.Message, _ = vals["@message"].(string)
+
+	keys := make([]string, 0, len(vals))
+	for k := range vals {
+		switch k {
+		case "@timestamp", "@level", "@level_num", "@module", "@module_path", "@message", "@caller", "@truncated", "@uptime", "@hostname":
+		default:
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		e.Args = append(e.Args, k, vals[k])
+	}
+	return e, nil
+}
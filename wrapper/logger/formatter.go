@@ -0,0 +1,61 @@
+package logger
+
+import (
+	"reflect"
+	"sync"
+)
+
+var (
+	_formattersLock sync.RWMutex
+	_formatters     = make(map[reflect.Type]func(interface{}) (string, bool))
+	_jsonFormatters = make(map[reflect.Type]func(interface{}) (interface{}, bool))
+)
+
+// RegisterFormatter sets the function rendering the values of the type in
+// the plain output, it is consulted before the reflection based rendering.
+// The value is rendered as usual when the function returns false, a nil
+// function removes the formatter of the type.
+func RegisterFormatter(t reflect.Type, fn func(interface{}) (string, bool)) {
+	_formattersLock.Lock()
+	defer _formattersLock.Unlock()
+	if fn == nil {
+		delete(_formatters, t)
+		return
+	}
+	_formatters[t] = fn
+}
+
+// RegisterJSONFormatter sets the function returning the value encoded in the
+// JSON output for the values of the type. The value is encoded as usual when
+// the function returns false, a nil function removes the formatter.
+func RegisterJSONFormatter(t reflect.Type, fn func(interface{}) (interface{}, bool)) {
+	_formattersLock.Lock()
+	defer _formattersLock.Unlock()
+	if fn == nil {
+		delete(_jsonFormatters, t)
+		return
+	}
+	_jsonFormatters[t] = fn
+}
+
+// Render the value with the formatter registered for its type
+func formatValue(v interface{}) (string, bool) {
+	_formattersLock.RLock()
+	fn, ok := _formatters[reflect.TypeOf(v)]
+	_formattersLock.RUnlock()
+	if !ok {
+		return "", false
+	}
+	return fn(v)
+}
+
+// Convert the value with the JSON formatter registered for its type
+func formatJSONValue(v interface{}) (interface{}, bool) {
+	_formattersLock.RLock()
+	fn, ok := _jsonFormatters[reflect.TypeOf(v)]
+	_formattersLock.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return fn(v)
+}
@@ -0,0 +1,90 @@
+package logger
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DefaultGoroutineInterval is the period of the GoroutineMonitor when the
+// Interval is not set
+const DefaultGoroutineInterval = time.Minute
+
+// GoroutineMonitorOptions configures the GoroutineMonitor
+type GoroutineMonitorOptions struct {
+	// The period of the goroutine count, defaults to DefaultGoroutineInterval
+	Interval time.Duration
+
+	// The count is logged at Warn above this limit, at Debug otherwise. Zero
+	// for no limit.
+	Limit int
+
+	// Returns the channel firing after the duration, defaults to time.After.
+	// Meant for the tests driving the monitor with their own clock.
+	After func(d time.Duration) <-chan time.Time
+}
+
+// GoroutineMonitor logs the number of goroutines periodically, to diagnose
+// the goroutine leaks of the long running nodes
+type GoroutineMonitor struct {
+	log      Logger
+	interval time.Duration
+	limit    int
+	after    func(d time.Duration) <-chan time.Time
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewGoroutineMonitor starts logging the number of goroutines to the logger
+// until Close is called
+func NewGoroutineMonitor(log Logger, opts GoroutineMonitorOptions) *GoroutineMonitor {
+	m := &GoroutineMonitor{
+		log:      log,
+		interval: opts.Interval,
+		limit:    opts.Limit,
+		after:    opts.After,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if m.interval <= 0 {
+		m.interval = DefaultGoroutineInterval
+	}
+	if m.after == nil {
+		m.after = time.After
+	}
+	go m.run()
+	return m
+}
+
+func (m *GoroutineMonitor) run() {
+	defer close(m.done)
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-m.after(m.interval):
+			m.check()
+		}
+	}
+}
+
+// Log the current number of goroutines
+func (m *GoroutineMonitor) check() {
+	n := runtime.NumGoroutine()
+	if m.limit > 0 && n > m.limit {
+		m.log.Warn("Goroutine count above the limit", "goroutines", n, "limit", m.limit)
+		return
+	}
+	m.log.Debug("Goroutine count", "goroutines", n)
+}
+
+// Close stops the monitor and waits for it to exit, it can be called more
+// than once
+func (m *GoroutineMonitor) Close() error {
+	m.once.Do(func() {
+		close(m.stop)
+	})
+	<-m.done
+	return nil
+}
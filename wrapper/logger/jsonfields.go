@@ -0,0 +1,84 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// jsonField is a key/value pair of a JSON entry
+type jsonField struct {
+	key string
+	val interface{}
+}
+
+// jsonFields is a JSON object keeping its keys in the order they are set
+type jsonFields []jsonField
+
+// set replaces the value of an existing key in place, a new key is appended
+func (f *jsonFields) set(key string, val interface{}) {
+	for i := range *f {
+		if (*f)[i].key == key {
+			(*f)[i].val = val
+			return
+		}
+	}
+	*f = append(*f, jsonField{key: key, val: val})
+}
+
+// add appends the key without looking for it, the fields added are to be
+// deduped once all of them are in
+func (f *jsonFields) add(key string, val interface{}) {
+	*f = append(*f, jsonField{key: key, val: val})
+}
+
+// dedupe keeps each key at its first position with the last value set for
+// it, in a single pass over the fields
+func (f *jsonFields) dedupe() {
+	if len(*f) < 2 {
+		return
+	}
+	idx := make(map[string]int, len(*f))
+	out := (*f)[:0]
+	for _, kv := range *f {
+		if i, ok := idx[kv.key]; ok {
+			out[i].val = kv.val
+			continue
+		}
+		idx[kv.key] = len(out)
+		out = append(out, kv)
+	}
+	*f = out
+}
+
+// toMap returns the fields as a map, encoded with the keys sorted
+func (f jsonFields) toMap() map[string]interface{} {
+	m := make(map[string]interface{}, len(f))
+	for _, kv := range f {
+		m[kv.key] = kv.val
+	}
+	return m
+}
+
+// MarshalJSON encodes the fields as a JSON object in their order
+func (f jsonFields) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, kv := range f {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(kv.key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(kv.val)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
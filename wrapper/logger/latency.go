@@ -0,0 +1,83 @@
+package logger
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWindow keeps the latest flush durations, shared by the logger and
+// its subloggers
+type latencyWindow struct {
+	l       sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, size)}
+}
+
+func (lw *latencyWindow) record(d time.Duration) {
+	lw.l.Lock()
+	defer lw.l.Unlock()
+	lw.samples[lw.next] = d
+	lw.next++
+	if lw.next == len(lw.samples) {
+		lw.next = 0
+		lw.full = true
+	}
+}
+
+// percentiles returns the nearest rank percentiles of the samples, zero
+// without samples
+func (lw *latencyWindow) percentiles(ps ...float64) []time.Duration {
+	lw.l.Lock()
+	n := lw.next
+	if lw.full {
+		n = len(lw.samples)
+	}
+	sorted := append([]time.Duration(nil), lw.samples[:n]...)
+	lw.l.Unlock()
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	res := make([]time.Duration, len(ps))
+	if n == 0 {
+		return res
+	}
+	for i, p := range ps {
+		rank := int(math.Ceil(p*float64(n))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		if rank >= n {
+			rank = n - 1
+		}
+		res[i] = sorted[rank]
+	}
+	return res
+}
+
+// Flush the rendered entry to the outputs, timing it if FlushLatencySamples
+// is set
+func (l *newLogger) flush(level Level) {
+	if l.latency == nil {
+		l.writer.Flush(level)
+		return
+	}
+	start := time.Now()
+	l.writer.Flush(level)
+	l.latency.record(time.Since(start))
+}
+
+// FlushLatency returns the median and the 99th percentile of the time spent
+// writing the latest entries to the outputs, zero unless FlushLatencySamples
+// is set
+func (l *newLogger) FlushLatency() (p50, p99 time.Duration) {
+	if l.latency == nil {
+		return 0, 0
+	}
+	ps := l.latency.percentiles(0.5, 0.99)
+	return ps[0], ps[1]
+}
@@ -1,9 +1,14 @@
 package logger
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"log"
 	"os"
 	"strings"
+	"time"
 )
 
 var (
@@ -38,6 +43,19 @@ type Octal int
 // text output. For example: L.Info("bits", Binary(17))
 type Binary int
 
+// TypedValue is a value logged along with its Go type name, see Typed
+type TypedValue struct {
+	V interface{}
+}
+
+// Typed marks the value to be logged with its Go type name, the JSON output
+// adds the type under the key suffixed with @type, e.g. L.Info("read",
+// "amount", Typed(v)) gives "amount" and "amount@type". The other formats
+// render the value as usual.
+func Typed(v interface{}) TypedValue {
+	return TypedValue{V: v}
+}
+
 // Level represents a log level.
 type Level int32
 
@@ -61,6 +79,12 @@ const (
 
 	// Error information about unrecoverable events.
 	Error Level = 5
+
+	// Fatal information about the events terminating the process, see Fatal
+	Fatal Level = 6
+
+	// Off is the threshold silencing the logger, no entry is written
+	Off Level = 7
 )
 
 // ColorOption defines color option
@@ -78,6 +102,88 @@ const (
 	ForceColor
 )
 
+// FieldColors defines the ANSI SGR codes used to color the fields of the
+// plain output, e.g. "2" for dim or "1;31" for bold red. The codes are only
+// written to the colored outputs.
+type FieldColors struct {
+	// Code for the field keys
+	Key string
+	// Code for the values of type error
+	ErrorValue string
+	// Codes for the values of specific keys, takes precedence over ErrorValue
+	Values map[string]string
+}
+
+// TimePrecision defines the fractional second precision of the JSON timestamp
+type TimePrecision uint8
+
+const (
+	// MicroPrecision is the default, the JSON timestamp carries microseconds.
+	MicroPrecision TimePrecision = iota
+	// SecondPrecision omits the fractional seconds from the JSON timestamp.
+	SecondPrecision
+	// MilliPrecision matches the millisecond precision of the plain format.
+	MilliPrecision
+	// NanoPrecision emits the JSON timestamp with nanoseconds.
+	NanoPrecision
+)
+
+// DuplicateKeyPolicy defines how a key repeated within the key/value pairs
+// of a single log call (including the implied args) is handled.
+type DuplicateKeyPolicy uint8
+
+const (
+	// DuplicateKeysAsIs is the default, the plain format emits every pair
+	// while the JSON format keeps the last value.
+	DuplicateKeysAsIs DuplicateKeyPolicy = iota
+	// DuplicateKeysKeepLast keeps the last value at the position of the
+	// first occurrence of the key.
+	DuplicateKeysKeepLast
+	// DuplicateKeysKeepFirst keeps the first value and drops the repeats.
+	DuplicateKeysKeepFirst
+	// DuplicateKeysRenameSuffix keeps every value, renaming the repeats
+	// with a counter suffix, e.g. k, k_2, k_3.
+	DuplicateKeysRenameSuffix
+)
+
+// PlainLevelMode defines how the level is rendered in the plain output
+type PlainLevelMode uint8
+
+const (
+	// PlainLevelBracket is the default, the level is rendered as [WARN]
+	PlainLevelBracket PlainLevelMode = iota
+	// PlainLevelBracketAndField keeps the bracket and adds the level=warn
+	// field, named by PlainLevelKey, in front of the other fields.
+	PlainLevelBracketAndField
+	// PlainLevelField replaces the bracket with the level=warn field.
+	PlainLevelField
+)
+
+// PlainLevelKey is the key of the level field of the plain output
+const PlainLevelKey = "level"
+
+// JSONProfile defines the field names of the JSON output
+type JSONProfile uint8
+
+const (
+	// JSONProfileDefault is the default, the reserved fields are the @ keys.
+	JSONProfileDefault JSONProfile = iota
+	// JSONProfileECS names the reserved fields after the Elastic Common
+	// Schema, e.g. log.level, log.logger and message, and reports a
+	// captured stacktrace as error.stack_trace with error.message.
+	JSONProfileECS
+)
+
+// ECSVersion is the version of the Elastic Common Schema set as ecs.version
+// by the ECS profile
+const ECSVersion = "1.6.0"
+
+// TxIDKey is the key of the transaction ID set by WithTxID
+const TxIDKey = "tx_id"
+
+// CategoryKey is the key of the category set by WithCategory
+const CategoryKey = "@category"
+
 // LevelFromString returns a Level type for the named log level, or "NoLevel" if
 // the level string is invalid. This facilitates setting the log level via
 // config or environment variable by name in a predictable way.
@@ -95,11 +201,26 @@ func LevelFromString(levelStr string) Level {
 		return Warn
 	case "error":
 		return Error
+	case "fatal":
+		return Fatal
+	case "off":
+		return Off
 	default:
 		return NoLevel
 	}
 }
 
+// LevelFromStringStrict returns the Level for the named log level like
+// LevelFromString, but returns an error for an invalid level string so that
+// a mistake in the config is surfaced rather than replaced by DefaultLevel.
+func LevelFromStringStrict(levelStr string) (Level, error) {
+	level := LevelFromString(levelStr)
+	if level == NoLevel {
+		return NoLevel, fmt.Errorf("invalid log level %q", levelStr)
+	}
+	return level, nil
+}
+
 func (l Level) String() string {
 	switch l {
 	case Trace:
@@ -112,6 +233,10 @@ func (l Level) String() string {
 		return "warn"
 	case Error:
 		return "error"
+	case Fatal:
+		return "fatal"
+	case Off:
+		return "off"
 	case NoLevel:
 		return "none"
 	default:
@@ -148,6 +273,10 @@ type Logger interface {
 	// If err not null Emit a message and panic
 	ErrorPanic(err error, args ...interface{})
 
+	// Emit a message and key/value pairs at the FATAL level, flush the
+	// outputs and exit with FatalExitCode. The deferred functions do not run.
+	Fatal(msg string, args ...interface{})
+
 	// Indicate if TRACE logs would be emitted. This and the other Is* guards
 	// are used to elide expensive logging code based on the current level.
 	IsTrace() bool
@@ -164,7 +293,12 @@ type Logger interface {
 	// Indicate if ERROR logs would be emitted. This and the other Is* guards
 	IsError() bool
 
-	// ImpliedArgs returns With key/value pairs
+	// Indicate if logs of the given level would be emitted, for the level
+	// computed at runtime
+	IsLevel(level Level) bool
+
+	// ImpliedArgs returns With key/value pairs, led by the transaction ID
+	// of WithTxID if any
 	ImpliedArgs() []interface{}
 
 	// Creates a sublogger that will always have the given key/value pairs
@@ -186,7 +320,11 @@ type Logger interface {
 
 	// Updates the level. This should affect all sub-loggers as well. If an
 	// implementation cannot update the level on the fly, it should no-op.
+	// The entries held by DeferUntilLevel are released at the new level.
 	SetLevel(level Level)
+
+	// Returns the current level, shared with the sub-loggers
+	GetLevel() Level
 }
 
 // LoggerOptions can be used to configure a new logger.
@@ -208,6 +346,48 @@ type LoggerOptions struct {
 	// Control if the output should be in JSON.
 	JSONFormat bool
 
+	// Add the numeric value of the level as @level_num to the JSON output,
+	// next to the @level string. The values are the Level constants,
+	// Trace=1, Debug=2, Info=3, Warn=4, Error=5 and Fatal=6.
+	JSONNumericLevel bool
+
+	// Omit the newline terminating each entry, for the outputs which frame
+	// the entries on their own. Applies to both the plain and JSON formats.
+	DisableNewline bool
+
+	// Control if the plain output should be in the compact L|15:04:05|msg|k=v
+	// format using single character level codes (T, D, I, W, E) and time only
+	// timestamps. Ignored when JSONFormat is set.
+	CompactPlain bool
+
+	// Render the name as [name] right after the level in the plain output,
+	// in place of the name: before the message
+	NameBracketPrefix bool
+
+	// Drop the padding aligning the short levels in the plain output, so a
+	// single space follows the level bracket as in [INFO] msg
+	SingleSpace bool
+
+	// Render the level of the plain output also, or only, as a level=warn
+	// field holding the JSON level name, for the consumers parsing the
+	// fields. The bracket alone is kept by default.
+	PlainLevel PlainLevelMode
+
+	// The maximum length in bytes of a log line, zero for no limit. The
+	// longer plain lines are cut on a UTF-8 boundary and end with the
+	// TruncateMarker, the JSON lines are kept whole and flagged with
+	// @truncated as cutting them would break the JSON.
+	MaxLineLen int
+
+	// Attach the stacktrace to the entry logged by Panic and ErrorPanic, so
+	// that the log carries the trace before the panic propagates
+	PanicStacktrace bool
+
+	// Prefix each line of a captured stacktrace in the plain output, e.g.
+	// "  > ", so that the frames stay visually apart from the next entries.
+	// The stacktrace is written as is if empty.
+	StacktracePrefix string
+
 	// Include file and line information in each log line
 	IncludeLocation bool
 
@@ -218,15 +398,166 @@ type LoggerOptions struct {
 	// because setting TimeFormat to empty assumes the default format.
 	DisableTime bool
 
-	// Color the output. On Windows, colored logs are only avaiable for io.Writers that
+	// The time format of the entries at the level, e.g. nanoseconds for
+	// Trace to order the tight sequences. Applies to the plain and JSON
+	// formats, the levels not listed use TimeFormat in plain and the
+	// JSONTimePrecision in JSON. Has no effect with DisableTime in plain.
+	TimeFormatByLevel map[Level]string
+
+	// The clock used for the entry timestamps, defaults to time.Now
+	Clock func() time.Time
+
+	// Add the time elapsed since the logger creation as @uptime to each
+	// entry, measured with the Clock
+	IncludeUptime bool
+
+	// Prefix the keys of the fields in the JSON output, e.g. "app_", to keep
+	// them apart from the reserved @ keys of the platform
+	JSONFieldPrefix string
+
+	// Keep the keys of the JSON output in a fixed order, the reserved @ keys
+	// first followed by the fields in the order they were given. By default
+	// the keys are sorted.
+	OrderedJSON bool
+
+	// Write the entries with all their fields to this sidecar output, the
+	// JSON output then carries the @ keys and a @detail id of the sidecar
+	// record, which holds the same @detail
+	JSONDetailOutput io.Writer
+
+	// Set only the last part of the name in @module of the JSON output, the
+	// full name is kept in @module_path
+	JSONModuleLeaf bool
+
+	// Add the @hash of the entry to the JSON output, a stable FNV hash of
+	// the level, message and fields excluding the timestamp and @uptime, so
+	// a downstream store can drop the duplicate entries
+	JSONEntryHash bool
+
+	// The nesting depth of the structs flattened by WithStruct, the deeper
+	// structs are rendered as a single field. Defaults to
+	// DefaultStructMaxDepth.
+	StructMaxDepth int
+
+	// The field names of the JSON output, JSONProfileECS emits the entries
+	// in the Elastic Common Schema for the APM correlation. JSONModuleLeaf
+	// does not apply to it.
+	JSONProfile JSONProfile
+
+	// Attach the stacktrace of the caller to the entries at or above this
+	// level, no stacktrace is captured for the lower levels. NoLevel, the
+	// default, disables it.
+	StacktraceMinLevel Level
+
+	// Write each entry to the outputs with a single Write call, a short
+	// write is reported to OnError instead of writing the rest separately.
+	// With a file opened by OpenAppendFile the entries of the processes
+	// appending to the same file are not interleaved, as POSIX makes the
+	// seek to the end and the write of O_APPEND a single step. This holds
+	// for the regular files of the local file systems, not for NFS, and a
+	// pipe only keeps the writes up to PIPE_BUF (4096 bytes on Linux) whole.
+	AtomicWrites bool
+
+	// The fractional second precision of the JSON timestamp, defaults to
+	// microseconds
+	JSONTimePrecision TimePrecision
+
+	// Count the entries logged per level, the counts are available through
+	// the LevelCounter interface
+	CountLevels bool
+
+	// Emit a line with the counts per level on Shutdown, implies CountLevels
+	SummaryOnShutdown bool
+
+	// The entries less severe than this level are counted but neither
+	// formatted nor written. This allows to keep the metrics on the verbose
+	// levels without paying for the rendering.
+	WriteMinLevel Level
+
+	// How a key repeated in a single log call is handled, applied the same
+	// way by all the formats
+	DuplicateKeys DuplicateKeyPolicy
+
+	// Frame the entries written to the output in the RFC5424 syslog format,
+	// one flag per output like Color. The header carries the priority from
+	// the level, the entry time from the Clock, the Hostname and the Name.
+	SyslogFormat []bool
+
+	// The hostname of the syslog header and of IncludeHostname, defaults to
+	// os.Hostname. Useful in containers where the OS hostname is meaningless.
+	Hostname string
+
+	// Add the hostname to each entry, as @hostname in JSON and host in plain,
+	// to tell apart the entries of the nodes in aggregated logs
+	IncludeHostname bool
+
+	// Color the field keys and values of the plain output independent of the
+	// level color
+	FieldColors *FieldColors
+
+	// Color the output, one option per output so a terminal can be colored
+	// while a file gets the plain text. The outputs without an option are
+	// not colored. On Windows, colored logs are only avaiable for io.Writers that
 	// are concretely instances of *os.File.
 	Color []ColorOption
 
+	// Keep the last entries of all the levels in the ring buffer, including
+	// the entries below the Level threshold, to dump them on a crash
+	RingBuffer *RingBufferWriter
+
+	// A function which is called when writing to an output fails, including
+	// io.ErrShortWrite for an output that stops accepting the remainder of a
+	// short write. The short writes are retried before giving up.
+	OnError func(err error)
+
+	// Write the entries also in the compact binary format, use an empty
+	// Output to write only the binary format. The entries are decoded with
+	// DecodeBinary.
+	BinaryOutput *BinaryWriter
+
+	// Hold the entries in memory until the level is set with SetLevel or
+	// the buffer is released with ReleaseBuffer, the entries are then
+	// checked against the final level. Meant for the bootstrap, when the
+	// configured level is not known yet.
+	DeferUntilLevel bool
+
+	// The number of entries held by DeferUntilLevel, the oldest entries are
+	// dropped beyond it. Defaults to DefaultDeferBufferSize.
+	DeferBufferSize int
+
+	// Limit the bytes written per second, allowing bursts up to a second
+	// worth of bytes. The entries over the limit are dropped until the budget
+	// recovers and are counted through the DropCounter interface.
+	MaxBytesPerSecond int
+
+	// Time the writes of the entries to the outputs, keeping this many of
+	// the latest durations for the FlushLatencyReporter interface. A high
+	// latency points to a slow output holding up the callers.
+	FlushLatencySamples int
+
+	// The context values written by the *Ctx methods, see
+	// RegisterContextField
+	ContextFields []ContextField
+
+	// A function which is called by With when a key replaces a value already
+	// present in the implied args, useful to catch accidental shadowing of
+	// the parent context
+	OnFieldOverride func(key string, old, new interface{})
+
 	// A function which is called with the log information and if it returns true the value
 	// should not be logged.
 	// This is useful when interacting with a system that you wish to suppress the log
 	// message for (because it's too noisy, etc)
 	Exclude func(level Level, msg string, args ...interface{}) bool
+
+	// Keep one entry in every n of the sample buckets set with
+	// WithSampleBucket, e.g. {"auth": 1, "heartbeat": 100}. A rate of 0 or
+	// 1 keeps every entry.
+	SampleRates map[string]int
+
+	// The sampling rate of the unbucketed entries and of the buckets not in
+	// SampleRates
+	DefaultSampleRate int
 }
 
 // Locker is used for locking output. If not set when creating a logger, a
@@ -249,15 +580,180 @@ type Flushable interface {
 // OutputResettable provides ways to swap the output in use at runtime
 type OutputResettable interface {
 	// ResetOutput swaps the current output writer with the one given in the
-	// opts. Color options given in opts will be used for the new output.
+	// opts. Color, syslog and AtomicWrites options given in opts will be used
+	// for the new output, the OnError function, the ring buffer and the
+	// attached taps are kept.
 	ResetOutput(opts *LoggerOptions) error
 
 	// ResetOutputWithFlush swaps the current output writer with the one given
-	// in the opts, first calling Flush on the given Flushable. Color options
-	// given in opts will be used for the new output.
+	// in the opts, first calling Flush on the given Flushable. The options are
+	// used as in ResetOutput.
 	ResetOutputWithFlush(opts *LoggerOptions, flushable Flushable) error
 }
 
+// LevelCounter provides the number of entries logged per level
+type LevelCounter interface {
+	// Count returns the number of entries logged at the level
+	Count(level Level) uint64
+
+	// Summary returns the counts of all the levels in a single line
+	Summary() string
+}
+
+// DropCounter provides the number of entries dropped by the logger
+type DropCounter interface {
+	// Dropped returns the number of entries dropped by the MaxBytesPerSecond
+	// limit
+	Dropped() uint64
+}
+
+// FlushLatencyReporter provides the time spent writing the entries to the
+// outputs
+type FlushLatencyReporter interface {
+	// FlushLatency returns the median and the 99th percentile of the latest
+	// writes, see FlushLatencySamples
+	FlushLatency() (p50, p99 time.Duration)
+}
+
+// TerminalWriter is implemented by the outputs which are not files but know
+// whether they end up on a terminal, e.g. a wrapper of os.Stdout. AutoColor
+// colors them as reported.
+type TerminalWriter interface {
+	IsTerminal() bool
+}
+
+// ErrWriterClosed is reported to OnError for the entries dropped as their
+// output is closed
+var ErrWriterClosed = errors.New("log writer is closed")
+
+// ClosedWriter is implemented by the outputs which can tell if they are
+// closed, the entries are then dropped rather than written to them
+type ClosedWriter interface {
+	IsClosed() bool
+}
+
+// Shutdowner is implemented by the loggers that have work to finish before
+// the process exits
+type Shutdowner interface {
+	// Shutdown finishes the pending work of the logger
+	Shutdown()
+}
+
+// EntryWriter is implemented by the loggers which can emit a pre-built entry
+type EntryWriter interface {
+	// Emit the entry using its own timestamp, level and name rather than the
+	// current time and the logger name
+	WriteEntry(e Entry)
+}
+
+// Merger is implemented by the loggers which can take the implied args of
+// another logger
+type Merger interface {
+	// Creates a sublogger with the implied args of both the loggers, the
+	// receiver wins on the conflicting keys and keeps its name and outputs
+	Merge(other Logger) Logger
+}
+
+// AutoFlusher is implemented by the loggers which can flush their outputs
+// once a context is done
+type AutoFlusher interface {
+	// Creates a sublogger which flushes the Flushable outputs once the
+	// context is done, for the entries buffered during a request
+	WithAutoFlush(ctx context.Context) Logger
+}
+
+// ConfigReporter is implemented by the loggers which can report the options
+// they are running with
+type ConfigReporter interface {
+	// Config returns a copy of the effective options of the logger, the
+	// outputs, callbacks and other shared state are left out
+	Config() LoggerOptions
+}
+
+// StructLogger is implemented by the loggers which can flatten a struct into
+// implied args
+type StructLogger interface {
+	// Creates a sublogger with the exported fields of the struct as implied
+	// args, keyed by their dotted path under the prefix
+	WithStruct(prefix string, v interface{}) Logger
+}
+
+// SampleBucketer is implemented by the loggers which can sample the entries
+// per bucket
+type SampleBucketer interface {
+	// Creates a sublogger whose entries are sampled at the rate of the
+	// bucket in SampleRates
+	WithSampleBucket(name string) Logger
+}
+
+// DeferredLogger is implemented by the loggers which can hold the entries
+// with DeferUntilLevel
+type DeferredLogger interface {
+	// Writes the entries held by DeferUntilLevel at the current level
+	ReleaseBuffer()
+
+	// Writes the entries held by DeferUntilLevel to another logger with
+	// WriteEntry, e.g. one built once the logging is configured
+	TransferBuffer(to Logger) error
+}
+
+// TxLogger is implemented by the loggers which can scope their entries to a
+// transaction
+type TxLogger interface {
+	// Creates a sublogger scoped to the transaction, its entries carry the
+	// TxIDKey field first
+	WithTxID(txID string) Logger
+}
+
+// WithTxID returns a sublogger scoped to the transaction, the loggers which
+// are not a TxLogger get the TxIDKey as an implied arg
+func WithTxID(l Logger, txID string) Logger {
+	if tl, ok := l.(TxLogger); ok {
+		return tl.WithTxID(txID)
+	}
+	return l.With(TxIDKey, txID)
+}
+
+// TapAttacher is implemented by the loggers which can duplicate their entries
+// to a writer at runtime
+type TapAttacher interface {
+	// Writes the entries logged from now on to w as well until detached,
+	// for live debugging without reconfiguring the outputs
+	AttachTap(w io.Writer) (detach func())
+}
+
+// CategoryLogger is implemented by the loggers which can tag their entries
+// with a category
+type CategoryLogger interface {
+	// Creates a sublogger whose entries carry the category, e.g. security,
+	// as the CategoryKey field. The CategoryWriter outputs route by it.
+	WithCategory(cat string) Logger
+}
+
+// ContextLogger is implemented by the loggers which can take the fields of
+// their entries from a context
+type ContextLogger interface {
+	// Emit a message and key/value pairs at the level with the values of
+	// the context registered with RegisterContextField, placed first
+	TraceCtx(ctx context.Context, msg string, args ...interface{})
+	DebugCtx(ctx context.Context, msg string, args ...interface{})
+	InfoCtx(ctx context.Context, msg string, args ...interface{})
+	WarnCtx(ctx context.Context, msg string, args ...interface{})
+	ErrorCtx(ctx context.Context, msg string, args ...interface{})
+}
+
+// StandardLoggerProvider is implemented by the loggers which can be used
+// through the standard library log package
+type StandardLoggerProvider interface {
+	// Returns a writer logging each write at the level of its [LEVEL]
+	// prefix, for the code which only takes an io.Writer
+	StandardWriter(opts *StandardLoggerOptions) io.Writer
+
+	// Returns a *log.Logger writing through StandardWriter, e.g. for the
+	// ErrorLog of http.Server
+	StandardLogger(opts *StandardLoggerOptions) *log.Logger
+}
+
 // NoopLocker implements locker but does nothing. This is useful if the client
 // wants tight control over locking, in order to provide grouping of log
 // entries or other functionality.
@@ -1,9 +1,22 @@
 package logger
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode/utf8"
 )
 
 func TestLogger(t *testing.T) {
@@ -20,3 +33,2609 @@ func TestLogger(t *testing.T) {
 	l.Debug("Test")
 	l.Info("Test")
 }
+
+// newTestLogger returns the logger of the options writing the entries without
+// colors to the returned buffer
+func newTestLogger(opts *LoggerOptions) (Logger, *bytes.Buffer) {
+	buf := new(bytes.Buffer)
+	opts.Output = []io.Writer{buf}
+	opts.Color = []ColorOption{ColorOff}
+	return New(opts), buf
+}
+
+func TestJSONTimePrecision(t *testing.T) {
+	digits := map[TimePrecision]int{
+		SecondPrecision: 0,
+		MilliPrecision:  3,
+		MicroPrecision:  6,
+		NanoPrecision:   9,
+	}
+	for p, n := range digits {
+		l, buf := newTestLogger(&LoggerOptions{
+			Level:             Info,
+			JSONFormat:        true,
+			JSONTimePrecision: p,
+		})
+		l.Info("Test")
+		var m map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+			t.Fatal("Failed to parse json entry", err)
+		}
+		ts, err := time.Parse(time.RFC3339Nano, m["@timestamp"].(string))
+		if err != nil {
+			t.Fatal("Failed to parse timestamp", err)
+		}
+		frac := 0
+		str := m["@timestamp"].(string)
+		if i := strings.IndexByte(str, '.'); i >= 0 {
+			frac = strings.IndexFunc(str[i+1:], func(r rune) bool { return r < '0' || r > '9' })
+		}
+		if frac != n || ts.IsZero() {
+			t.Fatalf("Invalid fractional digits for precision %d, expected %d got %d", p, n, frac)
+		}
+	}
+}
+
+func TestCompactPlain(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:        Trace,
+		CompactPlain: true,
+	})
+	codes := map[Level]string{Trace: "T", Debug: "D", Info: "I", Warn: "W", Error: "E"}
+	for level, code := range codes {
+		buf.Reset()
+		l.Log(level, "msg", "k", "v")
+		line := buf.String()
+		parts := strings.Split(strings.TrimSuffix(line, "\n"), "|")
+		if len(parts) != 4 || parts[0] != code || parts[2] != "msg" || parts[3] != "k=v" {
+			t.Fatalf("Invalid compact line for %s: %q", level, line)
+		}
+		if _, err := time.Parse(CompactTimeFormat, parts[1]); err != nil {
+			t.Fatalf("Invalid compact time %q", parts[1])
+		}
+	}
+}
+
+func TestWriteEntry(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Name:       "receiver",
+		Level:      Info,
+		JSONFormat: true,
+	})
+	e := Entry{
+		Time:    time.Date(2023, 5, 1, 10, 20, 30, 123456789, time.UTC),
+		Level:   Warn,
+		Name:    "sender.core",
+		Message: "forwarded",
+		Args:    []interface{}{"a", "x", "b", float64(2)},
+	}
+	l.(EntryWriter).WriteEntry(e)
+	expected := buf.String()
+	if !strings.Contains(expected, `"@module":"sender.core"`) || !strings.Contains(expected, `"@timestamp":"2023-05-01T10:20:30.123456Z"`) {
+		t.Fatalf("Entry not rendered with its own name and time: %s", expected)
+	}
+
+	b, err := MarshalEntryJSON(e)
+	if err != nil {
+		t.Fatal("Failed to marshal entry", err)
+	}
+	pe, err := ParseLine(b)
+	if err != nil {
+		t.Fatal("Failed to parse entry", err)
+	}
+	buf.Reset()
+	l.(EntryWriter).WriteEntry(pe)
+	if buf.String() != expected {
+		t.Fatalf("Round trip output mismatch, expected %s got %s", expected, buf.String())
+	}
+}
+
+func TestIncludeLocation(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:           Info,
+		IncludeLocation: true,
+	})
+	l.Info("Test")
+	if !strings.Contains(buf.String(), "logger/logger_test.go:") {
+		t.Fatalf("Invalid caller location: %s", buf.String())
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	tests := []struct {
+		policy DuplicateKeyPolicy
+		plain  string
+		json   map[string]interface{}
+	}{
+		{DuplicateKeysKeepLast, "k=3 a=2", map[string]interface{}{"k": "3", "a": "2"}},
+		{DuplicateKeysKeepFirst, "k=1 a=2", map[string]interface{}{"k": "1", "a": "2"}},
+		{DuplicateKeysRenameSuffix, "k=1 a=2 k_2=3", map[string]interface{}{"k": "1", "a": "2", "k_2": "3"}},
+	}
+	for _, tt := range tests {
+		l, buf := newTestLogger(&LoggerOptions{
+			Level:         Info,
+			DisableTime:   true,
+			DuplicateKeys: tt.policy,
+		})
+		l.With("k", "1").Info("msg", "a", "2", "k", "3")
+		if !strings.HasSuffix(buf.String(), "msg: "+tt.plain+"\n") {
+			t.Fatalf("Invalid plain line for policy %d: %q", tt.policy, buf.String())
+		}
+
+		l, buf = newTestLogger(&LoggerOptions{
+			Level:         Info,
+			JSONFormat:    true,
+			DuplicateKeys: tt.policy,
+		})
+		l.With("k", "1").Info("msg", "a", "2", "k", "3")
+		var vals map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+			t.Fatal("Failed to parse JSON line", err)
+		}
+		for k, v := range tt.json {
+			if vals[k] != v {
+				t.Fatalf("Invalid JSON value for %s with policy %d: %v", k, tt.policy, vals[k])
+			}
+		}
+		if _, ok := vals["k_3"]; ok {
+			t.Fatalf("Unexpected key for policy %d", tt.policy)
+		}
+	}
+}
+
+func TestWriteMinLevel(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:         Debug,
+		CountLevels:   true,
+		WriteMinLevel: Info,
+	})
+	sl := l.With("k", "v")
+	for i := 0; i < 3; i++ {
+		sl.Debug("debug msg")
+	}
+	l.Trace("trace msg")
+	l.Info("info msg")
+	if strings.Contains(buf.String(), "debug msg") || !strings.Contains(buf.String(), "info msg") {
+		t.Fatalf("Invalid output %q", buf.String())
+	}
+	lc := l.(LevelCounter)
+	if lc.Count(Debug) != 3 || lc.Count(Info) != 1 || lc.Count(Trace) != 0 {
+		t.Fatalf("Invalid counts, debug %d, info %d, trace %d", lc.Count(Debug), lc.Count(Info), lc.Count(Trace))
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		l.Debug("debug msg")
+	})
+	if allocs != 0 {
+		t.Fatalf("Expected no allocations on the counted path, got %v", allocs)
+	}
+}
+
+func BenchmarkWriteMinLevel(b *testing.B) {
+	l, _ := newTestLogger(&LoggerOptions{
+		Level:         Debug,
+		CountLevels:   true,
+		WriteMinLevel: Info,
+	})
+	b.ReportAllocs()
+	// Args passed through the Logger interface are boxed into a slice by
+	// the caller, so only the logger's own cost is measured here
+	for i := 0; i < b.N; i++ {
+		l.Debug("debug msg")
+	}
+}
+
+func TestStacktracePrefix(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:            Info,
+		StacktracePrefix: "  > ",
+	})
+	st := CapturedStacktrace("main.run\n\t/src/main.go:10\nmain.main\n\t/src/main.go:5\n")
+	l.Error("failed", "err", "boom", st)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Invalid output %q", buf.String())
+	}
+	for _, line := range lines[1:] {
+		if !strings.HasPrefix(line, "  > ") {
+			t.Fatalf("Stacktrace line not prefixed: %q", line)
+		}
+	}
+}
+
+func TestJSONNumericLevel(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:            Trace,
+		JSONFormat:       true,
+		JSONNumericLevel: true,
+	})
+	for _, level := range []Level{Trace, Debug, Info, Warn, Error} {
+		buf.Reset()
+		l.Log(level, "msg")
+		var vals map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+			t.Fatal("Failed to parse JSON line", err)
+		}
+		if vals["@level_num"] != float64(level) || vals["@level"] != level.String() {
+			t.Fatalf("Invalid level fields for %s: %v, %v", level, vals["@level"], vals["@level_num"])
+		}
+	}
+}
+
+func TestWithNoArgs(t *testing.T) {
+	l := New(&LoggerOptions{
+		Level:  Info,
+		Color:  []ColorOption{ColorOff},
+		Output: []io.Writer{io.Discard},
+	}).With("k", "v")
+	if l.With() != l {
+		t.Fatal("Expected the same logger for With without args")
+	}
+	sl := l.With("extra")
+	args := sl.ImpliedArgs()
+	if len(args) != 4 || args[2] != MissingKey || args[3] != "extra" {
+		t.Fatalf("Invalid implied args %v", args)
+	}
+}
+
+func TestWithConcurrent(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+	})
+	// The repeated key leaves the implied args shorter than the pairs given
+	parent := l.With("a", 1, "a", 2)
+	const n = 50
+	children := make([]Logger, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			children[i] = parent.With("id", i)
+			children[i].Info("child", "n", i)
+			parent.Info("parent", "n", i)
+		}(i)
+	}
+	wg.Wait()
+	for i, c := range children {
+		args := c.ImpliedArgs()
+		if !reflect.DeepEqual(args, []interface{}{"a", 2, "id", i}) {
+			t.Fatalf("Invalid implied args of child %d, %v", i, args)
+		}
+	}
+	if args := parent.ImpliedArgs(); !reflect.DeepEqual(args, []interface{}{"a", 2}) {
+		t.Fatalf("Invalid implied args of parent, %v", args)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var id, num int
+		if _, err := fmt.Sscanf(line, "[INFO]  child: a=2 id=%d n=%d", &id, &num); err == nil {
+			if id != num {
+				t.Fatalf("Child logged fields of another child, %q", line)
+			}
+		} else if _, err := fmt.Sscanf(line, "[INFO]  parent: a=2 n=%d", &num); err != nil {
+			t.Fatalf("Unexpected line %q", line)
+		}
+	}
+}
+
+func BenchmarkWithConcurrent(b *testing.B) {
+	base, _ := newTestLogger(&LoggerOptions{
+		Level: Info,
+	})
+	l := base.With("a", 1)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			l.With("id", 1).Info("child")
+		}
+	})
+}
+
+func BenchmarkWithNoArgs(b *testing.B) {
+	l, _ := newTestLogger(&LoggerOptions{
+		Level: Info,
+	})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.With()
+	}
+}
+
+func TestMaxLineLen(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+		MaxLineLen:  20,
+	})
+	l.Info("héllo wörld, this line is too long")
+	line := strings.TrimSuffix(buf.String(), "\n")
+	if len(line) > 20 || !strings.HasSuffix(line, TruncateMarker) || !utf8.ValidString(line) {
+		t.Fatalf("Invalid truncated line %q", line)
+	}
+
+	buf.Reset()
+	l.Info("short")
+	if strings.Contains(buf.String(), TruncateMarker) {
+		t.Fatalf("Unexpected truncation %q", buf.String())
+	}
+
+	buf.Reset()
+	l = New(&LoggerOptions{
+		Level:      Info,
+		JSONFormat: true,
+		MaxLineLen: 20,
+		Color:      []ColorOption{ColorOff},
+		Output:     []io.Writer{buf},
+	})
+	msg := "this message does not fit in the limit"
+	l.Info(msg)
+	var vals map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+		t.Fatal("Failed to parse JSON line", err)
+	}
+	if vals["@truncated"] != true || vals["@message"] != msg {
+		t.Fatalf("Invalid JSON entry %v", vals)
+	}
+}
+
+func TestIncludeUptime(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:         Info,
+		JSONFormat:    true,
+		IncludeUptime: true,
+		Clock:         func() time.Time { return now },
+	})
+	uptime := func() time.Duration {
+		var vals map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+			t.Fatal("Failed to parse JSON line", err)
+		}
+		buf.Reset()
+		d, err := time.ParseDuration(vals["@uptime"].(string))
+		if err != nil {
+			t.Fatal("Invalid uptime", err)
+		}
+		return d
+	}
+	now = now.Add(time.Second)
+	l.Info("first")
+	first := uptime()
+	now = now.Add(time.Second)
+	l.Info("second")
+	second := uptime()
+	if first != time.Second || second != 2*time.Second {
+		t.Fatalf("Invalid uptime, first %s, second %s", first, second)
+	}
+}
+
+func TestOnFieldOverride(t *testing.T) {
+	var overrides []string
+	l, _ := newTestLogger(&LoggerOptions{
+		Level: Info,
+		OnFieldOverride: func(key string, old, new interface{}) {
+			overrides = append(overrides, fmt.Sprintf("%s:%v:%v", key, old, new))
+		},
+	})
+	l = l.With("did", "did1", "peer", "p1")
+	l = l.With("did", "did2", "reqID", "r1")
+	if len(overrides) != 1 || overrides[0] != "did:did1:did2" {
+		t.Fatalf("Invalid overrides %v", overrides)
+	}
+}
+
+func TestRenderSliceConcurrent(t *testing.T) {
+	l := New(&LoggerOptions{
+		Color:  []ColorOption{ColorOff},
+		Output: []io.Writer{io.Discard},
+	}).(*newLogger)
+	var wg sync.WaitGroup
+	errs := make(chan string, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			s := []int{g, g, g}
+			exp := fmt.Sprintf("[%d, %d, %d]", g, g, g)
+			for i := 0; i < 1000; i++ {
+				if r := l.renderSlice(reflect.ValueOf(s)); r != exp {
+					errs <- r
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for r := range errs {
+		t.Fatalf("Invalid slice rendering %q", r)
+	}
+}
+
+func BenchmarkRenderSlice(b *testing.B) {
+	l, _ := newTestLogger(&LoggerOptions{})
+	tokens := []string{"QmToken1", "QmToken2", "QmToken3", "QmToken4"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.Info("tokens", "tokens", tokens)
+	}
+}
+
+func TestSummaryOnShutdown(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:             Warn,
+		SummaryOnShutdown: true,
+	})
+	l.Info("info msg")
+	for i := 0; i < 2; i++ {
+		l.Warn("warn msg")
+	}
+	for i := 0; i < 3; i++ {
+		l.Named("sub").Error("error msg")
+	}
+	exp := "trace=0 debug=0 info=0 warn=2 error=3 fatal=0"
+	if s := l.(LevelCounter).Summary(); s != exp {
+		t.Fatalf("Invalid summary %q", s)
+	}
+	buf.Reset()
+	l.(Shutdowner).Shutdown()
+	if !strings.Contains(buf.String(), "log summary: "+exp) {
+		t.Fatalf("Summary missing on shutdown, %q", buf.String())
+	}
+}
+
+func TestFirstNThenSample(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:   Info,
+		Exclude: NewFirstNThenSample(3, 10),
+	})
+	count := func() int {
+		n := strings.Count(buf.String(), "noisy event")
+		buf.Reset()
+		return n
+	}
+	for i := 0; i < 3; i++ {
+		l.Info("noisy event", "i", i)
+	}
+	if n := count(); n != 3 {
+		t.Fatalf("Expected the first 3 entries, got %d", n)
+	}
+	for i := 0; i < 100; i++ {
+		l.Info("noisy event", "i", i)
+	}
+	if n := count(); n != 10 {
+		t.Fatalf("Expected 10 sampled entries, got %d", n)
+	}
+	l.Info("other event")
+	if !strings.Contains(buf.String(), "other event") {
+		t.Fatal("Other message must not be sampled")
+	}
+}
+
+func TestCombineExcludes(t *testing.T) {
+	debug := func(level Level, msg string, args ...interface{}) bool {
+		return level == Debug
+	}
+	heartbeat := func(level Level, msg string, args ...interface{}) bool {
+		return msg == "heartbeat"
+	}
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:       Debug,
+		DisableTime: true,
+		Exclude:     CombineExcludes(debug, nil, heartbeat),
+	})
+	l.Debug("verbose")
+	l.Info("heartbeat")
+	l.Debug("heartbeat")
+	l.Info("kept")
+	if buf.String() != "[INFO]  kept\n" {
+		t.Fatalf("Expected the entries matching either rule excluded, got %q", buf.String())
+	}
+
+	buf.Reset()
+	l = New(&LoggerOptions{
+		Level:       Debug,
+		DisableTime: true,
+		Exclude:     NegateExclude(CombineExcludes(debug, heartbeat)),
+		Color:       []ColorOption{ColorOff},
+		Output:      []io.Writer{buf},
+	})
+	l.Debug("verbose")
+	l.Info("heartbeat")
+	l.Info("dropped")
+	if buf.String() != "[DEBUG] verbose\n[INFO]  heartbeat\n" {
+		t.Fatalf("Expected only the entries matching a rule, got %q", buf.String())
+	}
+	if CombineExcludes()(Error, "any") {
+		t.Fatal("Expected no function to exclude nothing")
+	}
+}
+
+type shortWriter struct {
+	buf   bytes.Buffer
+	max   int
+	stall bool
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if w.stall && w.buf.Len() > 0 {
+		return 0, nil
+	}
+	if len(p) > w.max {
+		p = p[:w.max]
+	}
+	return w.buf.Write(p)
+}
+
+func TestShortWrite(t *testing.T) {
+	var errs []error
+	sw := &shortWriter{max: 4}
+	l := New(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+		OnError:     func(err error) { errs = append(errs, err) },
+		Color:       []ColorOption{ColorOff},
+		Output:      []io.Writer{sw},
+	})
+	l.Info("short write", "k", "v")
+	if sw.buf.String() != "[INFO]  short write: k=v\n" || len(errs) != 0 {
+		t.Fatalf("Invalid output %q, errors %v", sw.buf.String(), errs)
+	}
+
+	sw = &shortWriter{max: 4, stall: true}
+	l.(OutputResettable).ResetOutput(&LoggerOptions{
+		Color:  []ColorOption{ColorOff},
+		Output: []io.Writer{sw},
+	})
+	l.Info("short write")
+	if len(errs) != 1 || errs[0] != io.ErrShortWrite {
+		t.Fatalf("Expected short write error, got %v", errs)
+	}
+}
+
+func TestRingBuffer(t *testing.T) {
+	ring := NewRingBufferWriter(3)
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+		RingBuffer:  ring,
+	})
+	for i := 0; i < 5; i++ {
+		l.Info("entry", "i", i)
+	}
+	l.Debug("entry", "i", 5)
+	if strings.Contains(buf.String(), "i=5") {
+		t.Fatal("Debug entry written to the output")
+	}
+	var dump bytes.Buffer
+	func() {
+		defer func() { recover() }()
+		defer ring.DumpOnPanic(&dump)
+		panic("crash")
+	}()
+	exp := "[INFO]  entry: i=3\n[INFO]  entry: i=4\n[DEBUG] entry: i=5\n"
+	if dump.String() != exp {
+		t.Fatalf("Invalid dump %q", dump.String())
+	}
+}
+
+func TestDisableNewline(t *testing.T) {
+	for _, jsonFormat := range []bool{false, true} {
+		l, buf := newTestLogger(&LoggerOptions{
+			Level:          Info,
+			JSONFormat:     jsonFormat,
+			DisableNewline: true,
+		})
+		l.Info("framed", "k", "v")
+		if buf.Len() == 0 || strings.HasSuffix(buf.String(), "\n") {
+			t.Fatalf("Unexpected trailing newline %q", buf.String())
+		}
+	}
+}
+
+func TestFieldColors(t *testing.T) {
+	var colored, plain bytes.Buffer
+	l := New(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+		FieldColors: &FieldColors{Key: "2", ErrorValue: "31", Values: map[string]string{"did": "36"}},
+		Color:       []ColorOption{ForceColor, ColorOff},
+		Output:      []io.Writer{&colored, &plain},
+	})
+	l.Info("failed", "did", "did1", "err", errors.New("boom"), "k", "v")
+	// The level color is restored after each field color
+	for _, exp := range []string{"\x1b[94m[INFO]", "\x1b[2mdid\x1b[0m\x1b[94m=\x1b[36mdid1\x1b[0m", "\x1b[2merr\x1b[0m\x1b[94m=\x1b[31mboom\x1b[0m", "\x1b[2mk\x1b[0m\x1b[94m=v"} {
+		if !strings.Contains(colored.String(), exp) {
+			t.Fatalf("Missing %q in colored output %q", exp, colored.String())
+		}
+	}
+	if plain.String() != "[INFO]  failed: did=did1 err=boom k=v\n" {
+		t.Fatalf("Invalid plain output %q", plain.String())
+	}
+}
+
+func TestSyslogFormat(t *testing.T) {
+	var syslog, plain bytes.Buffer
+	now := time.Date(2023, 5, 1, 10, 20, 30, 123456789, time.UTC)
+	l := New(&LoggerOptions{
+		Name:         "core",
+		Level:        Info,
+		DisableTime:  true,
+		Clock:        func() time.Time { return now },
+		Hostname:     "node1",
+		SyslogFormat: []bool{true, false},
+		Color:        []ColorOption{ColorOff, ColorOff},
+		Output:       []io.Writer{&syslog, &plain},
+	})
+	l.Info("started", "k", "v")
+	exp := fmt.Sprintf("<14>1 2023-05-01T10:20:30.123456Z node1 core %d - - [INFO]  core: started: k=v\n", os.Getpid())
+	if syslog.String() != exp {
+		t.Fatalf("Invalid syslog output %q", syslog.String())
+	}
+	if plain.String() != "[INFO]  core: started: k=v\n" {
+		t.Fatalf("Invalid plain output %q", plain.String())
+	}
+}
+
+func TestMaxBytesPerSecond(t *testing.T) {
+	now := time.Date(2023, 5, 1, 10, 20, 30, 0, time.UTC)
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:             Info,
+		DisableTime:       true,
+		Clock:             func() time.Time { return now },
+		MaxBytesPerSecond: 1000,
+	})
+	msg := strings.Repeat("x", 191)
+	// each entry is 200 bytes with the level and the newline
+	for i := 0; i < 20; i++ {
+		l.Info(msg)
+	}
+	if buf.Len() != 1000 {
+		t.Fatalf("Expected 1000 bytes in the first second, got %d", buf.Len())
+	}
+	dc := l.(DropCounter)
+	if dc.Dropped() != 15 {
+		t.Fatalf("Expected 15 dropped entries, got %d", dc.Dropped())
+	}
+
+	now = now.Add(700 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		l.Info(msg)
+	}
+	if buf.Len() != 1600 || dc.Dropped() != 32 {
+		t.Fatalf("Invalid throughput after recovery, %d bytes, %d dropped", buf.Len(), dc.Dropped())
+	}
+}
+
+func TestIncludeHostname(t *testing.T) {
+	var js, plain bytes.Buffer
+	l := New(&LoggerOptions{
+		Level:           Info,
+		JSONFormat:      true,
+		IncludeHostname: true,
+		Color:           []ColorOption{ColorOff},
+		Output:          []io.Writer{&js},
+	})
+	l.Info("started")
+	var vals map[string]interface{}
+	if err := json.Unmarshal(js.Bytes(), &vals); err != nil {
+		t.Fatal("Failed to parse JSON line", err)
+	}
+	host, _ := os.Hostname()
+	if vals["@hostname"] != host {
+		t.Fatalf("Invalid hostname %v, expected %s", vals["@hostname"], host)
+	}
+
+	l = New(&LoggerOptions{
+		Level:           Info,
+		DisableTime:     true,
+		IncludeHostname: true,
+		Hostname:        "node1",
+		Color:           []ColorOption{ColorOff},
+		Output:          []io.Writer{&plain},
+	})
+	l.Info("started", "k", "v")
+	if plain.String() != "[INFO]  started: host=node1 k=v\n" {
+		t.Fatalf("Invalid plain output %q", plain.String())
+	}
+}
+
+func TestLevelFromStringStrict(t *testing.T) {
+	valid := map[string]Level{
+		"trace":  Trace,
+		"DEBUG":  Debug,
+		" info ": Info,
+		"Warn":   Warn,
+		"error":  Error,
+		"OFF":    Off,
+	}
+	for s, exp := range valid {
+		level, err := LevelFromStringStrict(s)
+		if err != nil || level != exp {
+			t.Fatalf("Invalid level for %q, %s, %v", s, level, err)
+		}
+	}
+	for _, s := range []string{"inof", "", "none", "warning"} {
+		level, err := LevelFromStringStrict(s)
+		if err == nil || level != NoLevel {
+			t.Fatalf("Expected error for %q, got %s", s, level)
+		}
+	}
+}
+
+func TestIsLevel(t *testing.T) {
+	for threshold := Trace; threshold <= Error; threshold++ {
+		l, _ := newTestLogger(&LoggerOptions{
+			Level: threshold,
+		})
+		exp := map[Level]bool{
+			Trace: l.IsTrace(),
+			Debug: l.IsDebug(),
+			Info:  l.IsInfo(),
+			Warn:  l.IsWarn(),
+			Error: l.IsError(),
+		}
+		for level, is := range exp {
+			if l.IsLevel(level) != is {
+				t.Fatalf("IsLevel(%s) is %v at threshold %s", level, l.IsLevel(level), threshold)
+			}
+		}
+	}
+}
+
+func TestNameBracketPrefix(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Name:              "core",
+		Level:             Info,
+		DisableTime:       true,
+		NameBracketPrefix: true,
+	})
+	l.Named("storage").Info("opened", "k", "v")
+	if buf.String() != "[INFO]  [core.storage] opened: k=v\n" {
+		t.Fatalf("Invalid bracketed name %q", buf.String())
+	}
+	buf.Reset()
+	l.Error("failed")
+	if buf.String() != "[ERROR] [core] failed\n" {
+		t.Fatalf("Invalid bracketed name %q", buf.String())
+	}
+}
+
+func TestJSONFieldPrefix(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Name:            "core",
+		Level:           Info,
+		JSONFormat:      true,
+		JSONFieldPrefix: "app_",
+		IncludeUptime:   true,
+	})
+	l.With("did", "did1").Info("started", "count", 2)
+	var vals map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+		t.Fatal("Failed to parse JSON line", err)
+	}
+	for _, k := range []string{"app_did", "app_count", "@message", "@level", "@module", "@timestamp", "@uptime"} {
+		if _, ok := vals[k]; !ok {
+			t.Fatalf("Key %s missing in %v", k, vals)
+		}
+	}
+	if len(vals) != 7 {
+		t.Fatalf("Unexpected keys in %v", vals)
+	}
+}
+
+func TestPanicStacktrace(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:           Info,
+		DisableTime:     true,
+		PanicStacktrace: true,
+	})
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("Expected panic with the message, got %v", r)
+			}
+		}()
+		l.Panic("boom", "k", "v")
+	}()
+	out := buf.String()
+	if !strings.HasPrefix(out, "[ERROR] boom: k=v\n") || !strings.Contains(out, "TestPanicStacktrace") {
+		t.Fatalf("Stacktrace missing in the panic entry %q", out)
+	}
+
+	buf.Reset()
+	func() {
+		defer func() {
+			recover()
+		}()
+		l.ErrorPanic(errors.New("failed"), "extra")
+	}()
+	out = buf.String()
+	if !strings.HasPrefix(out, "[ERROR] failed: EXTRA_VALUE_AT_END=extra\n") || !strings.Contains(out, "TestPanicStacktrace") {
+		t.Fatalf("Stacktrace missing in the error panic entry %q", out)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base, buf := newTestLogger(&LoggerOptions{
+		Name:        "core",
+		Level:       Info,
+		DisableTime: true,
+	})
+	req := base.With("request", "r1", "did", "did1")
+	op := New(&LoggerOptions{
+		Name:   "op",
+		Level:  Error,
+		Color:  []ColorOption{ColorOff},
+		Output: []io.Writer{io.Discard},
+	}).With("op", "transfer", "did", "did2")
+
+	m := req.(Merger).Merge(op)
+	exp := []interface{}{"did", "did1", "op", "transfer", "request", "r1"}
+	if !reflect.DeepEqual(m.ImpliedArgs(), exp) {
+		t.Fatalf("Invalid merged args %v", m.ImpliedArgs())
+	}
+	m.Info("merged")
+	if buf.String() != "[INFO]  core: merged: did=did1 op=transfer request=r1\n" {
+		t.Fatalf("Invalid merged logger output %q", buf.String())
+	}
+	if !reflect.DeepEqual(req.ImpliedArgs(), []interface{}{"did", "did1", "request", "r1"}) {
+		t.Fatalf("Receiver modified by merge, %v", req.ImpliedArgs())
+	}
+	if req.(Merger).Merge(base) != req {
+		t.Fatal("Expected the receiver when there is nothing to merge")
+	}
+
+	// The tx_id of overlapping loggers is written once, the receiver's wins
+	buf.Reset()
+	tx1 := WithTxID(req, "tx1")
+	tx2 := WithTxID(op, "tx2").With("request", "r2")
+	tx1.(Merger).Merge(tx2).Info("merged")
+	if buf.String() != "[INFO]  core: merged: tx_id=tx1 did=did1 op=transfer request=r1\n" {
+		t.Fatalf("Invalid merged tx_id output %q", buf.String())
+	}
+	buf.Reset()
+	m = req.(Merger).Merge(tx2)
+	if !reflect.DeepEqual(m.ImpliedArgs(), []interface{}{"tx_id", "tx2", "did", "did1", "op", "transfer", "request", "r1"}) {
+		t.Fatalf("Invalid merged args %v", m.ImpliedArgs())
+	}
+	m.Info("merged")
+	if buf.String() != "[INFO]  core: merged: tx_id=tx2 did=did1 op=transfer request=r1\n" {
+		t.Fatalf("Invalid merged tx_id output %q", buf.String())
+	}
+	if tx1.(Merger).Merge(WithTxID(req, "tx3")) != tx1 {
+		t.Fatal("Expected the receiver when there is nothing to merge")
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	bw := NewBinaryWriter(&buf)
+	now := time.Date(2023, 5, 1, 10, 20, 30, 123456789, time.UTC)
+	entries := []Entry{
+		{Time: now, Level: Info, Name: "core", Message: "started"},
+		{
+			Time:    now.Add(time.Second),
+			Level:   Error,
+			Name:    "core.wallet",
+			Message: "failed",
+			Args: []interface{}{
+				"str", "value", "int", -42, "uint", uint32(7), "float", 0.25,
+				"bool", true, "bytes", []byte{1, 2, 3}, "time", now,
+				"duration", 3 * time.Second, "err", errors.New("boom"),
+				"nil", nil, "fmt", Fmt("%d tokens", 3), "extra",
+			},
+		},
+	}
+	for _, e := range entries {
+		if err := bw.WriteEntry(e); err != nil {
+			t.Fatal("Failed to write entry", err)
+		}
+	}
+	exp := []Entry{
+		entries[0],
+		{
+			Time:    entries[1].Time,
+			Level:   Error,
+			Name:    "core.wallet",
+			Message: "failed",
+			Args: []interface{}{
+				"str", "value", "int", int64(-42), "uint", uint64(7), "float", 0.25,
+				"bool", true, "bytes", []byte{1, 2, 3}, "time", now,
+				"duration", 3 * time.Second, "err", "boom",
+				"nil", nil, "fmt", "3 tokens", MissingKey, "extra",
+			},
+		},
+	}
+	for i := range exp {
+		e, err := DecodeBinary(&buf)
+		if err != nil {
+			t.Fatal("Failed to decode entry", err)
+		}
+		if !e.Time.Equal(exp[i].Time) {
+			t.Fatalf("Invalid time %s, expected %s", e.Time, exp[i].Time)
+		}
+		// the times are decoded in the local time zone
+		if len(e.Args) > 13 {
+			if tv, ok := e.Args[13].(time.Time); !ok || !tv.Equal(now) {
+				t.Fatalf("Invalid time field %v", e.Args[13])
+			}
+			e.Args[13] = now
+		}
+		e.Time = exp[i].Time
+		if !reflect.DeepEqual(e, exp[i]) {
+			t.Fatalf("Invalid decoded entry\n%#v\nexpected\n%#v", e, exp[i])
+		}
+	}
+	if _, err := DecodeBinary(&buf); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if _, err := DecodeBinary(bytes.NewReader([]byte{0, 0, 0, 3, 1, 2})); err != ErrInvalidBinaryRecord {
+		t.Fatalf("Expected invalid record, got %v", err)
+	}
+}
+
+func TestBinaryOutput(t *testing.T) {
+	var bin, plain bytes.Buffer
+	now := time.Date(2023, 5, 1, 10, 20, 30, 0, time.UTC)
+	opts := &LoggerOptions{
+		Name:         "core",
+		Level:        Info,
+		Clock:        func() time.Time { return now },
+		BinaryOutput: NewBinaryWriter(&bin),
+		Color:        []ColorOption{},
+		Output:       []io.Writer{},
+	}
+	l := New(opts).With("did", "did1")
+	l.Info("started", "count", 2)
+	l.Debug("hidden")
+	e, err := DecodeBinary(&bin)
+	if err != nil {
+		t.Fatal("Failed to decode entry", err)
+	}
+	if !e.Time.Equal(now) || e.Level != Info || e.Name != "core" || e.Message != "started" ||
+		!reflect.DeepEqual(e.Args, []interface{}{"did", "did1", "count", int64(2)}) {
+		t.Fatalf("Invalid binary entry %+v", e)
+	}
+	if _, err := DecodeBinary(&bin); err != io.EOF {
+		t.Fatalf("Expected only one entry, got %v", err)
+	}
+
+	// the decoded entry is rendered as text by WriteEntry
+	tl := New(&LoggerOptions{
+		Level:  Trace,
+		Color:  []ColorOption{ColorOff},
+		Output: []io.Writer{&plain},
+	})
+	tl.(EntryWriter).WriteEntry(e)
+	if !strings.HasSuffix(plain.String(), "[INFO]  core: started: did=did1 count=2\n") {
+		t.Fatalf("Invalid text of the binary entry %q", plain.String())
+	}
+}
+
+func TestSingleSpace(t *testing.T) {
+	var buf bytes.Buffer
+	opts := &LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+		Color:       []ColorOption{ColorOff},
+		Output:      []io.Writer{&buf},
+	}
+	l := New(opts)
+	l.Info("started")
+	if buf.String() != "[INFO]  started\n" {
+		t.Fatalf("Invalid padded output %q", buf.String())
+	}
+
+	opts.SingleSpace = true
+	tests := []struct {
+		name   string
+		caller bool
+		out    string
+	}{
+		{"", false, `^\[INFO\] started\n$`},
+		{"core", false, `^\[INFO\] core: started\n$`},
+		{"", true, `^\[INFO\] logger/logger_test\.go:\d+: started\n$`},
+		{"core", true, `^\[INFO\] logger/logger_test\.go:\d+: core: started\n$`},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		opts.Name = tt.name
+		opts.IncludeLocation = tt.caller
+		New(opts).Info("started")
+		if !regexp.MustCompile(tt.out).MatchString(buf.String()) {
+			t.Fatalf("Invalid spacing for name %q caller %v, got %q", tt.name, tt.caller, buf.String())
+		}
+	}
+}
+
+type flushBuffer struct {
+	bytes.Buffer
+	flushed chan string
+}
+
+func (b *flushBuffer) Flush() error {
+	b.flushed <- b.String()
+	return nil
+}
+
+func TestWithAutoFlush(t *testing.T) {
+	buf := &flushBuffer{flushed: make(chan string, 1)}
+	l := New(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+		Color:       []ColorOption{ColorOff},
+		Output:      []io.Writer{buf},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	rl := l.(AutoFlusher).WithAutoFlush(ctx)
+	rl.Info("request served")
+	select {
+	case <-buf.flushed:
+		t.Fatal("Output flushed before the context is done")
+	default:
+	}
+	cancel()
+	select {
+	case out := <-buf.flushed:
+		if out != "[INFO]  request served\n" {
+			t.Fatalf("Invalid flushed output %q", out)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Output not flushed after the context is done")
+	}
+}
+
+func TestOrderedJSON(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	l, buf := newTestLogger(&LoggerOptions{
+		Name:             "core",
+		Level:            Info,
+		JSONFormat:       true,
+		OrderedJSON:      true,
+		JSONNumericLevel: true,
+		Clock:            func() time.Time { return ts },
+	})
+	golden := `{"@timestamp":"2023-01-02T03:04:05.000000Z","@level":"info","@level_num":3,"@module":"core","@message":"stored","zeta":1,"alpha":"a","mid":true}` + "\n"
+	for i := 0; i < 20; i++ {
+		buf.Reset()
+		l.With("zeta", 1).Info("stored", "alpha", "a", "mid", true)
+		if buf.String() != golden {
+			t.Fatalf("Invalid JSON key order, got %s", buf.String())
+		}
+	}
+	// A repeated key keeps its first position with the last value
+	buf.Reset()
+	l.With("zeta", 1).Info("stored", "alpha", "a", "mid", true, "alpha", "b", "zeta", 2)
+	golden = `{"@timestamp":"2023-01-02T03:04:05.000000Z","@level":"info","@level_num":3,"@module":"core","@message":"stored","zeta":2,"alpha":"b","mid":true}` + "\n"
+	if buf.String() != golden {
+		t.Fatalf("Invalid repeated JSON key, got %s", buf.String())
+	}
+	buf.Reset()
+	l.Info("stored", "ch", make(chan int))
+	if !strings.HasPrefix(buf.String(), `{"@timestamp":"2023-01-02T03:04:05.000000Z","@level":"info","@level_num":3,"@module":"core","@message":"stored","@warn":`) {
+		t.Fatalf("Invalid unsupported type fallback, got %s", buf.String())
+	}
+}
+
+func TestJSONDetailOutput(t *testing.T) {
+	var detail bytes.Buffer
+	l, buf := newTestLogger(&LoggerOptions{
+		Name:             "core",
+		Level:            Info,
+		JSONFormat:       true,
+		JSONDetailOutput: &detail,
+	})
+	l.Info("stored", "token", "pt1", "value", 0.5)
+	var entry, rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal("Failed to parse the entry", err)
+	}
+	if err := json.Unmarshal(detail.Bytes(), &rec); err != nil {
+		t.Fatal("Failed to parse the detail record", err)
+	}
+	id, ok := entry["@detail"].(string)
+	if !ok || id == "" || rec["@detail"] != id {
+		t.Fatalf("Invalid detail reference %v, record %v", entry, rec)
+	}
+	if _, ok := entry["token"]; ok || entry["@message"] != "stored" || entry["@module"] != "core" {
+		t.Fatalf("Invalid summary entry %v", entry)
+	}
+	if rec["token"] != "pt1" || rec["value"] != 0.5 || rec["@message"] != "stored" {
+		t.Fatalf("Invalid detail record %v", rec)
+	}
+
+	buf.Reset()
+	detail.Reset()
+	l.Info("started")
+	if detail.Len() != 0 || !strings.Contains(buf.String(), `"@message":"started"`) || strings.Contains(buf.String(), "@detail") {
+		t.Fatalf("Expected the entry without fields kept whole, got %s", buf.String())
+	}
+}
+
+type testAmount struct {
+	units int64
+}
+
+func TestRegisterFormatter(t *testing.T) {
+	typ := reflect.TypeOf(testAmount{})
+	RegisterFormatter(typ, func(v interface{}) (string, bool) {
+		a := v.(testAmount)
+		return fmt.Sprintf("%d.%03dRBT", a.units/1000, a.units%1000), true
+	})
+	RegisterJSONFormatter(typ, func(v interface{}) (interface{}, bool) {
+		return float64(v.(testAmount).units) / 1000, true
+	})
+	defer RegisterFormatter(typ, nil)
+	defer RegisterJSONFormatter(typ, nil)
+
+	var buf bytes.Buffer
+	opts := &LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+		Color:       []ColorOption{ColorOff},
+		Output:      []io.Writer{&buf},
+	}
+	New(opts).Info("sent", "amount", testAmount{units: 1500})
+	if buf.String() != "[INFO]  sent: amount=1.500RBT\n" {
+		t.Fatalf("Invalid formatted value %q", buf.String())
+	}
+
+	buf.Reset()
+	opts.JSONFormat = true
+	New(opts).Info("sent", "amount", testAmount{units: 1500})
+	var vals map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+		t.Fatal("Failed to parse the entry", err)
+	}
+	if vals["amount"] != 1.5 {
+		t.Fatalf("Invalid JSON formatted value %v", vals["amount"])
+	}
+
+	RegisterFormatter(typ, nil)
+	buf.Reset()
+	opts.JSONFormat = false
+	New(opts).Info("sent", "amount", testAmount{units: 1500})
+	if buf.String() != "[INFO]  sent: amount={1500}\n" {
+		t.Fatalf("Expected the default rendering, got %q", buf.String())
+	}
+}
+
+func TestRecoverAndLog(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+	})
+	handler := func() {
+		defer RecoverAndLog(l.With("path", "/api/get-part-tokens"))
+		var m map[string]int
+		m["k"] = 1
+	}
+	var r interface{}
+	func() {
+		defer func() { r = recover() }()
+		handler()
+	}()
+	if r == nil {
+		t.Fatal("Expected the panic to be raised again")
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "[ERROR] Recovered panic: path=/api/get-part-tokens panic=\"assignment to entry in nil map\"\n") {
+		t.Fatalf("Invalid panic entry %q", out)
+	}
+	if !strings.Contains(out, "logger.TestRecoverAndLog") {
+		t.Fatalf("Panic entry is missing the stack %q", out)
+	}
+}
+
+func TestJSONModuleLeaf(t *testing.T) {
+	var buf bytes.Buffer
+	opts := &LoggerOptions{
+		Name:       "core",
+		Level:      Info,
+		JSONFormat: true,
+		Color:      []ColorOption{ColorOff},
+		Output:     []io.Writer{&buf},
+	}
+	New(opts).Named("wallet").Named("storage").Info("opened")
+	var vals map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+		t.Fatal("Failed to parse the entry", err)
+	}
+	if _, ok := vals["@module_path"]; ok || vals["@module"] != "core.wallet.storage" {
+		t.Fatalf("Expected the full module name by default, got %v", vals)
+	}
+
+	buf.Reset()
+	opts.JSONModuleLeaf = true
+	New(opts).Named("wallet").Named("storage").Info("opened")
+	vals = nil
+	if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+		t.Fatal("Failed to parse the entry", err)
+	}
+	if vals["@module"] != "storage" || vals["@module_path"] != "core.wallet.storage" {
+		t.Fatalf("Invalid module fields %v", vals)
+	}
+	e, err := ParseLine(buf.Bytes())
+	if err != nil || e.Name != "core.wallet.storage" || len(e.Args) != 0 {
+		t.Fatalf("Invalid parsed entry %+v, %v", e, err)
+	}
+}
+
+func TestStacktraceMinLevel(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:              Debug,
+		DisableTime:        true,
+		StacktraceMinLevel: Warn,
+	})
+	l.Info("below", "k", "v")
+	if buf.String() != "[INFO]  below: k=v\n" {
+		t.Fatalf("Unexpected stacktrace below the level %q", buf.String())
+	}
+	for _, fn := range []func(string, ...interface{}){l.Warn, l.Error} {
+		buf.Reset()
+		fn("above", "k", "v")
+		if !strings.Contains(buf.String(), "above: k=v\n") || !strings.Contains(buf.String(), "logger.TestStacktraceMinLevel") {
+			t.Fatalf("Expected the stacktrace at or above the level %q", buf.String())
+		}
+	}
+	buf.Reset()
+	l.Error("explicit", Stacktrace())
+	if strings.Count(buf.String(), "logger.TestStacktraceMinLevel") != 1 {
+		t.Fatalf("Expected a single stacktrace %q", buf.String())
+	}
+}
+
+func BenchmarkStacktraceMinLevel(b *testing.B) {
+	l, _ := newTestLogger(&LoggerOptions{
+		Level:              Debug,
+		StacktraceMinLevel: Error,
+	})
+	b.Run("below", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l.Info("message", "k", "v")
+		}
+	})
+	b.Run("above", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l.Error("message", "k", "v")
+		}
+	})
+}
+
+type writeCounter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestAtomicWrites(t *testing.T) {
+	wc := &writeCounter{}
+	var werr error
+	l := New(&LoggerOptions{
+		Level:        Info,
+		AtomicWrites: true,
+		Color:        []ColorOption{ColorOff, ColorOff},
+		Output:       []io.Writer{wc, &shortWriter{max: 8}},
+		OnError:      func(err error) { werr = err },
+	})
+	l.Error("failed", "k", "v", Stacktrace())
+	if wc.writes != 1 || strings.Count(wc.String(), "\n") < 2 {
+		t.Fatalf("Expected a single write for the entry, got %d writes", wc.writes)
+	}
+	if werr != io.ErrShortWrite {
+		t.Fatal("Expected the short write reported, got", werr)
+	}
+
+	name := t.TempDir() + "/log.txt"
+	var wg sync.WaitGroup
+	for p := 0; p < 2; p++ {
+		f, err := OpenAppendFile(name)
+		if err != nil {
+			t.Fatal("Failed to open the log file", err)
+		}
+		defer f.Close()
+		pl := New(&LoggerOptions{
+			Level:        Info,
+			DisableTime:  true,
+			AtomicWrites: true,
+			Color:        []ColorOption{ColorOff},
+			Output:       []io.Writer{f},
+		})
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				pl.Info("entry", "writer", p, "data", strings.Repeat(strconv.Itoa(p), 2000))
+			}
+		}(p)
+	}
+	wg.Wait()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal("Failed to read the log file", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 400 {
+		t.Fatalf("Expected 400 lines, got %d", len(lines))
+	}
+	for _, line := range lines {
+		if line != "[INFO]  entry: writer=0 data="+strings.Repeat("0", 2000) && line != "[INFO]  entry: writer=1 data="+strings.Repeat("1", 2000) {
+			t.Fatalf("Torn log line %.80q", line)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Name:              "core",
+		Level:             Warn,
+		JSONFormat:        true,
+		JSONTimePrecision: MilliPrecision,
+		IncludeLocation:   true,
+		OnError:           func(err error) {},
+	})
+	cfg := l.Named("wallet").(ConfigReporter).Config()
+	if cfg.Name != "core.wallet" || cfg.Level != Warn || !cfg.JSONFormat || cfg.JSONTimePrecision != MilliPrecision ||
+		!cfg.IncludeLocation || cfg.TimeFormat != TimeFormat || cfg.DisableTime {
+		t.Fatalf("Invalid logger config %+v", cfg)
+	}
+	if len(cfg.Color) != 1 || cfg.Color[0] != ColorOff {
+		t.Fatalf("Invalid logger colors %v", cfg.Color)
+	}
+	if cfg.Output != nil || cfg.Mutex != nil || cfg.OnError != nil || cfg.Clock != nil {
+		t.Fatal("Logger config exposes the internal state")
+	}
+	cfg.Color[0] = ForceColor
+	l.SetLevel(Error)
+	cfg = l.(ConfigReporter).Config()
+	if cfg.Color[0] != ColorOff || cfg.Level != Error {
+		t.Fatalf("Invalid logger config after the update %+v", cfg)
+	}
+
+	cfg = New(&LoggerOptions{DisableTime: true, Output: []io.Writer{buf}, Color: []ColorOption{ColorOff}}).(ConfigReporter).Config()
+	if !cfg.DisableTime || cfg.TimeFormat != "" || cfg.Level != DefaultLevel {
+		t.Fatalf("Invalid logger config %+v", cfg)
+	}
+}
+
+type testTyped struct {
+	N int `json:"n"`
+}
+
+func TestTyped(t *testing.T) {
+	var buf bytes.Buffer
+	opts := &LoggerOptions{
+		Level:      Info,
+		JSONFormat: true,
+		Color:      []ColorOption{ColorOff},
+		Output:     []io.Writer{&buf},
+	}
+	New(opts).Info("read", "int", Typed(1), "float", Typed(0.5), "str", Typed("s"),
+		"slice", Typed([]string{"a"}), "ptr", Typed(&testTyped{N: 2}), "nil", Typed(nil), "plain", 3)
+	var vals map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+		t.Fatal("Failed to parse the entry", err)
+	}
+	types := map[string]string{
+		"int":   "int",
+		"float": "float64",
+		"str":   "string",
+		"slice": "[]string",
+		"ptr":   "*logger.testTyped",
+		"nil":   "nil",
+	}
+	for k, typ := range types {
+		if vals[k+"@type"] != typ {
+			t.Fatalf("Invalid type of %s, got %v", k, vals[k+"@type"])
+		}
+	}
+	if vals["int"] != 1.0 || vals["str"] != "s" || !reflect.DeepEqual(vals["ptr"], map[string]interface{}{"n": 2.0}) {
+		t.Fatalf("Invalid typed values %v", vals)
+	}
+	if _, ok := vals["plain@type"]; ok {
+		t.Fatal("Unexpected type of the plain value")
+	}
+
+	buf.Reset()
+	opts.JSONFormat = false
+	opts.DisableTime = true
+	New(opts).Info("read", "int", Typed(1))
+	if buf.String() != "[INFO]  read: int=1\n" {
+		t.Fatalf("Invalid plain typed value %q", buf.String())
+	}
+}
+
+// closableBuffer panics on the writes after Close
+type closableBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closableBuffer) Write(p []byte) (int, error) {
+	if b.closed {
+		panic("write to a closed buffer")
+	}
+	return b.Buffer.Write(p)
+}
+
+func (b *closableBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (b *closableBuffer) IsClosed() bool {
+	return b.closed
+}
+
+func TestClosedWriter(t *testing.T) {
+	out := &closableBuffer{}
+	bin := &closableBuffer{}
+	bw := NewBinaryWriter(bin)
+	var errs []error
+	l := New(&LoggerOptions{
+		Level:        Info,
+		Color:        []ColorOption{ColorOff},
+		Output:       []io.Writer{out},
+		BinaryOutput: bw,
+		OnError:      func(err error) { errs = append(errs, err) },
+	})
+	l.Info("before close")
+	if out.Len() == 0 || bin.Len() == 0 || len(errs) != 0 {
+		t.Fatal("Failed to log before close", errs)
+	}
+	out.Close()
+	if err := bw.Close(); err != nil || !bw.IsClosed() || !bin.IsClosed() {
+		t.Fatal("Failed to close the binary writer", err)
+	}
+	n, bn := out.Len(), bin.Len()
+	l.Info("after close")
+	if out.Len() != n || bin.Len() != bn {
+		t.Fatal("Entry written to the closed writers")
+	}
+	if len(errs) != 2 || errs[0] != ErrWriterClosed || errs[1] != ErrWriterClosed {
+		t.Fatal("Expected the closed writers reported, got", errs)
+	}
+}
+
+func TestECSStacktrace(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Name:            "core",
+		Level:           Info,
+		JSONFormat:      true,
+		JSONProfile:     JSONProfileECS,
+		IncludeLocation: true,
+	})
+	l.Error("failed to commit", "did", "did1", Stacktrace())
+	var vals map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+		t.Fatal("Failed to parse the entry", err)
+	}
+	if vals["message"] != "failed to commit" || vals["log.level"] != "error" || vals["log.logger"] != "core" || vals["ecs.version"] != ECSVersion {
+		t.Fatalf("Invalid ECS fields %v", vals)
+	}
+	if vals["error.message"] != "failed to commit" || vals["did"] != "did1" {
+		t.Fatalf("Invalid ECS error fields %v", vals)
+	}
+	st, ok := vals["error.stack_trace"].(string)
+	if !ok || !strings.Contains(st, "TestECSStacktrace") {
+		t.Fatalf("Expected the stacktrace in error.stack_trace, got %v", vals["error.stack_trace"])
+	}
+	if f, _ := vals["log.origin.file.name"].(string); !strings.HasSuffix(f, "logger_test.go") {
+		t.Fatalf("Invalid log origin %v", vals["log.origin.file.name"])
+	}
+	if _, ok := vals["stacktrace"]; ok {
+		t.Fatal("Expected no stacktrace field in the ECS profile")
+	}
+
+	// No error fields without a stack
+	buf.Reset()
+	l.Error("failed to commit")
+	vals = nil
+	if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+		t.Fatal("Failed to parse the entry", err)
+	}
+	if _, ok := vals["error.stack_trace"]; ok {
+		t.Fatalf("Expected no error fields, got %v", vals)
+	}
+}
+
+type testStructNode struct {
+	Name  string          `json:"name"`
+	Value int             `json:"value,omitempty"`
+	Next  *testStructNode `json:"next"`
+	Skip  string          `json:"-"`
+	note  string
+}
+
+func TestWithStruct(t *testing.T) {
+	var buf bytes.Buffer
+	opts := &LoggerOptions{
+		Level:       Info,
+		JSONFormat:  true,
+		OrderedJSON: true,
+		DisableTime: true,
+		Color:       []ColorOption{ColorOff},
+		Output:      []io.Writer{&buf},
+	}
+	deep := &testStructNode{Name: "a", Value: 1, Skip: "x", note: "y", Next: &testStructNode{Name: "b", Next: &testStructNode{Name: "c", Next: &testStructNode{Name: "d"}}}}
+	New(opts).(StructLogger).WithStruct("n", deep).Info("nested")
+	var vals map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+		t.Fatal("Failed to parse the entry", err)
+	}
+	if vals["n.name"] != "a" || vals["n.value"] != 1.0 || vals["n.next.name"] != "b" || vals["n.next.next.name"] != "c" {
+		t.Fatalf("Invalid flattened fields %v", vals)
+	}
+	// The default depth renders the fourth level as a single field
+	if s, ok := vals["n.next.next.next"].(string); !ok || !strings.HasPrefix(s, "{d 0 <nil>") {
+		t.Fatalf("Expected the struct beyond the depth as a single field, got %v", vals)
+	}
+	if _, ok := vals["n.Skip"]; ok {
+		t.Fatal("Expected the skipped field left out")
+	}
+
+	buf.Reset()
+	opts.StructMaxDepth = 1
+	New(opts).(StructLogger).WithStruct("n", deep).Info("nested")
+	vals = nil
+	if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+		t.Fatal("Failed to parse the entry", err)
+	}
+	if _, ok := vals["n.next"].(string); !ok || vals["n.name"] != "a" {
+		t.Fatalf("Expected the nested struct as a single field, got %v", vals)
+	}
+
+	// A self-referential struct must not loop
+	buf.Reset()
+	opts.StructMaxDepth = 100
+	cyc := &testStructNode{Name: "self"}
+	cyc.Next = cyc
+	done := make(chan struct{})
+	go func() {
+		New(opts).(StructLogger).WithStruct("n", cyc).Info("cycle")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WithStruct did not return on a cyclic struct")
+	}
+	vals = nil
+	if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+		t.Fatal("Failed to parse the entry", err)
+	}
+	if vals["n.name"] != "self" || vals["n.next"] != "<cycle>" {
+		t.Fatalf("Expected the cycle marked, got %v", vals)
+	}
+
+	if args := structArgs("", 5, 3); len(args) != 2 || args[0] != "value" || args[1] != 5 {
+		t.Fatalf("Invalid args for a non struct value %v", args)
+	}
+}
+
+func TestSampleBuckets(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:             Info,
+		DisableTime:       true,
+		SampleRates:       map[string]int{"auth": 1, "heartbeat": 10},
+		DefaultSampleRate: 2,
+	})
+	auth := l.(SampleBucketer).WithSampleBucket("auth")
+	heartbeat := l.Named("peer").(SampleBucketer).WithSampleBucket("heartbeat")
+	for i := 0; i < 100; i++ {
+		auth.Info("auth event")
+		heartbeat.Info("heartbeat event")
+		l.Info("other event")
+	}
+	out := buf.String()
+	if n := strings.Count(out, "auth event"); n != 100 {
+		t.Fatalf("Expected all the auth entries, got %d", n)
+	}
+	if n := strings.Count(out, "heartbeat event"); n != 10 {
+		t.Fatalf("Expected 1 in 10 heartbeat entries, got %d", n)
+	}
+	if n := strings.Count(out, "other event"); n != 50 {
+		t.Fatalf("Expected 1 in 2 unbucketed entries, got %d", n)
+	}
+	if c := l.(ConfigReporter).Config(); c.SampleRates["heartbeat"] != 10 || c.DefaultSampleRate != 2 {
+		t.Fatalf("Invalid sample rates in the config %+v", c)
+	}
+}
+
+func TestDeferUntilLevel(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:           Trace,
+		DisableTime:     true,
+		DeferUntilLevel: true,
+		DeferBufferSize: 4,
+	})
+	sub := l.Named("boot").With("phase", "init")
+	l.Debug("debug before")
+	sub.Info("info before")
+	l.Warn("warn before")
+	if buf.Len() != 0 {
+		t.Fatalf("Expected the entries held until the level is set, got %q", buf.String())
+	}
+	l.SetLevel(Info)
+	out := buf.String()
+	if strings.Contains(out, "debug before") {
+		t.Fatalf("Expected the debug entry filtered by the final level, got %q", out)
+	}
+	if !strings.Contains(out, "[INFO]  boot: info before: phase=init") || !strings.Contains(out, "warn before") {
+		t.Fatalf("Expected the qualifying entries written, got %q", out)
+	}
+	if strings.Index(out, "info before") > strings.Index(out, "warn before") {
+		t.Fatalf("Expected the entries in their order, got %q", out)
+	}
+
+	buf.Reset()
+	l.Info("info after")
+	l.Debug("debug after")
+	if out := buf.String(); !strings.Contains(out, "info after") || strings.Contains(out, "debug after") {
+		t.Fatalf("Expected the entries written directly after the release, got %q", out)
+	}
+
+	// The buffer keeps the latest entries up to its size
+	buf.Reset()
+	l = New(&LoggerOptions{
+		Level:           Info,
+		DisableTime:     true,
+		DeferUntilLevel: true,
+		DeferBufferSize: 2,
+		Color:           []ColorOption{ColorOff},
+		Output:          []io.Writer{buf},
+	})
+	for i := 0; i < 5; i++ {
+		l.Info("held", "i", i)
+	}
+	l.(DeferredLogger).ReleaseBuffer()
+	out = buf.String()
+	if strings.Count(out, "held") != 2 || !strings.Contains(out, "i=4") || !strings.Contains(out, "dropped=3") {
+		t.Fatalf("Expected the last 2 entries and the dropped count, got %q", out)
+	}
+}
+
+func TestGoroutineMonitor(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:       Debug,
+		DisableTime: true,
+	})
+	tick := make(chan time.Time)
+	m := NewGoroutineMonitor(l, GoroutineMonitorOptions{
+		Interval: time.Second,
+		Limit:    runtime.NumGoroutine() + 50,
+		After:    func(d time.Duration) <-chan time.Time { return tick },
+	})
+	tick <- time.Now()
+
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-release
+		}()
+	}
+	tick <- time.Now()
+	// A tick is received once the previous count is logged
+	tick <- time.Now()
+	close(release)
+	wg.Wait()
+	if err := m.Close(); err != nil {
+		t.Fatal("Failed to close the monitor", err)
+	}
+	m.Close()
+
+	out := buf.String()
+	if !strings.Contains(out, "[DEBUG] Goroutine count: goroutines=") {
+		t.Fatalf("Expected the count at Debug below the limit, got %q", out)
+	}
+	if !strings.Contains(out, "[WARN]  Goroutine count above the limit: goroutines=") {
+		t.Fatalf("Expected the count at Warn above the limit, got %q", out)
+	}
+}
+
+func TestTimeFormatByLevel(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Date(2023, 1, 1, 0, 0, 0, 123456789, time.UTC)
+	opts := &LoggerOptions{
+		Level:             Trace,
+		Clock:             func() time.Time { return now },
+		TimeFormatByLevel: map[Level]string{Trace: time.RFC3339Nano},
+		Color:             []ColorOption{ColorOff},
+		Output:            []io.Writer{&buf},
+	}
+	l := New(opts)
+	l.Trace("tight")
+	l.Info("normal")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "2023-01-01T00:00:00.123456789Z [TRACE]") {
+		t.Fatalf("Expected the nanosecond override for Trace, got %q", buf.String())
+	}
+	if !strings.HasPrefix(lines[1], "2023-01-01T00:00:00.123Z [INFO]") {
+		t.Fatalf("Expected the default format for Info, got %q", lines[1])
+	}
+
+	buf.Reset()
+	opts.JSONFormat = true
+	l = New(opts)
+	l.Trace("tight")
+	l.Info("normal")
+	dec := json.NewDecoder(&buf)
+	var tv, iv map[string]interface{}
+	if err := dec.Decode(&tv); err != nil {
+		t.Fatal("Failed to parse the entry", err)
+	}
+	if err := dec.Decode(&iv); err != nil {
+		t.Fatal("Failed to parse the entry", err)
+	}
+	if tv["@timestamp"] != "2023-01-01T00:00:00.123456789Z" || iv["@timestamp"] != "2023-01-01T00:00:00.123456Z" {
+		t.Fatalf("Invalid JSON timestamps %v, %v", tv["@timestamp"], iv["@timestamp"])
+	}
+}
+
+func TestColorPerOutput(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "log")
+	if err != nil {
+		t.Fatal("Failed to create the log file", err)
+	}
+	defer f.Close()
+	var term bytes.Buffer
+	colors := []ColorOption{AutoColor, ForceColor}
+	l := New(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+		Color:       colors,
+		Output:      []io.Writer{f, &term},
+	})
+	l.Warn("mixed outputs", "k", "v")
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal("Failed to read the log file", err)
+	}
+	if string(b) != "[WARN]  mixed outputs: k=v\n" {
+		t.Fatalf("Expected the plain text in the file, got %q", b)
+	}
+	if !strings.Contains(term.String(), "\x1b[") || !strings.Contains(term.String(), "mixed outputs: k=v") {
+		t.Fatalf("Expected the forced color output colored, got %q", term.String())
+	}
+	if colors[0] != AutoColor {
+		t.Fatal("Expected the color options of the caller left as is")
+	}
+
+	// The outputs without a color option are plain
+	var plain bytes.Buffer
+	New(&LoggerOptions{Level: Info, DisableTime: true, Output: []io.Writer{&plain}}).Info("no color")
+	if plain.String() != "[INFO]  no color\n" {
+		t.Fatalf("Invalid output without color option %q", plain.String())
+	}
+}
+
+func TestWithTxID(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+	})
+	tl := WithTxID(l.With("a", 1), "tx1")
+	tl.Named("wallet").With("did", "did1").Info("moved", "count", 2)
+	if buf.String() != "[INFO]  wallet: moved: tx_id=tx1 a=1 did=did1 count=2\n" {
+		t.Fatalf("Expected the tx_id first across Named and With, got %q", buf.String())
+	}
+	if args := tl.With("b", 2).ImpliedArgs(); !reflect.DeepEqual(args, []interface{}{"tx_id", "tx1", "a", 1, "b", 2}) {
+		t.Fatalf("Invalid implied args %v", args)
+	}
+
+	buf.Reset()
+	l.Info("other")
+	if strings.Contains(buf.String(), "tx_id") {
+		t.Fatalf("Expected the parent logger without the tx_id, got %q", buf.String())
+	}
+
+	// A logger which is not a TxLogger gets the tx_id as an implied arg
+	buf.Reset()
+	WithTxID(struct{ Logger }{l}, "tx2").Info("plain")
+	if buf.String() != "[INFO]  plain: tx_id=tx2\n" {
+		t.Fatalf("Expected the tx_id of the plain logger, got %q", buf.String())
+	}
+}
+
+func TestJSONEntryHash(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:         Info,
+		JSONFormat:    true,
+		JSONEntryHash: true,
+		IncludeUptime: true,
+		Clock:         func() time.Time { return now },
+	})
+	hash := func(fn func()) string {
+		buf.Reset()
+		fn()
+		var vals map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+			t.Fatal("Failed to parse the entry", err)
+		}
+		h, _ := vals["@hash"].(string)
+		if h == "" {
+			t.Fatalf("Expected the @hash in the entry, got %v", vals)
+		}
+		return h
+	}
+	h1 := hash(func() { l.Info("token moved", "did", "did1", "count", 2) })
+	now = now.Add(time.Minute)
+	h2 := hash(func() { l.Info("token moved", "did", "did1", "count", 2) })
+	if h1 != h2 {
+		t.Fatalf("Expected the entries differing by the time to share the hash, %s != %s", h1, h2)
+	}
+	if h := hash(func() { l.Info("token moved", "did", "did2", "count", 2) }); h == h1 {
+		t.Fatal("Expected a changed field to change the hash")
+	}
+	if h := hash(func() { l.Info("token burnt", "did", "did1", "count", 2) }); h == h1 {
+		t.Fatal("Expected a changed message to change the hash")
+	}
+	if h := hash(func() { l.Warn("token moved", "did", "did1", "count", 2) }); h == h1 {
+		t.Fatal("Expected a changed level to change the hash")
+	}
+}
+
+func TestAttachTap(t *testing.T) {
+	var out, tapBuf bytes.Buffer
+	l := New(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+		Color:       []ColorOption{ColorOff},
+		Output:      []io.Writer{&out},
+	})
+	sub := l.Named("sub")
+	l.Info("before")
+	detach := l.(TapAttacher).AttachTap(&tapBuf)
+	l.Info("during", "k", 1)
+	sub.Info("from sub")
+	l.Debug("suppressed")
+	detach()
+	detach()
+	l.Info("after")
+
+	want := "[INFO]  during: k=1\n[INFO]  sub: from sub\n"
+	if tapBuf.String() != want {
+		t.Fatalf("Expected the tap to see %q, got %q", want, tapBuf.String())
+	}
+	if !strings.Contains(out.String(), "before") || !strings.Contains(out.String(), "after") {
+		t.Fatalf("Expected the outputs unaffected, got %q", out.String())
+	}
+
+	// Attaching and detaching while logging concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				var b bytes.Buffer
+				d := l.(TapAttacher).AttachTap(&b)
+				l.Info("concurrent")
+				d()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAttachTapResetOutput(t *testing.T) {
+	var out, tapBuf bytes.Buffer
+	l := New(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+		Color:       []ColorOption{ColorOff},
+		Output:      []io.Writer{&out},
+	})
+	detach := l.(TapAttacher).AttachTap(&tapBuf)
+	var next bytes.Buffer
+	if err := l.(OutputResettable).ResetOutput(&LoggerOptions{Color: []ColorOption{ColorOff}, Output: []io.Writer{&next}}); err != nil {
+		t.Fatal("Failed to reset the output", err)
+	}
+	l.Info("after reset")
+	detach()
+	l.Info("after detach")
+
+	if tapBuf.String() != "[INFO]  after reset\n" {
+		t.Fatalf("Expected the tap kept over the reset, got %q", tapBuf.String())
+	}
+	if next.String() != "[INFO]  after reset\n[INFO]  after detach\n" || out.Len() != 0 {
+		t.Fatalf("Expected the entries on the new output, got %q and %q", next.String(), out.String())
+	}
+}
+
+func TestTransferBuffer(t *testing.T) {
+	var boot, out bytes.Buffer
+	l := New(&LoggerOptions{
+		Level:           Trace,
+		DisableTime:     true,
+		DeferUntilLevel: true,
+		Color:           []ColorOption{ColorOff},
+		Output:          []io.Writer{&boot},
+	})
+	sub := l.Named("boot").With("phase", "init")
+	l.Debug("debug before")
+	sub.Info("info before")
+	l.Warn("warn before", "n", 1)
+
+	to := New(&LoggerOptions{
+		Level:      Info,
+		JSONFormat: true,
+		Color:      []ColorOption{ColorOff},
+		Output:     []io.Writer{&out},
+	})
+	if err := l.(DeferredLogger).TransferBuffer(to); err != nil {
+		t.Fatal("Failed to transfer the buffer", err)
+	}
+	if boot.Len() != 0 {
+		t.Fatalf("Expected nothing written to the original outputs, got %q", boot.String())
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected the entries passing the target level, got %q", out.String())
+	}
+	var first, second map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal("Invalid JSON", err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatal("Invalid JSON", err)
+	}
+	if first["@message"] != "info before" || first["@module"] != "boot" || first["phase"] != "init" || first["@level"] != "info" {
+		t.Fatalf("Unexpected first entry %v", first)
+	}
+	if second["@message"] != "warn before" || second["n"] != float64(1) || second["@level"] != "warn" {
+		t.Fatalf("Unexpected second entry %v", second)
+	}
+
+	if err := l.(DeferredLogger).TransferBuffer(to); err != ErrBufferReleased {
+		t.Fatalf("Expected %v, got %v", ErrBufferReleased, err)
+	}
+	l.Info("info after")
+	if !strings.Contains(boot.String(), "info after") {
+		t.Fatalf("Expected the later entries written directly, got %q", boot.String())
+	}
+	if err := to.(DeferredLogger).TransferBuffer(l); err != ErrNotDeferred {
+		t.Fatalf("Expected %v, got %v", ErrNotDeferred, err)
+	}
+	// The target has to write the entries as they are
+	if err := l.(DeferredLogger).TransferBuffer(struct{ Logger }{to}); err != ErrNoEntryWriter {
+		t.Fatalf("Expected %v, got %v", ErrNoEntryWriter, err)
+	}
+}
+
+func TestGetLevel(t *testing.T) {
+	l, _ := newTestLogger(&LoggerOptions{
+		Level: Info,
+	})
+	sub := l.Named("sub").With("k", "v")
+	if l.GetLevel() != Info || sub.GetLevel() != Info {
+		t.Fatalf("Expected %v, got %v and %v", Info, l.GetLevel(), sub.GetLevel())
+	}
+	sub.SetLevel(Debug)
+	if l.GetLevel() != Debug || sub.GetLevel() != Debug {
+		t.Fatalf("Expected %v shared with the sub-logger, got %v and %v", Debug, l.GetLevel(), sub.GetLevel())
+	}
+}
+
+func TestWithCategory(t *testing.T) {
+	var security, performance, other, errs bytes.Buffer
+	rw := NewRouterWriter(&other).
+		RouteCategory("security", &security).
+		RouteCategory("performance", &performance).
+		RouteLevel(Error, &errs)
+	l := New(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+		Color:       []ColorOption{ColorOff},
+		Output:      []io.Writer{rw},
+	})
+	l.(CategoryLogger).WithCategory("security").Info("login failed", "user", "u1")
+	l.(CategoryLogger).WithCategory("performance").Named("db").Warn("slow query")
+	l.(CategoryLogger).WithCategory("security").Error("token rejected")
+	l.Info("plain")
+	l.Error("failed")
+
+	if want := "[INFO]  login failed: @category=security user=u1\n[ERROR] token rejected: @category=security\n"; security.String() != want {
+		t.Fatalf("Expected %q, got %q", want, security.String())
+	}
+	if want := "[WARN]  db: slow query: @category=performance\n"; performance.String() != want {
+		t.Fatalf("Expected %q, got %q", want, performance.String())
+	}
+	if other.String() != "[INFO]  plain\n" || errs.String() != "[ERROR] failed\n" {
+		t.Fatalf("Expected the entries without category routed by level, got %q and %q", other.String(), errs.String())
+	}
+
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:      Info,
+		JSONFormat: true,
+	})
+	l.(CategoryLogger).WithCategory("security").Info("login failed")
+	var vals map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+		t.Fatal("Invalid JSON", err)
+	}
+	if vals[CategoryKey] != "security" {
+		t.Fatalf("Expected the category field, got %v", vals)
+	}
+}
+
+func TestOffLevel(t *testing.T) {
+	ring := NewRingBufferWriter(4)
+	l, buf := newTestLogger(&LoggerOptions{
+		Level:      Info,
+		RingBuffer: ring,
+	})
+	sub := l.Named("noisy").With("k", "v")
+	sub.SetLevel(Off)
+	for level := Trace; level <= Off; level++ {
+		l.Log(level, "log", "level", level)
+		sub.Log(level, "sub log")
+	}
+	l.Info("info")
+	l.Error("error")
+	sub.Error("sub error")
+	l.(EntryWriter).WriteEntry(Entry{Time: time.Now(), Level: Error, Message: "entry"})
+	if buf.Len() != 0 {
+		t.Fatalf("Expected no output at the Off level, got %q", buf.String())
+	}
+	var dump bytes.Buffer
+	if err := ring.Dump(&dump); err != nil || dump.Len() != 0 {
+		t.Fatalf("Expected nothing in the ring buffer, got %q, %v", dump.String(), err)
+	}
+	if Off.String() != "off" {
+		t.Fatalf("Unexpected level name %q", Off.String())
+	}
+
+	l.SetLevel(Error)
+	sub.Error("sub error")
+	if !strings.Contains(buf.String(), "sub error") {
+		t.Fatalf("Expected the output back after the Off level, got %q", buf.String())
+	}
+}
+
+func TestFatal(t *testing.T) {
+	defer func(exit func(int), code int) {
+		osExit, FatalExitCode = exit, code
+	}(osExit, FatalExitCode)
+	var exited []int
+	osExit = func(code int) { exited = append(exited, code) }
+
+	buf := &flushBuffer{flushed: make(chan string, 1)}
+	l := New(&LoggerOptions{
+		Level:           Info,
+		JSONFormat:      true,
+		DeferUntilLevel: true,
+		Color:           []ColorOption{ColorOff},
+		Output:          []io.Writer{buf},
+	})
+	l.Info("starting")
+	l.Fatal("failed to open the wallet", "err", "locked")
+	if len(exited) != 1 || exited[0] != 1 {
+		t.Fatalf("Expected exit with 1, got %v", exited)
+	}
+	var flushed string
+	select {
+	case flushed = <-buf.flushed:
+	default:
+		t.Fatal("Outputs not flushed before the exit")
+	}
+	lines := strings.Split(strings.TrimSpace(flushed), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "starting") {
+		t.Fatalf("Expected the held entry and the fatal entry flushed, got %q", flushed)
+	}
+	var vals map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &vals); err != nil {
+		t.Fatal("Invalid JSON", err)
+	}
+	if vals["@level"] != "fatal" || vals["@message"] != "failed to open the wallet" {
+		t.Fatalf("Unexpected fatal entry %v", vals)
+	}
+
+	var plain bytes.Buffer
+	FatalExitCode = 3
+	l = New(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+		Color:       []ColorOption{ColorOff},
+		Output:      []io.Writer{&plain},
+	})
+	l.Fatal("halt")
+	if plain.String() != "[FATAL] halt\n" || exited[1] != 3 {
+		t.Fatalf("Expected the plain fatal entry and exit with 3, got %q and %v", plain.String(), exited)
+	}
+
+	// The Off level silences the entry, the process still exits
+	plain.Reset()
+	l.SetLevel(Off)
+	l.Fatal("halt")
+	if plain.Len() != 0 || len(exited) != 3 {
+		t.Fatalf("Expected the exit without output, got %q and %v", plain.String(), exited)
+	}
+}
+
+type slowWriter struct {
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	return len(p), nil
+}
+
+func TestFlushLatency(t *testing.T) {
+	l := New(&LoggerOptions{
+		Level:               Info,
+		FlushLatencySamples: 8,
+		Color:               []ColorOption{ColorOff},
+		Output:              []io.Writer{&slowWriter{delay: 20 * time.Millisecond}},
+	})
+	fr := l.(FlushLatencyReporter)
+	if p50, p99 := fr.FlushLatency(); p50 != 0 || p99 != 0 {
+		t.Fatalf("Expected no latency before any write, got %v %v", p50, p99)
+	}
+	for i := 0; i < 4; i++ {
+		l.Info("slow")
+	}
+	p50, p99 := fr.FlushLatency()
+	if p50 < 20*time.Millisecond || p99 < p50 || p99 > time.Second {
+		t.Fatalf("Expected the latency to reflect the 20ms delay, got p50 %v p99 %v", p50, p99)
+	}
+	if l.(ConfigReporter).Config().FlushLatencySamples != 8 {
+		t.Fatalf("Expected the samples in the config, got %d", l.(ConfigReporter).Config().FlushLatencySamples)
+	}
+
+	// The window keeps the latest samples only
+	lw := newLatencyWindow(4)
+	for _, d := range []time.Duration{100, 1, 2, 3, 4, 5} {
+		lw.record(d)
+	}
+	if ps := lw.percentiles(0.5, 0.99); ps[0] != 3 || ps[1] != 5 {
+		t.Fatalf("Expected p50 3 and p99 5 of the latest samples, got %v", ps)
+	}
+}
+
+type testCtxKey string
+
+func TestContextFields(t *testing.T) {
+	var buf bytes.Buffer
+	opts := &LoggerOptions{
+		Level:           Trace,
+		DisableTime:     true,
+		IncludeLocation: true,
+		Color:           []ColorOption{ColorOff},
+		Output:          []io.Writer{&buf},
+	}
+	opts.RegisterContextField(testCtxKey("request"), "req_id")
+	opts.RegisterContextField(testCtxKey("peer"), "peer_id")
+	l := New(opts).With("k", "v").(ContextLogger)
+
+	ctx := context.WithValue(context.Background(), testCtxKey("request"), "r1")
+	ctx = context.WithValue(ctx, testCtxKey("other"), "ignored")
+	l.InfoCtx(ctx, "sending", "n", 1)
+	if out := buf.String(); !strings.Contains(out, "logger_test.go") || !strings.HasSuffix(out, "sending: k=v req_id=r1 n=1\n") {
+		t.Fatalf("Expected the context field and the caller location, got %q", out)
+	}
+
+	buf.Reset()
+	ctx = context.WithValue(ctx, testCtxKey("peer"), "p1")
+	l.TraceCtx(ctx, "t")
+	l.DebugCtx(ctx, "d")
+	l.WarnCtx(ctx, "w")
+	l.ErrorCtx(ctx, "e")
+	if n := strings.Count(buf.String(), "req_id=r1 peer_id=p1"); n != 4 {
+		t.Fatalf("Expected the context fields on each level, got %q", buf.String())
+	}
+
+	buf.Reset()
+	l.InfoCtx(context.Background(), "plain")
+	l.InfoCtx(nil, "no context")
+	if out := buf.String(); strings.Contains(out, "req_id") || strings.Count(out, "k=v") != 2 {
+		t.Fatalf("Expected no context fields, got %q", out)
+	}
+
+	// Looking up the absent fields does not allocate
+	bg := context.Background()
+	ll := l.(*newLogger)
+	args := []interface{}{"n", 1}
+	if allocs := testing.AllocsPerRun(100, func() { ll.ctxArgs(bg, args) }); allocs != 0 {
+		t.Fatalf("Expected no allocation without context fields, got %v", allocs)
+	}
+}
+
+// ttyBuffer is a buffer reporting itself as a terminal
+type ttyBuffer struct {
+	bytes.Buffer
+}
+
+func (b *ttyBuffer) IsTerminal() bool {
+	return true
+}
+
+func TestResetOutputAutoColor(t *testing.T) {
+	var tty ttyBuffer
+	colors := []ColorOption{AutoColor}
+	l := New(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+		Color:       colors,
+		Output:      []io.Writer{&tty},
+	})
+	l.Info("on the terminal")
+	if !strings.Contains(tty.String(), "\x1b[") {
+		t.Fatalf("Expected the terminal output colored, got %q", tty.String())
+	}
+
+	// Redirected to a buffer the color turns off
+	var buf bytes.Buffer
+	if err := l.(OutputResettable).ResetOutput(&LoggerOptions{Color: colors, Output: []io.Writer{&buf}}); err != nil {
+		t.Fatal("Failed to reset the output", err)
+	}
+	l.Info("redirected")
+	if buf.String() != "[INFO]  redirected\n" {
+		t.Fatalf("Expected the plain text after the redirect, got %q", buf.String())
+	}
+	if colors[0] != AutoColor {
+		t.Fatal("Expected the color options of the caller left as is")
+	}
+
+	// And back on when the output is a terminal again
+	tty.Reset()
+	if err := l.(OutputResettable).ResetOutput(&LoggerOptions{Color: colors, Output: []io.Writer{&tty}}); err != nil {
+		t.Fatal("Failed to reset the output", err)
+	}
+	l.Info("back")
+	if !strings.Contains(tty.String(), "\x1b[") {
+		t.Fatalf("Expected the color back on the terminal, got %q", tty.String())
+	}
+}
+
+func TestStandardLogger(t *testing.T) {
+	l, buf := newTestLogger(&LoggerOptions{
+		Name:        "http",
+		Level:       Debug,
+		DisableTime: true,
+	})
+	sl := l.(StandardLoggerProvider).StandardLogger(nil)
+	sl.Println("[ERROR] http: TLS handshake error")
+	sl.Printf("[warning]   slow client %s", "10.0.0.1")
+	sl.Println("[DEBUG] accepted")
+	sl.Println("no prefix")
+	sl.Println("[unknown] kept as is")
+	exp := "[ERROR] http: http: TLS handshake error\n" +
+		"[WARN]  http: slow client 10.0.0.1\n" +
+		"[DEBUG] http: accepted\n" +
+		"[INFO]  http: no prefix\n" +
+		"[INFO]  http: [unknown] kept as is\n"
+	if buf.String() != exp {
+		t.Fatalf("Expected %q, got %q", exp, buf.String())
+	}
+
+	buf.Reset()
+	w := l.(StandardLoggerProvider).StandardWriter(&StandardLoggerOptions{Level: Warn})
+	if n, err := w.Write([]byte("plain line\n")); err != nil || n != len("plain line\n") {
+		t.Fatalf("Unexpected write result %d, %v", n, err)
+	}
+	if buf.String() != "[WARN]  http: plain line\n" {
+		t.Fatalf("Expected the default level of the options, got %q", buf.String())
+	}
+}
+
+// gateWriter blocks the writes until the gate is closed
+type gateWriter struct {
+	gate chan struct{}
+	buf  bytes.Buffer
+}
+
+func (w *gateWriter) Write(p []byte) (int, error) {
+	<-w.gate
+	return w.buf.Write(p)
+}
+
+func TestAsyncWriter(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	aw := NewAsyncWriter(NewLeveledWriter(&stdout, map[Level]io.Writer{Error: &stderr}), nil)
+	l := New(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+		Color:       []ColorOption{ColorOff},
+		Output:      []io.Writer{aw},
+	})
+	for i := 0; i < 100; i++ {
+		l.Info("queued", "i", i)
+	}
+	l.Error("failed")
+	if err := aw.Close(); err != nil {
+		t.Fatalf("Unexpected close error %v", err)
+	}
+	if n := strings.Count(stdout.String(), "[INFO]  queued: i="); n != 100 {
+		t.Fatalf("Expected the 100 entries written on close, got %d", n)
+	}
+	if !strings.HasPrefix(stdout.String(), "[INFO]  queued: i=0\n[INFO]  queued: i=1\n") {
+		t.Fatalf("Expected the entries in order, got %q", stdout.String()[:40])
+	}
+	if stderr.String() != "[ERROR] failed\n" {
+		t.Fatalf("Expected the level passed on to the wrapped writer, got %q", stderr.String())
+	}
+	if !aw.IsClosed() {
+		t.Fatalf("Expected the writer closed")
+	}
+	if _, err := aw.Write([]byte("late\n")); err != ErrWriterClosed {
+		t.Fatalf("Expected ErrWriterClosed after close, got %v", err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatalf("Expected the second close to succeed, got %v", err)
+	}
+
+	// The drop policy does not wait for the stalled writer
+	gw := &gateWriter{gate: make(chan struct{})}
+	aw = NewAsyncWriter(gw, &AsyncWriterOptions{BufferSize: 2, Overflow: OverflowDrop})
+	for i := 0; i < 10; i++ {
+		aw.Write([]byte("entry\n"))
+	}
+	if aw.Dropped() < 7 {
+		t.Fatalf("Expected at least 7 entries dropped, got %d", aw.Dropped())
+	}
+	close(gw.gate)
+	if err := aw.Flush(); err != nil {
+		t.Fatalf("Unexpected flush error %v", err)
+	}
+	if n := uint64(strings.Count(gw.buf.String(), "entry\n")); n+aw.Dropped() != 10 {
+		t.Fatalf("Expected %d entries written, got %d", 10-aw.Dropped(), n)
+	}
+	aw.Close()
+
+	// The errors of the wrapped writer are reported
+	var reported []error
+	aw = NewAsyncWriter(&shortWriter{}, &AsyncWriterOptions{OnError: func(err error) {
+		reported = append(reported, err)
+	}})
+	aw.Write([]byte("entry\n"))
+	if err := aw.Close(); err == nil || len(reported) != 1 {
+		t.Fatalf("Expected the write error reported, got %v and %v", err, reported)
+	}
+
+	// A failing output with the queue full must neither wedge the blocked
+	// writes nor Close
+	fw := &failWriter{gate: make(chan struct{})}
+	aw = NewAsyncWriter(fw, &AsyncWriterOptions{BufferSize: 1})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			aw.Write([]byte("entry\n"))
+		}()
+	}
+	closed := make(chan error, 1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(fw.gate)
+		wg.Wait()
+		closed <- aw.Close()
+	}()
+	select {
+	case err := <-closed:
+		if err != errFailWriter {
+			t.Fatalf("Expected the write error on close, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The writer wedged on the failing output")
+	}
+}
+
+var errFailWriter = errors.New("output failed")
+
+// failWriter fails every write once the gate is closed
+type failWriter struct {
+	gate chan struct{}
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	<-w.gate
+	return 0, errFailWriter
+}
+
+func BenchmarkAsyncWriter(b *testing.B) {
+	bench := func(b *testing.B, w io.Writer) {
+		l := New(&LoggerOptions{
+			Level:  Info,
+			Color:  []ColorOption{ColorOff},
+			Output: []io.Writer{w},
+		})
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l.Info("bench", "i", i)
+		}
+	}
+	b.Run("direct", func(b *testing.B) {
+		bench(b, &slowWriter{delay: 10 * time.Microsecond})
+	})
+	b.Run("async", func(b *testing.B) {
+		aw := NewAsyncWriter(&slowWriter{delay: 10 * time.Microsecond}, &AsyncWriterOptions{Overflow: OverflowDrop})
+		defer aw.Close()
+		bench(b, aw)
+	})
+}
+
+func TestPlainLevelField(t *testing.T) {
+	var buf bytes.Buffer
+	opts := &LoggerOptions{
+		Name:        "node",
+		Level:       Info,
+		DisableTime: true,
+		Color:       []ColorOption{ColorOff},
+		Output:      []io.Writer{&buf},
+	}
+	New(opts).Warn("low balance", "did", "bafy")
+	if buf.String() != "[WARN]  node: low balance: did=bafy\n" {
+		t.Fatalf("Expected the bracket only by default, got %q", buf.String())
+	}
+
+	buf.Reset()
+	opts.PlainLevel = PlainLevelBracketAndField
+	l := New(opts)
+	l.Warn("low balance", "did", "bafy")
+	l.Info("started")
+	exp := "[WARN]  node: low balance: level=warn did=bafy\n" +
+		"[INFO]  node: started: level=info\n"
+	if buf.String() != exp {
+		t.Fatalf("Expected %q, got %q", exp, buf.String())
+	}
+	if l.(ConfigReporter).Config().PlainLevel != PlainLevelBracketAndField {
+		t.Fatalf("Expected the mode in the config, got %d", l.(ConfigReporter).Config().PlainLevel)
+	}
+
+	buf.Reset()
+	opts.PlainLevel = PlainLevelField
+	New(opts).Error("failed")
+	opts.NameBracketPrefix = true
+	New(opts).Error("failed")
+	exp = "node: failed: level=error\n" +
+		"[node] failed: level=error\n"
+	if buf.String() != exp {
+		t.Fatalf("Expected %q, got %q", exp, buf.String())
+	}
+}
+
+func TestRotatingFileWriter(t *testing.T) {
+	name := t.TempDir() + "/node.log"
+	rw, err := NewRotatingFileWriter(name, 100, 2)
+	if err != nil {
+		t.Fatal("Failed to open the rotating file", err)
+	}
+	l := New(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+		Color:       []ColorOption{ColorOff},
+		Output:      []io.Writer{rw},
+	})
+	// Each entry is 29 bytes, so 3 of them fit in a file
+	for i := 10; i < 20; i++ {
+		l.Info("rotating entry", "i", i)
+	}
+	read := func(name string) string {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal("Failed to read the log file", err)
+		}
+		return string(b)
+	}
+	exp := map[string]string{
+		name:        "[INFO]  rotating entry: i=19\n",
+		name + ".1": "[INFO]  rotating entry: i=16\n[INFO]  rotating entry: i=17\n[INFO]  rotating entry: i=18\n",
+		name + ".2": "[INFO]  rotating entry: i=13\n[INFO]  rotating entry: i=14\n[INFO]  rotating entry: i=15\n",
+	}
+	for f, e := range exp {
+		if got := read(f); got != e {
+			t.Fatalf("Expected %q in %s, got %q", e, f, got)
+		}
+	}
+	if _, err := os.Stat(name + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("Expected only 2 rolled files, got %v", err)
+	}
+	if err := rw.Close(); err != nil || !rw.IsClosed() {
+		t.Fatalf("Expected the writer closed, got %v", err)
+	}
+	if _, err := rw.Write([]byte("late\n")); err != ErrWriterClosed {
+		t.Fatalf("Expected ErrWriterClosed after close, got %v", err)
+	}
+
+	// Reopening appends to the current file and the concurrent writes are
+	// neither lost nor split
+	rw, err = NewRotatingFileWriter(name, 1000, 10)
+	if err != nil {
+		t.Fatal("Failed to reopen the rotating file", err)
+	}
+	defer rw.Close()
+	l = New(&LoggerOptions{
+		Level:       Info,
+		DisableTime: true,
+		Color:       []ColorOption{ColorOff},
+		Output:      []io.Writer{rw},
+	})
+	var wg sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 10; i < 60; i++ {
+				l.Info("rotating entry", "i", i)
+			}
+		}()
+	}
+	wg.Wait()
+	var lines int
+	for i := 0; i <= 10; i++ {
+		f := name
+		if i > 0 {
+			f = name + "." + strconv.Itoa(i)
+		}
+		b, err := os.ReadFile(f)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil || len(b) > 1000 {
+			t.Fatalf("Expected %s within the max size, got %d bytes and %v", f, len(b), err)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			if !strings.HasPrefix(line, "[INFO]  rotating entry: i=") || len(line) != 28 {
+				t.Fatalf("Unexpected line %q in %s", line, f)
+			}
+			lines++
+		}
+	}
+	// The 7 entries of the first run remaining plus 200 new ones
+	if lines != 207 {
+		t.Fatalf("Expected 207 entries across the files, got %d", lines)
+	}
+}
+
+func TestRotatingFileWriterRecovery(t *testing.T) {
+	name := t.TempDir() + "/node.log"
+	rw, err := NewRotatingFileWriter(name, 10, 2)
+	if err != nil {
+		t.Fatal("Failed to open the rotating file", err)
+	}
+	defer rw.Close()
+	var errs []error
+	rw.SetOnError(func(err error) { errs = append(errs, err) })
+	if _, err := rw.Write([]byte("entry 1\n")); err != nil {
+		t.Fatal("Failed to write", err)
+	}
+
+	// The current file stays at the path when the new file fails to open
+	openErr := errors.New("open failed")
+	rw.open = func(string) (*os.File, error) { return nil, openErr }
+	if _, err := rw.Write([]byte("entry 2\n")); err != openErr {
+		t.Fatalf("Expected the open error, got %v", err)
+	}
+	rw.open = OpenAppendFile
+	if _, err := os.Stat(name + ".1"); !os.IsNotExist(err) {
+		t.Fatalf("Expected the current file moved back, got %v", err)
+	}
+
+	// Failing to close the rolled file does not fail the write
+	rw.f.Close()
+	if _, err := rw.Write([]byte("entry 3\n")); err != nil {
+		t.Fatal("Failed to write after the rotation", err)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("Expected the close error reported, got %v", errs)
+	}
+	for f, e := range map[string]string{name: "entry 3\n", name + ".1": "entry 1\n"} {
+		if b, err := os.ReadFile(f); err != nil || string(b) != e {
+			t.Fatalf("Expected %q in %s, got %q and %v", e, f, b, err)
+		}
+	}
+}
@@ -2,10 +2,14 @@ package logger
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"os"
 	"reflect"
@@ -27,11 +31,28 @@ import (
 // contains millisecond precision
 const TimeFormat = "2006-01-02T15:04:05.000Z0700"
 
+// JSON timestamp formats for each of the TimePrecision
+var _precisionToJSONTimeFormat = map[TimePrecision]string{
+	SecondPrecision: "2006-01-02T15:04:05Z07:00",
+	MilliPrecision:  "2006-01-02T15:04:05.000Z07:00",
+	MicroPrecision:  "2006-01-02T15:04:05.000000Z07:00",
+	NanoPrecision:   "2006-01-02T15:04:05.000000000Z07:00",
+}
+
 // errJsonUnsupportedTypeMsg is included in log json entries, if an arg cannot be serialized to json
 const errJsonUnsupportedTypeMsg = "logging contained values that don't serialize to json"
 
 const MissingKey = "EXTRA_VALUE_AT_END"
 
+// CompactTimeFormat is the time only format used by the compact plain format
+const CompactTimeFormat = "15:04:05"
+
+// FatalExitCode is the exit status of the process after Fatal
+var FatalExitCode = 1
+
+// Exits the process after Fatal, replaced by the tests
+var osExit = os.Exit
+
 var (
 	_levelToBracket = map[Level]string{
 		Debug: "[DEBUG]",
@@ -39,27 +60,109 @@ var (
 		Info:  "[INFO] ",
 		Warn:  "[WARN] ",
 		Error: "[ERROR]",
+		Fatal: "[FATAL]",
+	}
+
+	_levelToCompact = map[Level]string{
+		Debug: "D",
+		Trace: "T",
+		Info:  "I",
+		Warn:  "W",
+		Error: "E",
+		Fatal: "F",
 	}
 
 	_levelToColor = map[Level]*color.Color{
-		Debug: color.New(color.FgHiWhite),
-		Trace: color.New(color.FgHiGreen),
-		Info:  color.New(color.FgHiBlue),
-		Warn:  color.New(color.FgHiYellow),
-		Error: color.New(color.FgHiRed),
+		Debug: levelColor(color.FgHiWhite),
+		Trace: levelColor(color.FgHiGreen),
+		Info:  levelColor(color.FgHiBlue),
+		Warn:  levelColor(color.FgHiYellow),
+		Error: levelColor(color.FgHiRed),
+		Fatal: levelColor(color.FgHiMagenta),
 	}
 )
 
+// The level colors are always enabled, each output is colored as per its
+// own ColorOption rather than the global color.NoColor which follows the
+// tty status of os.Stdout
+func levelColor(a color.Attribute) *color.Color {
+	c := color.New(a)
+	c.EnableColor()
+	return c
+}
+
+// Buffers reused by renderSlice
+var _sliceBufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
 // Make sure that newLogger is a Logger
 var _ Logger = &newLogger{}
+var _ LevelCounter = &newLogger{}
+var _ Shutdowner = &newLogger{}
+var _ EntryWriter = &newLogger{}
+var _ Merger = &newLogger{}
+var _ AutoFlusher = &newLogger{}
+var _ ConfigReporter = &newLogger{}
+var _ StructLogger = &newLogger{}
+var _ SampleBucketer = &newLogger{}
+var _ DeferredLogger = &newLogger{}
+var _ TxLogger = &newLogger{}
+var _ TapAttacher = &newLogger{}
+var _ CategoryLogger = &newLogger{}
+var _ ContextLogger = &newLogger{}
+var _ StandardLoggerProvider = &newLogger{}
+var _ DropCounter = &newLogger{}
+var _ FlushLatencyReporter = &newLogger{}
+
+// levelCounts holds a counter per level, indexed by the level
+type levelCounts [Fatal + 1]uint64
 
 // newLogger is an internal logger implementation. Internal in that it is
 // defined entirely by this package.
 type newLogger struct {
-	json       bool
-	caller     bool
-	name       string
-	timeFormat string
+	json           bool
+	compact        bool
+	duplicateKeys  DuplicateKeyPolicy
+	stackPrefix    string
+	numericLevel   bool
+	maxLineLen     int
+	clock          func() time.Time
+	start          time.Time
+	uptime         bool
+	host           string
+	nameBracket    bool
+	singleSpace    bool
+	plainLevel     PlainLevelMode
+	orderedJSON    bool
+	detail         io.Writer
+	moduleLeaf     bool
+	profile        JSONProfile
+	structDepth    int
+	stackMin       Level
+	fieldPrefix    string
+	panicStack     bool
+	binary         *BinaryWriter
+	onOverride     func(key string, old, new interface{})
+	summary        bool
+	noNewline      bool
+	fieldColors    *FieldColors
+	bucket         *byteBucket
+	latency        *latencyWindow
+	ctxFields      []ContextField
+	sampler        *bucketSampler
+	sampleBucket   string
+	txID           string
+	category       string
+	entryHash      bool
+	deferred       *deferredBuffer
+	caller         bool
+	name           string
+	timeFormat     string
+	jsonTimeFormat string
+	timeByLevel    map[Level]string
 
 	// This is an interface so that it's shared by any derived loggers, since
 	// those derived loggers share the bufio.Writer as well.
@@ -67,6 +170,10 @@ type newLogger struct {
 	writer *writer
 	level  *int32
 
+	// Shared with the derived loggers, nil unless CountLevels is set
+	counts        *levelCounts
+	writeMinLevel Level
+
 	implied []interface{}
 
 	exclude func(level Level, msg string, args ...interface{}) bool
@@ -94,17 +201,88 @@ func New(opts *LoggerOptions) Logger {
 		mutex = new(sync.Mutex)
 	}
 
+	colors := outputColors(output, opts.Color)
+
+	jsonTimeFormat, ok := _precisionToJSONTimeFormat[opts.JSONTimePrecision]
+	if !ok {
+		jsonTimeFormat = _precisionToJSONTimeFormat[MicroPrecision]
+	}
+
 	l := &newLogger{
-		json:       opts.JSONFormat,
-		caller:     opts.IncludeLocation,
-		name:       opts.Name,
-		timeFormat: TimeFormat,
-		writer:     newWriter(output, opts.Color),
-		mutex:      mutex,
-		level:      new(int32),
-		exclude:    opts.Exclude,
+		json:           opts.JSONFormat,
+		compact:        opts.CompactPlain,
+		duplicateKeys:  opts.DuplicateKeys,
+		stackPrefix:    opts.StacktracePrefix,
+		numericLevel:   opts.JSONNumericLevel,
+		maxLineLen:     opts.MaxLineLen,
+		clock:          opts.Clock,
+		uptime:         opts.IncludeUptime,
+		onOverride:     opts.OnFieldOverride,
+		summary:        opts.SummaryOnShutdown,
+		noNewline:      opts.DisableNewline,
+		fieldColors:    opts.FieldColors,
+		nameBracket:    opts.NameBracketPrefix,
+		singleSpace:    opts.SingleSpace,
+		plainLevel:     opts.PlainLevel,
+		orderedJSON:    opts.OrderedJSON,
+		detail:         opts.JSONDetailOutput,
+		moduleLeaf:     opts.JSONModuleLeaf,
+		profile:        opts.JSONProfile,
+		structDepth:    opts.StructMaxDepth,
+		entryHash:      opts.JSONEntryHash,
+		stackMin:       opts.StacktraceMinLevel,
+		fieldPrefix:    opts.JSONFieldPrefix,
+		panicStack:     opts.PanicStacktrace,
+		binary:         opts.BinaryOutput,
+		caller:         opts.IncludeLocation,
+		name:           opts.Name,
+		timeFormat:     TimeFormat,
+		jsonTimeFormat: jsonTimeFormat,
+		timeByLevel:    copyTimeFormats(opts.TimeFormatByLevel),
+		writer:         newWriter(output, colors),
+		mutex:          mutex,
+		level:          new(int32),
+		writeMinLevel:  opts.WriteMinLevel,
+		exclude:        opts.Exclude,
+	}
+
+	if opts.CountLevels || opts.SummaryOnShutdown {
+		l.counts = new(levelCounts)
+	}
+
+	if l.clock == nil {
+		l.clock = time.Now
+	}
+	l.start = l.clock()
+
+	if opts.IncludeHostname {
+		l.host = opts.Hostname
+		if l.host == "" {
+			l.host = osHostname()
+		}
 	}
 
+	if opts.MaxBytesPerSecond > 0 {
+		l.bucket = newByteBucket(opts.MaxBytesPerSecond, l.start)
+	}
+	if opts.FlushLatencySamples > 0 {
+		l.latency = newLatencyWindow(opts.FlushLatencySamples)
+	}
+	if len(opts.ContextFields) > 0 {
+		l.ctxFields = append([]ContextField(nil), opts.ContextFields...)
+	}
+	if opts.DeferUntilLevel {
+		l.deferred = newDeferredBuffer(opts.DeferBufferSize)
+	}
+	if len(opts.SampleRates) > 0 || opts.DefaultSampleRate > 1 {
+		l.sampler = newBucketSampler(opts.SampleRates, opts.DefaultSampleRate)
+	}
+
+	l.writer.onError = opts.OnError
+	l.writer.ring = opts.RingBuffer
+	l.writer.atomic = opts.AtomicWrites
+	l.setSyslog(opts)
+
 	l.setColorization(opts)
 
 	if opts.DisableTime {
@@ -118,25 +296,134 @@ func New(opts *LoggerOptions) Logger {
 	return l
 }
 
+// NewNullLogger returns a logger discarding all the entries, it is meant as
+// a safe default where no logger has been provided
+func NewNullLogger() Logger {
+	return New(&LoggerOptions{
+		Level:  Off,
+		Output: []io.Writer{io.Discard},
+		Color:  []ColorOption{ColorOff},
+	})
+}
+
 // Log a message and a set of key/value pairs if the given level is at
 // or more severe that the threshold configured in the Logger.
 func (l *newLogger) log(name string, level Level, msg string, args ...interface{}) {
-	if level < Level(atomic.LoadInt32(l.level)) {
+	if Level(atomic.LoadInt32(l.level)) == Off {
+		return
+	}
+
+	if l.deferred != nil && l.deferred.hold(l, name, level, msg, args) {
 		return
 	}
 
-	t := time.Now()
+	if !l.admit(level) {
+		if l.writer.ring != nil {
+			l.write(l.clock(), name, level, true, msg, args...)
+		}
+		return
+	}
+
+	if l.exclude != nil && l.exclude(level, msg, args...) {
+		return
+	}
+
+	if l.sampler != nil && !l.sampler.keep(l.sampleBucket) {
+		return
+	}
+
+	if l.stackMin != NoLevel && level >= l.stackMin {
+		args = withStacktrace(args)
+	}
+
+	l.write(l.clock(), name, level, false, msg, args...)
+}
+
+// Check the level against the threshold, count the admitted entry and
+// report whether it has to be written
+func (l *newLogger) admit(level Level) bool {
+	if current := Level(atomic.LoadInt32(l.level)); level < current || current == Off {
+		return false
+	}
+	if l.counts != nil && level >= 0 && int(level) < len(l.counts) {
+		atomic.AddUint64(&l.counts[level], 1)
+	}
+	return level >= l.writeMinLevel
+}
+
+// Render and flush the entry through the configured formatter, the entry
+// is kept only in the ring buffer if ringOnly is set
+func (l *newLogger) write(t time.Time, name string, level Level, ringOnly bool, msg string, args ...interface{}) {
+	if l.uptime {
+		args = append([]interface{}{"@uptime", t.Sub(l.start).String()}, args...)
+	}
+	if l.host != "" {
+		key := "host"
+		if l.json {
+			key = "@hostname"
+		}
+		args = append([]interface{}{key, l.host}, args...)
+	}
 
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
+	if l.binary != nil && !ringOnly {
+		l.writeBinary(t, name, level, msg, args)
+		// Skip rendering the text when the binary output is the only one
+		if len(l.writer.w) == 0 && l.writer.ring == nil && len(l.writer.taps) == 0 {
+			return
+		}
+	}
+
 	if l.json {
 		l.logJSON(t, name, level, msg, args...)
 	} else {
-		l.logPlain(t, name, level, msg, args...)
+		if l.compact {
+			l.logCompact(t, name, level, msg, args...)
+		} else {
+			l.logPlain(t, name, level, msg, args...)
+		}
+		if l.maxLineLen > 0 {
+			l.writer.truncateLines(l.maxLineLen)
+		}
+	}
+
+	if l.noNewline {
+		l.writer.trimNewline()
+	}
+
+	if ringOnly {
+		l.writer.flushRing()
+		return
 	}
 
-	l.writer.Flush(level)
+	if l.bucket != nil && !l.bucket.take(t, l.writer.b.Len()) {
+		// the ring buffer is in memory, keep the dropped entry there
+		if l.writer.ring != nil {
+			l.writer.flushRing()
+		} else {
+			l.writer.b.Reset()
+		}
+		return
+	}
+
+	l.writer.t = t
+	l.writer.category = l.category
+
+	l.flush(level)
+}
+
+// Write the entry to the binary output, the stacktrace is kept as a field
+func (l *newLogger) writeBinary(t time.Time, name string, level Level, msg string, args []interface{}) {
+	args, stacktrace := l.entryArgs(args)
+	if stacktrace != "" {
+		args = append(args, "stacktrace", string(stacktrace))
+	}
+	err := l.binary.WriteEntry(Entry{Time: t, Level: level, Name: name, Message: msg, Args: args})
+	if err != nil && l.writer.onError != nil {
+		l.writer.onError(err)
+	}
 }
 
 // Cleanup a path by returning the last 2 segments of the path only.
@@ -170,33 +457,74 @@ func trimCallerPath(path string) string {
 
 var logImplFile = regexp.MustCompile(`.+newLogger.go|.+interceptlogger.go$`)
 
+// The time format of the entries at the level, def if not overridden
+func (l *newLogger) levelTimeFormat(level Level, def string) string {
+	if f, ok := l.timeByLevel[level]; ok {
+		return f
+	}
+	return def
+}
+
+func copyTimeFormats(m map[Level]string) map[Level]string {
+	if len(m) == 0 {
+		return nil
+	}
+	c := make(map[Level]string, len(m))
+	for level, f := range m {
+		c[level] = f
+	}
+	return c
+}
+
 // Non-JSON logging format function
 func (l *newLogger) logPlain(t time.Time, name string, level Level, msg string, args ...interface{}) {
 	if len(l.timeFormat) > 0 {
-		l.writer.WriteString(t.Format(l.timeFormat))
+		l.writer.WriteString(t.Format(l.levelTimeFormat(level, l.timeFormat)))
 		l.writer.WriteByte(' ')
 	}
 
-	s, ok := _levelToBracket[level]
-	if ok {
-		l.writer.WriteString(s)
-	} else {
-		l.writer.WriteString("[?????]")
+	// Without the bracket the separator before the next part is dropped, so
+	// that the line neither starts with nor doubles a space
+	bracket := l.plainLevel != PlainLevelField
+	if bracket {
+		s, ok := _levelToBracket[level]
+		if ok {
+			if l.singleSpace {
+				s = strings.TrimRight(s, " ")
+			}
+			l.writer.WriteString(s)
+		} else {
+			l.writer.WriteString("[?????]")
+		}
+	}
+	started := bracket
+	sep := func() {
+		if started {
+			l.writer.WriteByte(' ')
+		}
+		started = true
 	}
 
-	offset := 3
+	if l.nameBracket && name != "" {
+		sep()
+		l.writer.WriteByte('[')
+		l.writer.WriteString(name)
+		l.writer.WriteByte(']')
+	}
+
+	offset := 4
 	if l.caller {
 		// Check if the caller is inside our package and inside
 		// a logger implementation file
-		if _, file, _, ok := runtime.Caller(3); ok {
+		if _, file, _, ok := runtime.Caller(4); ok {
 			match := logImplFile.MatchString(file)
 			if match {
-				offset = 4
+				offset = 5
 			}
 		}
 
 		if _, file, line, ok := runtime.Caller(offset); ok {
-			l.writer.WriteByte(' ')
+			sep()
 			l.writer.WriteString(trimCallerPath(file))
 			l.writer.WriteByte(':')
 			l.writer.WriteString(strconv.Itoa(line))
@@ -204,112 +532,251 @@ func (l *newLogger) logPlain(t time.Time, name string, level Level, msg string,
 		}
 	}
 
-	l.writer.WriteByte(' ')
+	sep()
 
-	if name != "" {
+	if name != "" && !l.nameBracket {
 		l.writer.WriteString(name)
 		l.writer.WriteString(": ")
 	}
 
 	l.writer.WriteString(msg)
 
-	args = append(l.implied, args...)
-
-	var stacktrace CapturedStacktrace
+	args, stacktrace := l.entryArgs(args)
+	if l.plainLevel != PlainLevelBracket {
+		args = append([]interface{}{PlainLevelKey, jsonLevel(level)}, args...)
+	}
 
-	if args != nil && len(args) > 0 {
-		if len(args)%2 != 0 {
-			cs, ok := args[len(args)-1].(CapturedStacktrace)
-			if ok {
-				args = args[:len(args)-1]
-				stacktrace = cs
-			} else {
-				extra := args[len(args)-1]
-				args = append(args[:len(args)-1], MissingKey, extra)
-			}
-		}
+	if len(args) > 0 {
 
 		l.writer.WriteByte(':')
 
-	FOR:
 		for i := 0; i < len(args); i = i + 2 {
-			var (
-				val string
-				raw bool
-			)
-
-			switch st := args[i+1].(type) {
-			case string:
-				val = st
-			case int:
-				val = strconv.FormatInt(int64(st), 10)
-			case int64:
-				val = strconv.FormatInt(int64(st), 10)
-			case int32:
-				val = strconv.FormatInt(int64(st), 10)
-			case int16:
-				val = strconv.FormatInt(int64(st), 10)
-			case int8:
-				val = strconv.FormatInt(int64(st), 10)
-			case uint:
-				val = strconv.FormatUint(uint64(st), 10)
-			case uint64:
-				val = strconv.FormatUint(uint64(st), 10)
-			case uint32:
-				val = strconv.FormatUint(uint64(st), 10)
-			case uint16:
-				val = strconv.FormatUint(uint64(st), 10)
-			case uint8:
-				val = strconv.FormatUint(uint64(st), 10)
-			case Hex:
-				val = "0x" + strconv.FormatUint(uint64(st), 16)
-			case Octal:
-				val = "0" + strconv.FormatUint(uint64(st), 8)
-			case Binary:
-				val = "0b" + strconv.FormatUint(uint64(st), 2)
-			case CapturedStacktrace:
+			if st, ok := args[i+1].(CapturedStacktrace); ok {
 				stacktrace = st
-				continue FOR
-			case Format:
-				val = fmt.Sprintf(st[0].(string), st[1:]...)
-			default:
-				v := reflect.ValueOf(st)
-				if v.Kind() == reflect.Slice {
-					val = l.renderSlice(v)
-					raw = true
-				} else {
-					val = fmt.Sprintf("%v", st)
-				}
+				continue
 			}
 
 			l.writer.WriteByte(' ')
-			switch st := args[i].(type) {
-			case string:
-				l.writer.WriteString(st)
-			default:
-				l.writer.WriteString(fmt.Sprintf("%s", st))
-			}
-			l.writer.WriteByte('=')
-
-			if !raw && strings.ContainsAny(val, " \t\n\r") {
-				l.writer.WriteByte('"')
-				l.writer.WriteString(val)
-				l.writer.WriteByte('"')
-			} else {
-				l.writer.WriteString(val)
-			}
+			l.writePlainField(args[i], args[i+1])
 		}
 	}
 
 	l.writer.WriteString("\n")
 
 	if stacktrace != "" {
-		l.writer.WriteString(string(stacktrace))
+		l.writeStacktrace(stacktrace)
+	}
+}
+
+// Write the stacktrace after the log line, each line prefixed with the
+// configured stacktrace prefix if any
+func (l *newLogger) writeStacktrace(st CapturedStacktrace) {
+	if l.stackPrefix == "" {
+		l.writer.WriteString(string(st))
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(st), "\n"), "\n") {
+		l.writer.WriteString(l.stackPrefix)
+		l.writer.WriteString(line)
+		l.writer.WriteByte('\n')
+	}
+}
+
+// Combine the implied args with the args of the call, pairing a dangling
+// value with MissingKey and applying the duplicate key policy. A trailing
+// CapturedStacktrace is returned separately.
+func (l *newLogger) entryArgs(args []interface{}) ([]interface{}, CapturedStacktrace) {
+	var stacktrace CapturedStacktrace
+
+	// Cap the implied args so that the append never writes into an array
+	// shared with the parent or the other users of the logger
+	args = append(l.implied[:len(l.implied):len(l.implied)], args...)
+	if l.txID != "" {
+		args = append([]interface{}{TxIDKey, l.txID}, args...)
+	}
+	if l.category != "" {
+		args = append([]interface{}{CategoryKey, l.category}, args...)
+	}
+
+	if len(args)%2 != 0 {
+		cs, ok := args[len(args)-1].(CapturedStacktrace)
+		if ok {
+			args = args[:len(args)-1]
+			stacktrace = cs
+		} else {
+			extra := args[len(args)-1]
+			args = append(args[:len(args)-1], MissingKey, extra)
+		}
+	}
+
+	if l.duplicateKeys != DuplicateKeysAsIs {
+		args = dedupArgs(args, l.duplicateKeys)
+	}
+
+	return args, stacktrace
+}
+
+// Resolve the repeated keys in the key/value pairs as per the policy
+func dedupArgs(args []interface{}, policy DuplicateKeyPolicy) []interface{} {
+	index := make(map[string]int, len(args)/2)
+	count := make(map[string]int, len(args)/2)
+	result := make([]interface{}, 0, len(args))
+	for i := 0; i < len(args); i = i + 2 {
+		key := fmt.Sprintf("%s", args[i])
+		n := count[key] + 1
+		count[key] = n
+		if n == 1 {
+			index[key] = len(result)
+			result = append(result, args[i], args[i+1])
+			continue
+		}
+		switch policy {
+		case DuplicateKeysKeepFirst:
+		case DuplicateKeysKeepLast:
+			result[index[key]+1] = args[i+1]
+		case DuplicateKeysRenameSuffix:
+			result = append(result, key+"_"+strconv.Itoa(n), args[i+1])
+		}
+	}
+	return result
+}
+
+// Render the value for the plain formats, raw values must not be quoted
+func (l *newLogger) plainValue(v interface{}) (string, bool) {
+	switch st := v.(type) {
+	case string:
+		return st, false
+	case int:
+		return strconv.FormatInt(int64(st), 10), false
+	case int64:
+		return strconv.FormatInt(int64(st), 10), false
+	case int32:
+		return strconv.FormatInt(int64(st), 10), false
+	case int16:
+		return strconv.FormatInt(int64(st), 10), false
+	case int8:
+		return strconv.FormatInt(int64(st), 10), false
+	case uint:
+		return strconv.FormatUint(uint64(st), 10), false
+	case uint64:
+		return strconv.FormatUint(uint64(st), 10), false
+	case uint32:
+		return strconv.FormatUint(uint64(st), 10), false
+	case uint16:
+		return strconv.FormatUint(uint64(st), 10), false
+	case uint8:
+		return strconv.FormatUint(uint64(st), 10), false
+	case Hex:
+		return "0x" + strconv.FormatUint(uint64(st), 16), false
+	case Octal:
+		return "0" + strconv.FormatUint(uint64(st), 8), false
+	case Binary:
+		return "0b" + strconv.FormatUint(uint64(st), 2), false
+	case Format:
+		return fmt.Sprintf(st[0].(string), st[1:]...), false
+	case TypedValue:
+		return l.plainValue(st.V)
+	default:
+		if s, ok := formatValue(st); ok {
+			return s, false
+		}
+		v := reflect.ValueOf(st)
+		if v.Kind() == reflect.Slice {
+			return l.renderSlice(v), true
+		}
+		return fmt.Sprintf("%v", st), false
+	}
+}
+
+// Write the key=value pair for the plain formats
+func (l *newLogger) writePlainField(key interface{}, value interface{}) {
+	val, raw := l.plainValue(value)
+
+	var k string
+	switch st := key.(type) {
+	case string:
+		k = st
+	default:
+		k = fmt.Sprintf("%s", st)
+	}
+
+	var keyCode, valCode string
+	if l.fieldColors != nil && l.writer.colored() {
+		keyCode = l.fieldColors.Key
+		if c, ok := l.fieldColors.Values[k]; ok {
+			valCode = c
+		} else if _, ok := value.(error); ok {
+			valCode = l.fieldColors.ErrorValue
+		}
+	}
+
+	l.writeColored(keyCode, k)
+	l.writer.WriteByte('=')
+
+	if !raw && strings.ContainsAny(val, " \t\n\r") {
+		val = "\"" + val + "\""
+	}
+	l.writeColored(valCode, val)
+}
+
+// Write the text wrapped in the ANSI SGR code, the code is stripped by the
+// writer for the outputs without coloring
+func (l *newLogger) writeColored(code string, s string) {
+	if code == "" {
+		l.writer.WriteString(s)
+		return
+	}
+	l.writer.WriteString("\x1b[")
+	l.writer.WriteString(code)
+	l.writer.WriteByte('m')
+	l.writer.WriteString(s)
+	l.writer.WriteString(ansiReset)
+}
+
+// Compact plain logging format function, renders L|15:04:05|msg|k=v
+func (l *newLogger) logCompact(t time.Time, name string, level Level, msg string, args ...interface{}) {
+	s, ok := _levelToCompact[level]
+	if ok {
+		l.writer.WriteString(s)
+	} else {
+		l.writer.WriteByte('?')
+	}
+
+	if len(l.timeFormat) > 0 {
+		l.writer.WriteByte('|')
+		l.writer.WriteString(t.Format(CompactTimeFormat))
+	}
+
+	l.writer.WriteByte('|')
+	if name != "" {
+		l.writer.WriteString(name)
+		l.writer.WriteString(": ")
+	}
+	l.writer.WriteString(msg)
+
+	args, _ = l.entryArgs(args)
+
+	first := true
+	for i := 0; i < len(args); i = i + 2 {
+		if _, ok := args[i+1].(CapturedStacktrace); ok {
+			continue
+		}
+		if first {
+			l.writer.WriteByte('|')
+			first = false
+		} else {
+			l.writer.WriteByte(' ')
+		}
+		l.writePlainField(args[i], args[i+1])
 	}
+
+	l.writer.WriteString("\n")
 }
 
 func (l *newLogger) renderSlice(v reflect.Value) string {
-	var buf bytes.Buffer
+	buf := _sliceBufPool.Get().(*bytes.Buffer)
+	defer _sliceBufPool.Put(buf)
+	buf.Reset()
 
 	buf.WriteRune('[')
 
@@ -344,28 +811,38 @@ func (l *newLogger) renderSlice(v reflect.Value) string {
 
 	buf.WriteRune(']')
 
+	// String copies the bytes, so the buffer can be reused after return
 	return buf.String()
 }
 
 // JSON logging function
 func (l *newLogger) logJSON(t time.Time, name string, level Level, msg string, args ...interface{}) {
-	vals := l.jsonMapEntry(t, name, level, msg)
-	args = append(l.implied, args...)
-
-	if args != nil && len(args) > 0 {
-		if len(args)%2 != 0 {
-			cs, ok := args[len(args)-1].(CapturedStacktrace)
-			if ok {
-				args = args[:len(args)-1]
-				vals["stacktrace"] = cs
-			} else {
-				extra := args[len(args)-1]
-				args = append(args[:len(args)-1], MissingKey, extra)
-			}
+	vals := l.jsonEntry(t, name, level, msg)
+	args, stacktrace := l.entryArgs(args)
+	if stacktrace != "" {
+		if l.profile == JSONProfileECS {
+			vals.set("error.message", msg)
+			vals.set("error.stack_trace", string(stacktrace))
+		} else {
+			vals.set("stacktrace", stacktrace)
 		}
+	}
+
+	if len(args) > 0 {
 
 		for i := 0; i < len(args); i = i + 2 {
 			val := args[i+1]
+			typ := ""
+			if tv, ok := val.(TypedValue); ok {
+				val = tv.V
+				typ = "nil"
+				if val != nil {
+					typ = reflect.TypeOf(val).String()
+				}
+			}
+			if fv, ok := formatJSONValue(val); ok {
+				val = fv
+			}
 			switch sv := val.(type) {
 			case error:
 				// Check if val is of type error. If error type doesn't
@@ -388,62 +865,187 @@ func (l *newLogger) logJSON(t time.Time, name string, level Level, msg string, a
 			default:
 				key = fmt.Sprintf("%s", st)
 			}
-			vals[key] = val
+			if l.fieldPrefix != "" && !strings.HasPrefix(key, "@") {
+				key = l.fieldPrefix + key
+			}
+			vals.add(key, val)
+			if typ != "" {
+				vals.add(key+"@type", typ)
+			}
+		}
+		vals.dedupe()
+	}
+
+	if l.entryHash {
+		if h, err := entryHash(vals); err == nil {
+			vals.set("@hash", h)
 		}
 	}
 
-	err := json.NewEncoder(l.writer).Encode(vals)
+	if l.detail != nil {
+		vals = l.writeDetail(vals)
+	}
+
+	err := l.encodeJSON(l.writer, vals)
+	if err == nil && l.maxLineLen > 0 && l.writer.b.Len()-1 > l.maxLineLen {
+		// Cutting the line would break the JSON, flag it instead
+		l.writer.b.Reset()
+		vals.set("@truncated", true)
+		err = l.encodeJSON(l.writer, vals)
+	}
 	if err != nil {
-		if _, ok := err.(*json.UnsupportedTypeError); ok {
-			plainVal := l.jsonMapEntry(t, name, level, msg)
-			plainVal["@warn"] = errJsonUnsupportedTypeMsg
+		var ute *json.UnsupportedTypeError
+		if errors.As(err, &ute) {
+			plainVal := l.jsonEntry(t, name, level, msg)
+			plainVal.set("@warn", errJsonUnsupportedTypeMsg)
 
-			json.NewEncoder(l.writer).Encode(plainVal)
+			l.encodeJSON(l.writer, plainVal)
 		}
 	}
 }
 
-func (l newLogger) jsonMapEntry(t time.Time, name string, level Level, msg string) map[string]interface{} {
-	vals := map[string]interface{}{
-		"@message":   msg,
-		"@timestamp": t.Format("2006-01-02T15:04:05.000000Z07:00"),
+// The FNV-1a hash of the entry fields sorted by key, the fields varying with
+// the time of the entry are left out so the identical entries share it
+func entryHash(vals jsonFields) (string, error) {
+	m := vals.toMap()
+	delete(m, "@timestamp")
+	delete(m, "@uptime")
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
 	}
+	h := fnv.New64a()
+	h.Write(b)
+	return strconv.FormatUint(h.Sum64(), 16), nil
+}
 
-	var levelStr string
-	switch level {
-	case Error:
-		levelStr = "error"
-	case Warn:
-		levelStr = "warn"
-	case Info:
-		levelStr = "info"
-	case Debug:
-		levelStr = "debug"
-	case Trace:
-		levelStr = "trace"
-	default:
-		levelStr = "all"
+// Encode the fields in their order with OrderedJSON, else as a map with the
+// keys sorted
+func (l *newLogger) encodeJSON(w io.Writer, vals jsonFields) error {
+	if l.orderedJSON {
+		return json.NewEncoder(w).Encode(vals)
 	}
+	return json.NewEncoder(w).Encode(vals.toMap())
+}
 
-	vals["@level"] = levelStr
+// Write the entry with all its fields to the JSONDetailOutput and return the
+// @ keys of the entry with the @detail reference to it. The entries without
+// application fields are returned whole.
+func (l *newLogger) writeDetail(vals jsonFields) jsonFields {
+	summary := make(jsonFields, 0, len(vals)+1)
+	for _, kv := range vals {
+		if strings.HasPrefix(kv.key, "@") {
+			summary = append(summary, kv)
+		}
+	}
+	if len(summary) == len(vals) {
+		return vals
+	}
+	var buf bytes.Buffer
+	if err := l.encodeJSON(&buf, vals); err != nil {
+		// Left to the fallback of the primary entry
+		return vals
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	id := hex.EncodeToString(sum[:8])
+	buf.Reset()
+	vals.set("@detail", id)
+	l.encodeJSON(&buf, vals)
+	if err := writeFull(l.detail, buf.Bytes()); err != nil && l.writer.onError != nil {
+		l.writer.onError(err)
+	}
+	summary.set("@detail", id)
+	return summary
+}
+
+// The reserved fields of the JSON entry, in their output order
+func (l newLogger) jsonEntry(t time.Time, name string, level Level, msg string) jsonFields {
+	if l.profile == JSONProfileECS {
+		return l.ecsEntry(t, name, level, msg)
+	}
+	vals := make(jsonFields, 0, 8)
+	vals.set("@timestamp", t.Format(l.levelTimeFormat(level, l.jsonTimeFormat)))
+
+	vals.set("@level", jsonLevel(level))
+	if l.numericLevel {
+		vals.set("@level_num", int(level))
+	}
 
 	if name != "" {
-		vals["@module"] = name
+		if l.moduleLeaf {
+			vals.set("@module", name[strings.LastIndexByte(name, '.')+1:])
+			vals.set("@module_path", name)
+		} else {
+			vals.set("@module", name)
+		}
 	}
 
 	if l.caller {
-		if _, file, line, ok := runtime.Caller(4); ok {
-			vals["@caller"] = fmt.Sprintf("%s:%d", file, line)
+		if _, file, line, ok := runtime.Caller(5); ok {
+			vals.set("@caller", fmt.Sprintf("%s:%d", file, line))
+		}
+	}
+	vals.set("@message", msg)
+	return vals
+}
+
+// The reserved fields of the JSON entry in the ECS profile
+func (l newLogger) ecsEntry(t time.Time, name string, level Level, msg string) jsonFields {
+	vals := make(jsonFields, 0, 8)
+	vals.set("@timestamp", t.Format(l.levelTimeFormat(level, l.jsonTimeFormat)))
+	vals.set("log.level", jsonLevel(level))
+	if l.numericLevel {
+		vals.set("@level_num", int(level))
+	}
+	if name != "" {
+		vals.set("log.logger", name)
+	}
+	if l.caller {
+		// One frame deeper, called through jsonEntry
+		if _, file, line, ok := runtime.Caller(6); ok {
+			vals.set("log.origin.file.name", file)
+			vals.set("log.origin.file.line", line)
 		}
 	}
+	vals.set("message", msg)
+	vals.set("ecs.version", ECSVersion)
 	return vals
 }
 
+// The level name of the JSON entry
+func jsonLevel(level Level) string {
+	switch level {
+	case Fatal:
+		return "fatal"
+	case Error:
+		return "error"
+	case Warn:
+		return "warn"
+	case Info:
+		return "info"
+	case Debug:
+		return "debug"
+	case Trace:
+		return "trace"
+	default:
+		return "all"
+	}
+}
+
 // Emit the message and args at the provided level
 func (l *newLogger) Log(level Level, msg string, args ...interface{}) {
 	l.log(l.Name(), level, msg, args...)
 }
 
+// Emit a pre-built entry with its own time, level and name. The entry is
+// still subject to the level threshold of the logger.
+func (l *newLogger) WriteEntry(e Entry) {
+	if !l.admit(e.Level) {
+		return
+	}
+	l.write(e.Time, e.Name, e.Level, false, e.Message, e.Args...)
+}
+
 // Emit the message and args at DEBUG level
 func (l *newLogger) Debug(msg string, args ...interface{}) {
 	l.log(l.Name(), Debug, msg, args...)
@@ -471,18 +1073,51 @@ func (l *newLogger) Error(msg string, args ...interface{}) {
 
 // Emit a message and key/value pairs at the ERROR level & panic
 func (l *newLogger) Panic(msg string, args ...interface{}) {
-	l.log(l.Name(), Error, msg, args...)
+	l.log(l.Name(), Error, msg, l.panicArgs(args)...)
 	panic(msg)
 }
 
 // Emit the message and args & panic
 func (l *newLogger) ErrorPanic(err error, args ...interface{}) {
 	if err != nil {
-		l.log(l.Name(), Error, err.Error(), args...)
+		l.log(l.Name(), Error, err.Error(), l.panicArgs(args)...)
 		panic(err)
 	}
 }
 
+// Emit the message and args at FATAL level and exit, the entries held by
+// DeferUntilLevel are released first so that they are not lost
+func (l *newLogger) Fatal(msg string, args ...interface{}) {
+	l.ReleaseBuffer()
+	l.log(l.Name(), Fatal, msg, args...)
+	l.flushOutputs()
+	osExit(FatalExitCode)
+}
+
+// Attach the stacktrace of the panicking goroutine to the args if enabled
+func (l *newLogger) panicArgs(args []interface{}) []interface{} {
+	if !l.panicStack {
+		return args
+	}
+	return withStacktrace(args)
+}
+
+// Append the stacktrace of the caller to the args unless they already end
+// with one
+func withStacktrace(args []interface{}) []interface{} {
+	if n := len(args); n > 0 {
+		if _, ok := args[n-1].(CapturedStacktrace); ok {
+			return args
+		}
+	}
+	sa := make([]interface{}, 0, len(args)+3)
+	sa = append(sa, args...)
+	if len(sa)%2 != 0 {
+		sa = append(sa[:len(sa)-1], MissingKey, sa[len(sa)-1])
+	}
+	return append(sa, Stacktrace())
+}
+
 // Indicate that the logger would emit TRACE level logs
 func (l *newLogger) IsTrace() bool {
 	return Level(atomic.LoadInt32(l.level)) == Trace
@@ -508,10 +1143,19 @@ func (l *newLogger) IsError() bool {
 	return Level(atomic.LoadInt32(l.level)) <= Error
 }
 
+// Indicate that the logger would emit logs of the given level
+func (l *newLogger) IsLevel(level Level) bool {
+	return level >= Level(atomic.LoadInt32(l.level))
+}
+
 // Return a sub-Logger for which every emitted log message will contain
 // the given key/value pairs. This is used to create a context specific
 // Logger.
 func (l *newLogger) With(args ...interface{}) Logger {
+	if len(args) == 0 {
+		return l
+	}
+
 	var extra interface{}
 
 	if len(args)%2 != 0 {
@@ -533,9 +1177,11 @@ func (l *newLogger) With(args ...interface{}) Logger {
 	// Read new args, store map and key for consistent sorting
 	for i := 0; i < len(args); i += 2 {
 		key := args[i].(string)
-		_, exists := result[key]
+		old, exists := result[key]
 		if !exists {
 			keys = append(keys, key)
+		} else if l.onOverride != nil {
+			l.onOverride(key, old, args[i+1])
 		}
 		result[key] = args[i+1]
 	}
@@ -543,7 +1189,13 @@ func (l *newLogger) With(args ...interface{}) Logger {
 	// Sort keys to be consistent
 	sort.Strings(keys)
 
-	sl.implied = make([]interface{}, 0, len(l.implied)+len(args))
+	// Allocate the exact size, the spare capacity would be shared by the
+	// copies of the sub-logger
+	n := 2 * len(keys)
+	if extra != nil {
+		n += 2
+	}
+	sl.implied = make([]interface{}, 0, n)
 	for _, k := range keys {
 		sl.implied = append(sl.implied, k)
 		sl.implied = append(sl.implied, result[k])
@@ -556,6 +1208,143 @@ func (l *newLogger) With(args ...interface{}) Logger {
 	return &sl
 }
 
+// Return a sub-Logger with the implied args of the other logger added, the
+// values of the receiver are kept for the keys present in both. The tx_id of
+// the other logger is taken only if the receiver has none, so that it is
+// written once.
+func (l *newLogger) Merge(other Logger) Logger {
+	if other == nil {
+		return l
+	}
+	own := make(map[string]bool, len(l.implied)/2+1)
+	for i := 0; i+1 < len(l.implied); i += 2 {
+		if k, ok := l.implied[i].(string); ok {
+			own[k] = true
+		}
+	}
+	txID := l.txID
+	oa := other.ImpliedArgs()
+	args := make([]interface{}, 0, len(oa))
+	for i := 0; i+1 < len(oa); i += 2 {
+		k, ok := oa[i].(string)
+		if !ok || own[k] {
+			continue
+		}
+		if k == TxIDKey {
+			if txID == "" {
+				txID = fmt.Sprint(oa[i+1])
+			}
+			continue
+		}
+		own[k] = true
+		args = append(args, k, oa[i+1])
+	}
+	if txID == l.txID && len(args) == 0 {
+		return l
+	}
+	ml := *l
+	if len(args) > 0 {
+		ml = *l.With(args...).(*newLogger)
+	}
+	ml.txID = txID
+	return &ml
+}
+
+// Return a sub-Logger and flush the Flushable outputs when the context is
+// done, the flushing goroutine exits with the context. A context which is
+// never done is not watched.
+func (l *newLogger) WithAutoFlush(ctx context.Context) Logger {
+	sl := *l
+	if done := ctx.Done(); done != nil {
+		go func() {
+			<-done
+			l.flushOutputs()
+		}()
+	}
+	return &sl
+}
+
+// Return the effective options, the colors are the ones resolved for the
+// outputs and the level is the current one
+func (l *newLogger) Config() LoggerOptions {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	opts := LoggerOptions{
+		Name:               l.name,
+		Level:              Level(atomic.LoadInt32(l.level)),
+		JSONFormat:         l.json,
+		JSONNumericLevel:   l.numericLevel,
+		DisableNewline:     l.noNewline,
+		CompactPlain:       l.compact,
+		NameBracketPrefix:  l.nameBracket,
+		SingleSpace:        l.singleSpace,
+		PlainLevel:         l.plainLevel,
+		MaxLineLen:         l.maxLineLen,
+		PanicStacktrace:    l.panicStack,
+		StacktracePrefix:   l.stackPrefix,
+		IncludeLocation:    l.caller,
+		TimeFormat:         l.timeFormat,
+		DisableTime:        l.timeFormat == "",
+		TimeFormatByLevel:  copyTimeFormats(l.timeByLevel),
+		IncludeUptime:      l.uptime,
+		JSONFieldPrefix:    l.fieldPrefix,
+		OrderedJSON:        l.orderedJSON,
+		JSONModuleLeaf:     l.moduleLeaf,
+		StructMaxDepth:     l.structDepth,
+		JSONEntryHash:      l.entryHash,
+		JSONProfile:        l.profile,
+		StacktraceMinLevel: l.stackMin,
+		AtomicWrites:       l.writer.atomic,
+		CountLevels:        l.counts != nil,
+		SummaryOnShutdown:  l.summary,
+		WriteMinLevel:      l.writeMinLevel,
+		DuplicateKeys:      l.duplicateKeys,
+		SyslogFormat:       append([]bool(nil), l.writer.syslog...),
+		Hostname:           l.host,
+		IncludeHostname:    l.host != "",
+		Color:              append([]ColorOption(nil), l.writer.color...),
+	}
+	for p, f := range _precisionToJSONTimeFormat {
+		if f == l.jsonTimeFormat {
+			opts.JSONTimePrecision = p
+		}
+	}
+	if l.deferred != nil {
+		opts.DeferUntilLevel = true
+		opts.DeferBufferSize = l.deferred.max
+	}
+	if l.sampler != nil {
+		opts.SampleRates = make(map[string]int, len(l.sampler.rates))
+		for b, r := range l.sampler.rates {
+			opts.SampleRates[b] = r
+		}
+		opts.DefaultSampleRate = l.sampler.def
+	}
+	if l.bucket != nil {
+		opts.MaxBytesPerSecond = int(l.bucket.rate)
+	}
+	if l.latency != nil {
+		opts.FlushLatencySamples = len(l.latency.samples)
+	}
+	if l.ctxFields != nil {
+		opts.ContextFields = append([]ContextField(nil), l.ctxFields...)
+	}
+	return opts
+}
+
+// Flush the outputs implementing Flushable, the errors go to OnError
+func (l *newLogger) flushOutputs() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, w := range l.writer.w {
+		if f, ok := w.(Flushable); ok {
+			if err := f.Flush(); err != nil && l.writer.onError != nil {
+				l.writer.onError(err)
+			}
+		}
+	}
+}
+
 // Create a new sub-Logger that a name decending from the current name.
 // This is used to create a subsystem specific Logger.
 func (l *newLogger) Named(name string) Logger {
@@ -570,6 +1359,30 @@ func (l *newLogger) Named(name string) Logger {
 	return &sl
 }
 
+// Create a new sub-Logger with the transaction ID ahead of the other fields,
+// it is kept by the further Named and With calls
+func (l *newLogger) WithTxID(txID string) Logger {
+	sl := *l
+	sl.txID = txID
+	return &sl
+}
+
+// Create a new sub-Logger with the category, the sublogger of a sublogger
+// replaces the category
+func (l *newLogger) WithCategory(cat string) Logger {
+	sl := *l
+	sl.category = cat
+	return &sl
+}
+
+// Create a new sub-Logger sampling its entries in the bucket, the sublogger
+// of a sublogger replaces the bucket
+func (l *newLogger) WithSampleBucket(name string) Logger {
+	sl := *l
+	sl.sampleBucket = name
+	return &sl
+}
+
 // Create a new sub-Logger with an explicit name. This ignores the current
 // name. This is used to create a standalone logger that doesn't fall
 // within the normal hierarchy.
@@ -610,8 +1423,17 @@ func (l *newLogger) ResetOutputWithFlush(opts *LoggerOptions, flushable Flushabl
 	return l.resetOutput(opts)
 }
 
+// Replace the writer by one for the outputs of the opts, with the colors,
+// syslog framing and AtomicWrites of the opts. The OnError function, the ring
+// buffer and the attached taps are not of the outputs, they move to the new
+// writer.
 func (l *newLogger) resetOutput(opts *LoggerOptions) error {
-	l.writer = newWriter(opts.Output, opts.Color)
+	old := l.writer
+	l.writer = newWriter(opts.Output, outputColors(opts.Output, opts.Color))
+	l.writer.onError, l.writer.ring = old.onError, old.ring
+	l.writer.taps, l.writer.tapSeq = old.taps, old.tapSeq
+	l.writer.atomic = opts.AtomicWrites
+	l.setSyslog(opts)
 	l.setColorization(opts)
 	return nil
 }
@@ -620,6 +1442,99 @@ func (l *newLogger) resetOutput(opts *LoggerOptions) error {
 // well.
 func (l *newLogger) SetLevel(level Level) {
 	atomic.StoreInt32(l.level, int32(level))
+	l.ReleaseBuffer()
+}
+
+// Return the current logging level, the same for all the subloggers
+func (l *newLogger) GetLevel() Level {
+	return Level(atomic.LoadInt32(l.level))
+}
+
+// Count returns the number of entries logged at the level, including the
+// entries not written due to WriteMinLevel. It is always zero unless
+// CountLevels is set.
+func (l *newLogger) Count(level Level) uint64 {
+	if l.counts == nil || level < 0 || int(level) >= len(l.counts) {
+		return 0
+	}
+	return atomic.LoadUint64(&l.counts[level])
+}
+
+// Dropped returns the number of entries dropped by the MaxBytesPerSecond limit
+func (l *newLogger) Dropped() uint64 {
+	if l.bucket == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&l.bucket.dropped)
+}
+
+// Summary returns the counts of all the levels in a single line, e.g.
+// "trace=0 debug=4 info=10 warn=1 error=0 fatal=0"
+func (l *newLogger) Summary() string {
+	var sb strings.Builder
+	for level := Trace; level <= Fatal; level++ {
+		if level > Trace {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(level.String())
+		sb.WriteByte('=')
+		sb.WriteString(strconv.FormatUint(l.Count(level), 10))
+	}
+	return sb.String()
+}
+
+// Shutdown emits the level summary if SummaryOnShutdown is set, the summary
+// is written irrespective of the level threshold
+func (l *newLogger) Shutdown() {
+	if !l.summary {
+		return
+	}
+	l.write(l.clock(), l.name, Info, false, "log summary: "+l.Summary())
+}
+
+// Set the syslog framing of the outputs
+func (l *newLogger) setSyslog(opts *LoggerOptions) {
+	l.writer.syslog = opts.SyslogFormat
+	l.writer.appName = opts.Name
+	l.writer.hostname = opts.Hostname
+	if l.writer.hostname == "" {
+		l.writer.hostname = osHostname()
+	}
+}
+
+var (
+	_hostname     string
+	_hostnameOnce sync.Once
+)
+
+// The hostname of the OS, looked up once
+func osHostname() string {
+	_hostnameOnce.Do(func() {
+		_hostname, _ = os.Hostname()
+	})
+	return _hostname
+}
+
+// Return the color option of each output, a copy of the options of the
+// caller padded with ColorOff for the outputs without an option. AutoColor
+// is resolved later by setColorization.
+func outputColors(output []io.Writer, color []ColorOption) []ColorOption {
+	colors := make([]ColorOption, len(output))
+	copy(colors, color)
+	return colors
+}
+
+// Report whether the output is a terminal, for AutoColor. The writers which
+// are not files are not terminals unless they implement TerminalWriter.
+func isTerminal(w io.Writer) bool {
+	switch tw := w.(type) {
+	case TerminalWriter:
+		return tw.IsTerminal()
+	case *os.File:
+		return isatty.IsTerminal(tw.Fd()) || isatty.IsCygwinTerminal(tw.Fd())
+	default:
+		return false
+	}
 }
 
 // checks if the underlying io.Writer is a file, and
@@ -639,6 +1554,9 @@ func (i *newLogger) Accept(name string, level Level, msg string, args ...interfa
 
 // ImpliedArgs returns the loggers implied args
 func (i *newLogger) ImpliedArgs() []interface{} {
+	if i.txID != "" {
+		return append([]interface{}{TxIDKey, i.txID}, i.implied...)
+	}
 	return i.implied
 }
 
@@ -653,34 +1571,29 @@ func (i *newLogger) Name() string {
 func (l *newLogger) setColorization(opts *LoggerOptions) {
 	for i, w := range l.writer.w {
 		if runtime.GOOS == "windows" {
-			switch opts.Color[i] {
+			switch l.writer.color[i] {
 			case ColorOff:
-				return
+				continue
 			case ForceColor:
 				fi := l.checkWriterIsFile(w)
 				l.writer.w[i] = colorable.NewColorable(fi)
 			case AutoColor:
-				fi := l.checkWriterIsFile(w)
-				isUnixTerm := isatty.IsTerminal(os.Stdout.Fd())
-				isCygwinTerm := isatty.IsCygwinTerminal(os.Stdout.Fd())
-				isTerm := isUnixTerm || isCygwinTerm
-				if !isTerm {
+				if !isTerminal(w) {
 					l.writer.color[i] = ColorOff
+					continue
+				}
+				if fi, ok := w.(*os.File); ok {
+					l.writer.w[i] = colorable.NewColorable(fi)
 				}
-				l.writer.w[i] = colorable.NewColorable(fi)
 			}
 		} else {
-			switch opts.Color[i] {
-			case ColorOff:
-				fallthrough
-			case ForceColor:
-				return
+			switch l.writer.color[i] {
+			case ColorOff, ForceColor:
+				continue
 			case AutoColor:
-				fi := l.checkWriterIsFile(w)
-				isUnixTerm := isatty.IsTerminal(fi.Fd())
-				isCygwinTerm := isatty.IsCygwinTerminal(fi.Fd())
-				isTerm := isUnixTerm || isCygwinTerm
-				if !isTerm {
+				// Detected for each output again when reset, so that the
+				// color follows an output redirected mid-run
+				if !isTerminal(w) {
 					l.writer.color[i] = ColorOff
 				}
 			}
@@ -0,0 +1,106 @@
+// Package protolog renders protobuf messages compactly for the logger. It is
+// kept apart from the logger package so that the logger does not depend on
+// protobuf.
+package protolog
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Message wraps a protobuf message to be logged, the plain format renders the
+// populated fields as {name=value ...} and the JSON format uses protojson.
+// For example: L.Info("request", "req", protolog.Compact(req))
+type Message struct {
+	m proto.Message
+}
+
+// Compact returns the message wrapped for logging
+func Compact(m proto.Message) Message {
+	return Message{m: m}
+}
+
+// String implements fmt.Stringer
+func (pm Message) String() string {
+	if pm.m == nil {
+		return "<nil>"
+	}
+	var sb strings.Builder
+	writeMessage(&sb, pm.m.ProtoReflect())
+	return sb.String()
+}
+
+// MarshalJSON implements json.Marshaler
+func (pm Message) MarshalJSON() ([]byte, error) {
+	if pm.m == nil {
+		return []byte("null"), nil
+	}
+	return protojson.Marshal(pm.m)
+}
+
+func writeMessage(sb *strings.Builder, m protoreflect.Message) {
+	fds := make([]protoreflect.FieldDescriptor, 0)
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fds = append(fds, fd)
+		return true
+	})
+	sort.Slice(fds, func(i, j int) bool { return fds[i].Number() < fds[j].Number() })
+	sb.WriteByte('{')
+	for i, fd := range fds {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(string(fd.Name()))
+		sb.WriteByte('=')
+		v := m.Get(fd)
+		switch {
+		case fd.IsList():
+			l := v.List()
+			sb.WriteByte('[')
+			for k := 0; k < l.Len(); k++ {
+				if k > 0 {
+					sb.WriteByte(' ')
+				}
+				writeValue(sb, fd, l.Get(k))
+			}
+			sb.WriteByte(']')
+		case fd.IsMap():
+			sb.WriteString("map[")
+			sb.WriteString(strconv.Itoa(v.Map().Len()))
+			sb.WriteByte(']')
+		default:
+			writeValue(sb, fd, v)
+		}
+	}
+	sb.WriteByte('}')
+}
+
+func writeValue(sb *strings.Builder, fd protoreflect.FieldDescriptor, v protoreflect.Value) {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		writeMessage(sb, v.Message())
+	case protoreflect.StringKind:
+		s := v.String()
+		if strings.ContainsAny(s, " \t\n\r") {
+			s = strconv.Quote(s)
+		}
+		sb.WriteString(s)
+	case protoreflect.BytesKind:
+		sb.WriteString("bytes[")
+		sb.WriteString(strconv.Itoa(len(v.Bytes())))
+		sb.WriteByte(']')
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			sb.WriteString(string(ev.Name()))
+		} else {
+			sb.WriteString(strconv.Itoa(int(v.Enum())))
+		}
+	default:
+		sb.WriteString(v.String())
+	}
+}
@@ -0,0 +1,48 @@
+package protolog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rubixchain/rubixgoplatform/protos"
+	"github.com/rubixchain/rubixgoplatform/wrapper/logger"
+)
+
+func TestCompact(t *testing.T) {
+	req := &protos.AccessReq{
+		Did:     "bafybmidid",
+		Payload: &protos.SignedPayload{Payload: "signed", Signature: []byte{1, 2, 3}},
+	}
+	var buf bytes.Buffer
+	l := logger.New(&logger.LoggerOptions{
+		Level:       logger.Info,
+		DisableTime: true,
+		Color:       []logger.ColorOption{logger.ColorOff},
+		Output:      []io.Writer{&buf},
+	})
+	l.Info("access", "req", Compact(req))
+	exp := `req="{did=bafybmidid payload={payload=signed signature=bytes[3]}}"`
+	if !strings.Contains(buf.String(), exp) {
+		t.Fatalf("Invalid compact message %q", buf.String())
+	}
+
+	buf.Reset()
+	l = logger.New(&logger.LoggerOptions{
+		Level:      logger.Info,
+		JSONFormat: true,
+		Color:      []logger.ColorOption{logger.ColorOff},
+		Output:     []io.Writer{&buf},
+	})
+	l.Info("access", "req", Compact(req))
+	var vals map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+		t.Fatal("Failed to parse JSON line", err)
+	}
+	r, ok := vals["req"].(map[string]interface{})
+	if !ok || r["did"] != "bafybmidid" {
+		t.Fatalf("Invalid JSON message %v", vals["req"])
+	}
+}
@@ -0,0 +1,39 @@
+package logger
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// byteBucket is a token bucket of bytes refilled at rate bytes per second,
+// holding at most one second worth of bytes. It is used under the logger
+// mutex, only the dropped counter is read outside of it.
+type byteBucket struct {
+	rate    float64
+	tokens  float64
+	last    time.Time
+	dropped uint64
+}
+
+func newByteBucket(rate int, t time.Time) *byteBucket {
+	return &byteBucket{rate: float64(rate), tokens: float64(rate), last: t}
+}
+
+// take refills the bucket for the time elapsed since the last entry and
+// takes n bytes out of it, the entry is dropped and counted if the bucket
+// does not hold n bytes
+func (b *byteBucket) take(t time.Time, n int) bool {
+	if d := t.Sub(b.last); d > 0 {
+		b.tokens += d.Seconds() * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+		b.last = t
+	}
+	if float64(n) > b.tokens {
+		atomic.AddUint64(&b.dropped, 1)
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
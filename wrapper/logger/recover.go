@@ -0,0 +1,19 @@
+package logger
+
+// RecoverAndLog logs an unrecovered panic with its stack at the ERROR level
+// and panics again with the same value, e.g. defer RecoverAndLog(log) at the
+// entry of a handler or goroutine. The outputs are flushed before panicking
+// again so the entry is not lost when the process crashes.
+func RecoverAndLog(l Logger) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if l != nil {
+		l.Error("Recovered panic", "panic", r, Stacktrace())
+		if nl, ok := l.(*newLogger); ok {
+			nl.flushOutputs()
+		}
+	}
+	panic(r)
+}
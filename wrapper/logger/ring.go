@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"io"
+	"sync"
+)
+
+// RingBufferWriter retains the last K entries written to it in memory, so
+// that they can be dumped for post-mortem debugging. Every Write is treated
+// as one entry.
+type RingBufferWriter struct {
+	l       sync.Mutex
+	entries [][]byte
+	next    int
+	full    bool
+}
+
+// NewRingBufferWriter returns a RingBufferWriter retaining the last k entries
+func NewRingBufferWriter(k int) *RingBufferWriter {
+	if k < 1 {
+		k = 1
+	}
+	return &RingBufferWriter{entries: make([][]byte, k)}
+}
+
+// Write implements io.Writer, the entry is copied
+func (r *RingBufferWriter) Write(p []byte) (int, error) {
+	e := make([]byte, len(p))
+	copy(e, p)
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.entries[r.next] = e
+	r.next++
+	if r.next == len(r.entries) {
+		r.next = 0
+		r.full = true
+	}
+	return len(p), nil
+}
+
+// Dump writes the retained entries to the writer, oldest first
+func (r *RingBufferWriter) Dump(w io.Writer) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	start := 0
+	if r.full {
+		start = r.next
+	}
+	for i := 0; i < len(r.entries); i++ {
+		e := r.entries[(start+i)%len(r.entries)]
+		if e == nil {
+			continue
+		}
+		if _, err := w.Write(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DumpOnPanic dumps the retained entries to the writer if the goroutine is
+// panicking and then continues the panic. It must be deferred directly,
+// e.g. defer ring.DumpOnPanic(os.Stderr)
+func (r *RingBufferWriter) DumpOnPanic(w io.Writer) {
+	if p := recover(); p != nil {
+		r.Dump(w)
+		panic(p)
+	}
+}
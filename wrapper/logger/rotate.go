@@ -0,0 +1,147 @@
+package logger
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// RotatingFileWriter writes to a log file which it rolls over once it would
+// grow past the maximum size, the current file becomes path.1, the previous
+// path.1 becomes path.2 and so on, keeping at most maxFiles rolled files. It
+// is safe for concurrent use and can be set directly as an Output.
+type RotatingFileWriter struct {
+	l        sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	size     int64
+	closed   bool
+	onError  func(err error)
+	// Opens the new file of a rotation, OpenAppendFile unless replaced by
+	// the tests
+	open func(name string) (*os.File, error)
+}
+
+// NewRotatingFileWriter opens the log file at path for appending, creating
+// it if needed. A maxFiles below 1 keeps a single rolled file.
+func NewRotatingFileWriter(path string, maxSize int64, maxFiles int) (*RotatingFileWriter, error) {
+	if maxSize <= 0 {
+		return nil, errors.New("rotating file writer needs a positive max size")
+	}
+	if maxFiles < 1 {
+		maxFiles = 1
+	}
+	f, err := OpenAppendFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &RotatingFileWriter{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		f:        f,
+		size:     fi.Size(),
+		open:     OpenAppendFile,
+	}, nil
+}
+
+// SetOnError sets the function called with the errors which do not fail the
+// write, like a failure to close the rolled file
+func (rw *RotatingFileWriter) SetOnError(fn func(err error)) {
+	rw.l.Lock()
+	defer rw.l.Unlock()
+	rw.onError = fn
+}
+
+func (rw *RotatingFileWriter) report(err error) {
+	if rw.onError != nil {
+		rw.onError(err)
+	}
+}
+
+// Write implements io.Writer, the file is rolled over first if p would take
+// it past the maximum size. An entry larger than the maximum size is written
+// whole to a new file.
+func (rw *RotatingFileWriter) Write(p []byte) (int, error) {
+	rw.l.Lock()
+	defer rw.l.Unlock()
+	if rw.closed {
+		return 0, ErrWriterClosed
+	}
+	if rw.size > 0 && rw.size+int64(len(p)) > rw.maxSize {
+		if err := rw.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rw.f.Write(p)
+	rw.size += int64(n)
+	return n, err
+}
+
+// The name of the i-th rolled file
+func (rw *RotatingFileWriter) rolled(i int) string {
+	return rw.path + "." + strconv.Itoa(i)
+}
+
+// Shift the rolled files and swap the current file for a new one, the lock
+// must be held. The current file is kept if the rotation fails, the next
+// write retries it.
+func (rw *RotatingFileWriter) rotate() error {
+	err := os.Remove(rw.rolled(rw.maxFiles))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := rw.maxFiles - 1; i >= 1; i-- {
+		err := os.Rename(rw.rolled(i), rw.rolled(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(rw.path, rw.rolled(1)); err != nil {
+		return err
+	}
+	f, err := rw.open(rw.path)
+	if err != nil {
+		// The current file goes back to the path, it is still the one open
+		if rerr := os.Rename(rw.rolled(1), rw.path); rerr != nil {
+			rw.report(rerr)
+		}
+		return err
+	}
+	old := rw.f
+	rw.f = f
+	rw.size = 0
+	// The file is rolled over, failing to close the old one does not fail
+	// the write
+	if err := old.Close(); err != nil {
+		rw.report(err)
+	}
+	return nil
+}
+
+// Close closes the current file, the entries written afterwards fail with
+// ErrWriterClosed
+func (rw *RotatingFileWriter) Close() error {
+	rw.l.Lock()
+	defer rw.l.Unlock()
+	if rw.closed {
+		return nil
+	}
+	rw.closed = true
+	return rw.f.Close()
+}
+
+// IsClosed reports whether Close was called
+func (rw *RotatingFileWriter) IsClosed() bool {
+	rw.l.Lock()
+	defer rw.l.Unlock()
+	return rw.closed
+}
@@ -0,0 +1,63 @@
+package logger
+
+import "io"
+
+// CategoryWriter is the interface that wraps the CategoryWrite method, the
+// outputs implementing it get the category set by WithCategory with each
+// entry, empty for the entries without category.
+type CategoryWriter interface {
+	CategoryWrite(level Level, category string, p []byte) (n int, err error)
+}
+
+// RouterWriter writes the entries to the writer of their category, else to
+// the writer of their level, else to the standard writer.
+type RouterWriter struct {
+	standard   io.Writer
+	levels     map[Level]io.Writer
+	categories map[string]io.Writer
+}
+
+// NewRouterWriter returns a RouterWriter writing all the entries to the
+// standard writer until routes are added
+func NewRouterWriter(standard io.Writer) *RouterWriter {
+	return &RouterWriter{
+		standard:   standard,
+		levels:     make(map[Level]io.Writer),
+		categories: make(map[string]io.Writer),
+	}
+}
+
+// RouteLevel routes the entries of the level to w, it is not safe to call
+// once the writer is in use
+func (rw *RouterWriter) RouteLevel(level Level, w io.Writer) *RouterWriter {
+	rw.levels[level] = w
+	return rw
+}
+
+// RouteCategory routes the entries of the category to w, it is not safe to
+// call once the writer is in use
+func (rw *RouterWriter) RouteCategory(category string, w io.Writer) *RouterWriter {
+	rw.categories[category] = w
+	return rw
+}
+
+// Write implements io.Writer.
+func (rw *RouterWriter) Write(p []byte) (int, error) {
+	return rw.standard.Write(p)
+}
+
+// LevelWrite implements LevelWriter.
+func (rw *RouterWriter) LevelWrite(level Level, p []byte) (int, error) {
+	return rw.CategoryWrite(level, "", p)
+}
+
+// CategoryWrite implements CategoryWriter.
+func (rw *RouterWriter) CategoryWrite(level Level, category string, p []byte) (int, error) {
+	if w, ok := rw.categories[category]; ok && category != "" {
+		return w.Write(p)
+	}
+	if w, ok := rw.levels[level]; ok {
+		return w.Write(p)
+	}
+	return rw.standard.Write(p)
+}
@@ -0,0 +1,86 @@
+package logger
+
+import "sync"
+
+// NewFirstNThenSample returns an Exclude function that lets the first n
+// occurrences of a message through and then only one in every m of them.
+// The occurrences are counted per message irrespective of the level and
+// args. A non positive m excludes every occurrence after the first n.
+func NewFirstNThenSample(n, m int) func(level Level, msg string, args ...interface{}) bool {
+	var l sync.Mutex
+	seen := make(map[string]int)
+	return func(level Level, msg string, args ...interface{}) bool {
+		l.Lock()
+		c := seen[msg] + 1
+		seen[msg] = c
+		l.Unlock()
+		if c <= n {
+			return false
+		}
+		if m <= 0 {
+			return true
+		}
+		return (c-n)%m != 0
+	}
+}
+
+// CombineExcludes returns an Exclude function excluding the entries which
+// any of fns excludes. The functions are called in order until one returns
+// true, so the stateful ones such as NewFirstNThenSample only count the
+// entries the previous ones let through. The nil functions are skipped.
+func CombineExcludes(fns ...func(level Level, msg string, args ...interface{}) bool) func(level Level, msg string, args ...interface{}) bool {
+	fns = append([]func(level Level, msg string, args ...interface{}) bool(nil), fns...)
+	return func(level Level, msg string, args ...interface{}) bool {
+		for _, fn := range fns {
+			if fn != nil && fn(level, msg, args...) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// NegateExclude returns an Exclude function excluding the entries which fn
+// lets through, e.g. to keep only the entries matching a rule
+func NegateExclude(fn func(level Level, msg string, args ...interface{}) bool) func(level Level, msg string, args ...interface{}) bool {
+	return func(level Level, msg string, args ...interface{}) bool {
+		return !fn(level, msg, args...)
+	}
+}
+
+// bucketSampler keeps one entry in every rate entries of each sample bucket,
+// the first entry of a bucket is kept
+type bucketSampler struct {
+	l     sync.Mutex
+	rates map[string]int
+	def   int
+	seen  map[string]int
+}
+
+func newBucketSampler(rates map[string]int, def int) *bucketSampler {
+	s := &bucketSampler{
+		rates: make(map[string]int, len(rates)),
+		def:   def,
+		seen:  make(map[string]int),
+	}
+	for b, r := range rates {
+		s.rates[b] = r
+	}
+	return s
+}
+
+// keep counts the entry in the bucket and reports whether it is sampled
+func (s *bucketSampler) keep(bucket string) bool {
+	rate, ok := s.rates[bucket]
+	if !ok {
+		rate = s.def
+	}
+	if rate <= 1 {
+		return true
+	}
+	s.l.Lock()
+	c := s.seen[bucket]
+	s.seen[bucket] = c + 1
+	s.l.Unlock()
+	return c%rate == 0
+}
@@ -0,0 +1,111 @@
+//go:build go1.21
+// +build go1.21
+
+package logger
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogHandler routes the log/slog records to a Logger, the group is the key
+// prefix of the attributes added under WithGroup
+type slogHandler struct {
+	l     Logger
+	group string
+}
+
+// NewSlogHandler returns a slog.Handler writing the records to the logger,
+// e.g. slog.New(NewSlogHandler(l)). The slog levels below Debug map to Trace
+// and the ones above Error to Error, the attributes of the groups are keyed
+// by their dotted path.
+func NewSlogHandler(l Logger) slog.Handler {
+	return &slogHandler{l: l}
+}
+
+// The level of the slog level
+func slogLevel(level slog.Level) Level {
+	switch {
+	case level < slog.LevelDebug:
+		return Trace
+	case level < slog.LevelInfo:
+		return Debug
+	case level < slog.LevelWarn:
+		return Info
+	case level < slog.LevelError:
+		return Warn
+	default:
+		return Error
+	}
+}
+
+// Enabled implements slog.Handler.
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	switch slogLevel(level) {
+	case Trace:
+		return h.l.IsTrace()
+	case Debug:
+		return h.l.IsDebug()
+	case Info:
+		return h.l.IsInfo()
+	case Warn:
+		return h.l.IsWarn()
+	default:
+		return h.l.IsError()
+	}
+}
+
+// Handle implements slog.Handler.
+func (h *slogHandler) Handle(_ context.Context, r slog.Record) error {
+	args := make([]interface{}, 0, 2*r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		args = appendSlogAttr(args, h.group, a)
+		return true
+	})
+	h.l.Log(slogLevel(r.Level), r.Message, args...)
+	return nil
+}
+
+// WithAttrs implements slog.Handler.
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	args := make([]interface{}, 0, 2*len(attrs))
+	for _, a := range attrs {
+		args = appendSlogAttr(args, h.group, a)
+	}
+	return &slogHandler{l: h.l.With(args...), group: h.group}
+}
+
+// WithGroup implements slog.Handler.
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &slogHandler{l: h.l, group: h.group + name + "."}
+}
+
+// Append the attribute as key/value pairs, the groups are flattened with
+// the dotted keys and the empty attributes are dropped as slog does
+func appendSlogAttr(args []interface{}, prefix string, a slog.Attr) []interface{} {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		attrs := v.Group()
+		if len(attrs) == 0 {
+			return args
+		}
+		// A group without key is inlined
+		if a.Key != "" {
+			prefix = prefix + a.Key + "."
+		}
+		for _, ga := range attrs {
+			args = appendSlogAttr(args, prefix, ga)
+		}
+		return args
+	}
+	if a.Key == "" && v.Any() == nil {
+		return args
+	}
+	return append(args, prefix+a.Key, v.Any())
+}
@@ -0,0 +1,54 @@
+//go:build go1.21
+// +build go1.21
+
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestSlogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&LoggerOptions{
+		Name:        "app",
+		Level:       Info,
+		DisableTime: true,
+		Color:       []ColorOption{ColorOff},
+		Output:      []io.Writer{&buf},
+	})
+	sl := slog.New(NewSlogHandler(l))
+	sl.Debug("hidden")
+	sl.Info("started", "port", 20000)
+	sl.With("did", "d1").WithGroup("req").Warn("slow", "ms", 250, slog.Group("peer", "id", "p1"))
+	sl.Error("failed", slog.Group("", "inline", true), slog.Group("empty"))
+	exp := "[INFO]  app: started: port=20000\n" +
+		"[WARN]  app: slow: did=d1 req.ms=250 req.peer.id=p1\n" +
+		"[ERROR] app: failed: inline=true\n"
+	if buf.String() != exp {
+		t.Fatalf("Expected %q, got %q", exp, buf.String())
+	}
+	if sl.Enabled(context.Background(), slog.LevelDebug) || !sl.Enabled(context.Background(), slog.LevelInfo) || !sl.Enabled(context.Background(), slog.LevelError+4) {
+		t.Fatal("Expected Enabled to follow the level of the logger")
+	}
+
+	buf.Reset()
+	l = New(&LoggerOptions{
+		Level:      Trace,
+		JSONFormat: true,
+		Color:      []ColorOption{ColorOff},
+		Output:     []io.Writer{&buf},
+	})
+	slog.New(NewSlogHandler(l)).WithGroup("tx").Log(context.Background(), slog.LevelDebug-4, "trace entry", "id", "t1")
+	var vals map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &vals); err != nil {
+		t.Fatal("Invalid JSON", err)
+	}
+	if vals["@level"] != "trace" || vals["@message"] != "trace entry" || vals["tx.id"] != "t1" {
+		t.Fatalf("Unexpected JSON entry %v", vals)
+	}
+}
@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+)
+
+// StandardLoggerOptions configures the io.Writer and the *log.Logger
+// returned by StandardWriter and StandardLogger
+type StandardLoggerOptions struct {
+	// The level of the lines without a [LEVEL] prefix, Info if not set
+	Level Level
+}
+
+// stdlogAdapter writes the lines of the standard library loggers to the
+// logger, at the level of their [LEVEL] prefix if any
+type stdlogAdapter struct {
+	log   Logger
+	level Level
+}
+
+// Return an io.Writer logging each write as an entry, e.g. for the third
+// party code taking a writer
+func (l *newLogger) StandardWriter(opts *StandardLoggerOptions) io.Writer {
+	if opts == nil {
+		opts = &StandardLoggerOptions{}
+	}
+	level := opts.Level
+	if level == NoLevel {
+		level = Info
+	}
+	return &stdlogAdapter{log: l, level: level}
+}
+
+// Return a *log.Logger writing to the logger, e.g. for http.Server.ErrorLog
+func (l *newLogger) StandardLogger(opts *StandardLoggerOptions) *log.Logger {
+	return log.New(l.StandardWriter(opts), "", 0)
+}
+
+// Write implements io.Writer, the entry is logged at the level of the prefix
+// like [ERROR] which is removed from the message
+func (s *stdlogAdapter) Write(p []byte) (int, error) {
+	msg := string(bytes.TrimRight(p, " \r\n"))
+	level, msg := stdlogLevel(msg, s.level)
+	s.log.Log(level, msg)
+	return len(p), nil
+}
+
+// Parse the [LEVEL] prefix of the line, the level is def if there is no
+// known level prefix
+func stdlogLevel(line string, def Level) (Level, string) {
+	if !strings.HasPrefix(line, "[") {
+		return def, line
+	}
+	end := strings.IndexByte(line, ']')
+	if end < 0 {
+		return def, line
+	}
+	name := line[1:end]
+	var level Level
+	switch strings.ToLower(name) {
+	case "warning":
+		level = Warn
+	case "err":
+		level = Error
+	default:
+		level = LevelFromString(name)
+	}
+	if level == NoLevel || level == Off {
+		return def, line
+	}
+	return level, strings.TrimLeft(line[end+1:], " ")
+}
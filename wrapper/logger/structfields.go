@@ -0,0 +1,91 @@
+package logger
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// DefaultStructMaxDepth is the nesting depth flattened by WithStruct when
+// StructMaxDepth is not set
+const DefaultStructMaxDepth = 3
+
+// Return a sub-Logger with the exported fields of the struct as implied
+// args, see structArgs
+func (l *newLogger) WithStruct(prefix string, v interface{}) Logger {
+	depth := l.structDepth
+	if depth <= 0 {
+		depth = DefaultStructMaxDepth
+	}
+	return l.With(structArgs(prefix, v, depth)...)
+}
+
+// structArgs flattens the exported fields of the struct into key/value pairs
+// with dotted keys, named by the json tag of the field if any. The structs
+// nested deeper than maxDepth are rendered with %v as a single field, and a
+// pointer back to a struct being flattened is rendered as <cycle>. A value
+// which is not a struct is returned as the single pair prefix=v, keyed value
+// when the prefix is empty.
+func structArgs(prefix string, v interface{}, maxDepth int) []interface{} {
+	args := make([]interface{}, 0)
+	flattenStruct(&args, prefix, reflect.ValueOf(v), 0, maxDepth, make(map[uintptr]bool))
+	return args
+}
+
+func flattenStruct(args *[]interface{}, key string, v reflect.Value, depth int, maxDepth int, seen map[uintptr]bool) {
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			flattenStruct(args, key, reflect.Value{}, depth, maxDepth, seen)
+			return
+		}
+		if v.Kind() == reflect.Ptr {
+			p := v.Pointer()
+			if seen[p] {
+				*args = append(*args, key, "<cycle>")
+				return
+			}
+			// Only the pointers on the current path are cycles, a struct
+			// referenced twice from siblings is flattened twice
+			seen[p] = true
+			defer delete(seen, p)
+		}
+		flattenStruct(args, key, v.Elem(), depth, maxDepth, seen)
+		return
+	}
+	if v.Kind() != reflect.Struct {
+		if key == "" {
+			key = "value"
+		}
+		if !v.IsValid() {
+			*args = append(*args, key, nil)
+		} else {
+			*args = append(*args, key, v.Interface())
+		}
+		return
+	}
+	if depth >= maxDepth {
+		*args = append(*args, key, fmt.Sprintf("%v", v.Interface()))
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			tag = strings.Split(tag, ",")[0]
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		if key != "" {
+			name = key + "." + name
+		}
+		flattenStruct(args, name, v.Field(i), depth+1, maxDepth, seen)
+	}
+}
@@ -0,0 +1,49 @@
+package logger
+
+import (
+	"io"
+	"sync"
+)
+
+type tap struct {
+	id int
+	w  io.Writer
+}
+
+// Write the entry to the taps, the taps get the plain text of the entry
+func (w *writer) flushTaps(plain []byte) (err error) {
+	for _, t := range w.taps {
+		if werr := w.writeEntry(t.w, plain); werr != nil {
+			err = werr
+			if w.onError != nil {
+				w.onError(werr)
+			}
+		}
+	}
+	return err
+}
+
+// Write the entries logged from now on to the writer as well, in the format
+// of the outputs, until the returned function is called. The tap is shared
+// with the sub-loggers, detaching more than once has no effect.
+func (l *newLogger) AttachTap(w io.Writer) (detach func()) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.writer.tapSeq++
+	id := l.writer.tapSeq
+	l.writer.taps = append(l.writer.taps, tap{id: id, w: w})
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			taps := make([]tap, 0, len(l.writer.taps))
+			for _, t := range l.writer.taps {
+				if t.id != id {
+					taps = append(taps, t)
+				}
+			}
+			l.writer.taps = taps
+		})
+	}
+}
@@ -3,12 +3,57 @@ package logger
 import (
 	"bytes"
 	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
+const ansiReset = "\x1b[0m"
+
+// SyslogTimeFormat is the RFC5424 timestamp format, limited to microseconds
+const SyslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+const syslogFacilityUser = 1
+
+var _levelToSyslogSeverity = map[Level]int{
+	Trace: 7,
+	Debug: 7,
+	Info:  6,
+	Warn:  4,
+	Error: 3,
+	Fatal: 2,
+}
+
+// Matches the ANSI SGR sequences, stripped for the outputs without coloring
+var _ansiSequence = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// TruncateMarker is appended to the plain lines cut by MaxLineLen
+const TruncateMarker = "…"
+
 type writer struct {
-	b     bytes.Buffer
-	w     []io.Writer
-	color []ColorOption
+	b       bytes.Buffer
+	w       []io.Writer
+	color   []ColorOption
+	onError func(err error)
+	ring    *RingBufferWriter
+	atomic  bool
+
+	// The writers attached with AttachTap
+	taps   []tap
+	tapSeq int
+
+	// RFC5424 framing of the outputs marked in syslog, t is the time of the
+	// entry being flushed
+	syslog   []bool
+	hostname string
+	appName  string
+	t        time.Time
+
+	// The category of the entry being flushed, for the CategoryWriter
+	category string
 }
 
 func newWriter(w []io.Writer, color []ColorOption) *writer {
@@ -18,29 +63,188 @@ func newWriter(w []io.Writer, color []ColorOption) *writer {
 func (w *writer) Flush(level Level) (err error) {
 	var unwritten = w.b.Bytes()
 
+	// The plain text for the outputs without coloring
+	var plain = unwritten
+	if bytes.IndexByte(unwritten, 27) >= 0 {
+		plain = _ansiSequence.ReplaceAll(unwritten, nil)
+	}
+
+	if w.ring != nil {
+		w.ring.Write(plain)
+	}
+
+	if len(w.taps) > 0 {
+		err = w.flushTaps(plain)
+	}
+
 	for i, wr := range w.w {
-		if lw, ok := wr.(LevelWriter); ok {
-			_, err = lw.LevelWrite(level, unwritten)
-		} else {
-			l := len(unwritten)
-			if unwritten[0] == 27 {
-				unwritten = unwritten[5 : l-4]
+		var werr error
+		if cw, ok := wr.(ClosedWriter); ok && cw.IsClosed() {
+			werr = ErrWriterClosed
+		} else if i < len(w.syslog) && w.syslog[i] {
+			werr = w.writeEntry(wr, w.syslogFrame(level, plain))
+		} else if cw, ok := wr.(CategoryWriter); ok {
+			if w.color[i] != ColorOff {
+				_, werr = cw.CategoryWrite(level, w.category, unwritten)
+			} else {
+				_, werr = cw.CategoryWrite(level, w.category, plain)
+			}
+		} else if lw, ok := wr.(LevelWriter); ok {
+			if w.color[i] != ColorOff {
+				_, werr = lw.LevelWrite(level, unwritten)
+			} else {
+				_, werr = lw.LevelWrite(level, plain)
 			}
+		} else {
 			if w.color[i] != ColorOff {
-				color := _levelToColor[level]
-				colorbytes := []byte(color.Sprintf("%s", unwritten))
-				_, err = wr.Write(colorbytes)
+				werr = w.writeEntry(wr, colorLine(level, unwritten))
 			} else {
-				_, err = wr.Write(unwritten)
+				werr = w.writeEntry(wr, plain)
+			}
+
+		}
+		if werr != nil {
+			err = werr
+			if w.onError != nil {
+				w.onError(werr)
 			}
+		}
+	}
+
+	w.b.Reset()
+	return err
+}
 
+// Cut the buffered lines longer than max bytes on a UTF-8 boundary, so that
+// the line including the TruncateMarker fits in max bytes
+func (w *writer) truncateLines(max int) {
+	if w.b.Len() <= max {
+		return
+	}
+	lines := bytes.SplitAfter(w.b.Bytes(), []byte("\n"))
+	var out bytes.Buffer
+	for _, line := range lines {
+		nl := bytes.HasSuffix(line, []byte("\n"))
+		content := bytes.TrimSuffix(line, []byte("\n"))
+		if len(content) > max {
+			n := max - len(TruncateMarker)
+			if n < 0 {
+				n = 0
+			}
+			for n > 0 && !utf8.RuneStart(content[n]) {
+				n--
+			}
+			out.Write(content[:n])
+			out.WriteString(TruncateMarker)
+		} else {
+			out.Write(content)
+		}
+		if nl {
+			out.WriteByte('\n')
 		}
 	}
+	w.b = out
+}
+
+// Prefix the entry with the RFC5424 header, the facility is user-level
+func (w *writer) syslogFrame(level Level, msg []byte) []byte {
+	sev, ok := _levelToSyslogSeverity[level]
+	if !ok {
+		sev = 5
+	}
+	var b bytes.Buffer
+	b.WriteByte('<')
+	b.WriteString(strconv.Itoa(syslogFacilityUser*8 + sev))
+	b.WriteString(">1 ")
+	b.WriteString(w.t.Format(SyslogTimeFormat))
+	b.WriteByte(' ')
+	b.WriteString(syslogField(w.hostname))
+	b.WriteByte(' ')
+	b.WriteString(syslogField(w.appName))
+	b.WriteByte(' ')
+	b.WriteString(strconv.Itoa(os.Getpid()))
+	b.WriteString(" - - ")
+	b.Write(msg)
+	return b.Bytes()
+}
 
+// The header fields can not be empty or have spaces
+func syslogField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(s, " ", "_")
+}
+
+// Color the line with the level color, the inline resets of the field colors
+// are followed by the level color again so that the rest of the line keeps it
+func colorLine(level Level, line []byte) []byte {
+	colored := _levelToColor[level].Sprintf("%s", line)
+	if !strings.HasPrefix(colored, "\x1b[") || !bytes.Contains(line, []byte(ansiReset)) {
+		return []byte(colored)
+	}
+	prefix := colored[:strings.IndexByte(colored, 'm')+1]
+	inner := strings.ReplaceAll(string(line), ansiReset, ansiReset+prefix)
+	return []byte(prefix + inner + ansiReset)
+}
+
+// Drop the newline terminating the buffered entry
+func (w *writer) trimNewline() {
+	if n := w.b.Len(); n > 0 && w.b.Bytes()[n-1] == '\n' {
+		w.b.Truncate(n - 1)
+	}
+}
+
+// Keep the buffered entry only in the ring buffer
+func (w *writer) flushRing() {
+	w.ring.Write(w.b.Bytes())
 	w.b.Reset()
+}
+
+// OpenAppendFile opens the log file with O_APPEND for use with AtomicWrites
+func OpenAppendFile(name string) (*os.File, error) {
+	return os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+// Write the entry to the output, with AtomicWrites in a single Write call
+// failing on a short write rather than writing the rest on its own
+func (w *writer) writeEntry(wr io.Writer, p []byte) error {
+	if !w.atomic {
+		return writeFull(wr, p)
+	}
+	n, err := wr.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
 	return err
 }
 
+// Write the whole buffer, retrying the remainder on a short write. A writer
+// making no progress results in io.ErrShortWrite.
+func writeFull(wr io.Writer, p []byte) error {
+	for len(p) > 0 {
+		n, err := wr.Write(p)
+		if err != nil {
+			return err
+		}
+		if n <= 0 {
+			return io.ErrShortWrite
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// Report whether any of the outputs is colored
+func (w *writer) colored() bool {
+	for _, c := range w.color {
+		if c != ColorOff {
+			return true
+		}
+	}
+	return false
+}
+
 func (w *writer) Write(p []byte) (int, error) {
 	return w.b.Write(p)
 }